@@ -0,0 +1,41 @@
+package adapter
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/w-woong/auth/entity"
+)
+
+// AuditFileSink appends audit events to a file as json lines.
+type AuditFileSink struct {
+	f *os.File
+	l sync.Mutex
+}
+
+func NewAuditFileSink(name string) (*AuditFileSink, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditFileSink{
+		f: f,
+	}, nil
+}
+
+func (a *AuditFileSink) Write(event entity.AuditEvent) error {
+	b, err := json.Marshal(&event)
+	if err != nil {
+		return err
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+	_, err = a.f.Write(append(b, '\n'))
+	return err
+}
+
+func (a *AuditFileSink) Close() error {
+	return a.f.Close()
+}
@@ -0,0 +1,55 @@
+package adapter
+
+import (
+	"context"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/common"
+	"github.com/w-woong/common/txcom"
+	"gorm.io/gorm"
+)
+
+type auditPg struct {
+	db *gorm.DB
+}
+
+func NewAuditPg(db *gorm.DB) *auditPg {
+	return &auditPg{
+		db: db,
+	}
+}
+
+func (a *auditPg) Create(ctx context.Context, tx common.TxController, event entity.AuditEvent) (int64, error) {
+	res := tx.(*txcom.GormTxController).Tx.WithContext(ctx).Create(&event)
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return 0, txcom.ConvertErr(res.Error)
+	}
+	return event.ID, nil
+}
+
+func (a *auditPg) Find(ctx context.Context, filter port.AuditFilter) ([]entity.AuditEvent, error) {
+	db := a.db.WithContext(ctx)
+	if filter.Subject != "" {
+		db = db.Where("subject = ?", filter.Subject)
+	}
+	if filter.Type != "" {
+		db = db.Where("type = ?", filter.Type)
+	}
+	if filter.TokenID != "" {
+		db = db.Where("token_id = ?", filter.TokenID)
+	}
+	if filter.Before > 0 {
+		db = db.Where("id < ?", filter.Before)
+	}
+
+	events := make([]entity.AuditEvent, 0)
+	res := db.Order("id desc").Limit(filter.Limit).Find(&events)
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return nil, txcom.ConvertErr(res.Error)
+	}
+	return events, nil
+}
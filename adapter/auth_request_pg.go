@@ -4,8 +4,8 @@ import (
 	"context"
 
 	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
 	"github.com/w-woong/common"
-	"github.com/w-woong/common/logger"
 	"github.com/w-woong/common/txcom"
 	"gorm.io/gorm"
 )
@@ -25,7 +25,7 @@ func (a *authRequestPg) Create(ctx context.Context, tx common.TxController, auth
 	res := tx.(*txcom.GormTxController).Tx.WithContext(ctx).Create(&authRequest)
 	if res.Error != nil {
 		logger.Error(res.Error.Error())
-		return 0, txcom.ConvertErr(res.Error)
+		return 0, convertErr(res.Error)
 	}
 
 	return res.RowsAffected, nil
@@ -47,6 +47,18 @@ func (a *authRequestPg) Delete(ctx context.Context, tx common.TxController, id s
 	return res.RowsAffected, nil
 }
 
+func (a *authRequestPg) UpdateResponseUrl(ctx context.Context, tx common.TxController, id, responseUrl string) (int64, error) {
+	res := tx.(*txcom.GormTxController).Tx.
+		WithContext(ctx).
+		Model(&entity.AuthRequest{ID: id}).
+		Update("response_url", responseUrl)
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return 0, convertErr(res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
 func (a *authRequestPg) readAuthRequest(ctx context.Context, db *gorm.DB, id string) (entity.AuthRequest, error) {
 	authRequest := entity.AuthRequest{}
 	res := db.WithContext(ctx).
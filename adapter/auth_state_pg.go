@@ -4,8 +4,8 @@ import (
 	"context"
 
 	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
 	"github.com/w-woong/common"
-	"github.com/w-woong/common/logger"
 	"github.com/w-woong/common/txcom"
 	"gorm.io/gorm"
 )
@@ -25,7 +25,7 @@ func (a *authStatePg) Create(ctx context.Context, tx common.TxController, authSt
 	res := tx.(*txcom.GormTxController).Tx.WithContext(ctx).Create(&authState)
 	if res.Error != nil {
 		logger.Error(res.Error.Error())
-		return 0, txcom.ConvertErr(res.Error)
+		return 0, convertErr(res.Error)
 	}
 
 	return res.RowsAffected, nil
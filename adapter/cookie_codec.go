@@ -0,0 +1,157 @@
+package adapter
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCookieMalformed  = errors.New("malformed cookie")
+	ErrCookieKeyUnknown = errors.New("unknown or retired cookie key")
+	ErrCookieTampered   = errors.New("cookie is tampered")
+	ErrCookieStale      = errors.New("cookie is stale")
+)
+
+const (
+	cookieCodecVersion = "v1"
+	// allowed clock skew between instances
+	cookieIssuedAtSkew = 1 * time.Minute
+)
+
+// CookieKey is a key of AesGcmCookieCodec. Key must be 16, 24 or 32 bytes.
+// A retired key still decodes cookies until RetiredAt plus the grace period of the codec.
+type CookieKey struct {
+	ID        string
+	Key       []byte
+	RetiredAt time.Time
+}
+
+type cookieKey struct {
+	aead      cipher.AEAD
+	retiredAt time.Time
+}
+
+// AesGcmCookieCodec encrypts and authenticates cookie values with AES-GCM. Values are bound to
+// their cookie name and carry the time they were issued at.
+type AesGcmCookieCodec struct {
+	mu          sync.RWMutex
+	primaryID   string
+	keys        map[string]cookieKey
+	maxAge      time.Duration
+	gracePeriod time.Duration
+	now         func() time.Time
+}
+
+// NewAesGcmCookieCodec creates a codec encoding with the first key of keys. Others are retired
+// keys, accepted for gracePeriod after they were retired. Cookies older than maxAge are rejected.
+func NewAesGcmCookieCodec(keys []CookieKey, maxAge, gracePeriod time.Duration) (*AesGcmCookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("cookie codec requires at least one key")
+	}
+
+	c := &AesGcmCookieCodec{
+		primaryID:   keys[0].ID,
+		keys:        make(map[string]cookieKey),
+		maxAge:      maxAge,
+		gracePeriod: gracePeriod,
+		now:         time.Now,
+	}
+	for i, key := range keys {
+		if key.ID == "" || strings.Contains(key.ID, ".") {
+			return nil, errors.New("cookie key id must not be empty or contain '.'")
+		}
+		if _, ok := c.keys[key.ID]; ok {
+			return nil, errors.New("duplicate cookie key id " + key.ID)
+		}
+		if i > 0 && key.RetiredAt.IsZero() {
+			return nil, errors.New("retired cookie key " + key.ID + " requires retired_at")
+		}
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys[key.ID] = cookieKey{aead: aead, retiredAt: key.RetiredAt}
+	}
+	return c, nil
+}
+
+// Replace replaces keys, maxAge and gracePeriod with those of loaded, which is validated by
+// NewAesGcmCookieCodec. Cookies of keys which are not in loaded can no longer be decoded.
+func (c *AesGcmCookieCodec) Replace(loaded *AesGcmCookieCodec) {
+	loaded.mu.RLock()
+	defer loaded.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.primaryID = loaded.primaryID
+	c.keys = loaded.keys
+	c.maxAge = loaded.maxAge
+	c.gracePeriod = loaded.gracePeriod
+}
+
+func (c *AesGcmCookieCodec) Encode(name, value string) (string, error) {
+	c.mu.RLock()
+	primaryID, key := c.primaryID, c.keys[c.primaryID]
+	c.mu.RUnlock()
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	plain := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(plain, uint64(c.now().Unix()))
+	copy(plain[8:], value)
+
+	sealed := key.aead.Seal(nonce, nonce, plain, []byte(name))
+	return cookieCodecVersion + "." + primaryID + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *AesGcmCookieCodec) Decode(name, encoded string) (string, error) {
+	parts := strings.SplitN(encoded, ".", 3)
+	if len(parts) != 3 || parts[0] != cookieCodecVersion {
+		return "", ErrCookieMalformed
+	}
+
+	now := c.now()
+	c.mu.RLock()
+	key, ok := c.keys[parts[1]]
+	maxAge, gracePeriod := c.maxAge, c.gracePeriod
+	c.mu.RUnlock()
+	if !ok {
+		return "", ErrCookieKeyUnknown
+	}
+	if !key.retiredAt.IsZero() && now.After(key.retiredAt.Add(gracePeriod)) {
+		return "", ErrCookieKeyUnknown
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", ErrCookieMalformed
+	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	plain, err := key.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil || len(plain) < 8 {
+		return "", ErrCookieTampered
+	}
+
+	issuedAt := time.Unix(int64(binary.BigEndian.Uint64(plain)), 0)
+	if issuedAt.After(now.Add(cookieIssuedAtSkew)) {
+		return "", ErrCookieStale
+	}
+	if maxAge > 0 && now.After(issuedAt.Add(maxAge)) {
+		return "", ErrCookieStale
+	}
+
+	return string(plain[8:]), nil
+}
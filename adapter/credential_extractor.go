@@ -0,0 +1,134 @@
+package adapter
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/w-woong/auth/port"
+)
+
+// TokenCookieExtractor extracts credentials through a port.TokenCookie, such as TokenCookie,
+// TokenHeader or a session cookie.
+type TokenCookieExtractor struct {
+	name   string
+	cookie port.TokenCookie
+}
+
+func NewTokenCookieExtractor(name string, cookie port.TokenCookie) *TokenCookieExtractor {
+	return &TokenCookieExtractor{
+		name:   name,
+		cookie: cookie,
+	}
+}
+
+func (a *TokenCookieExtractor) Name() string {
+	return a.name
+}
+
+func (a *TokenCookieExtractor) Extract(r *http.Request) port.Credential {
+	return port.Credential{
+		TokenIdentifier: a.cookie.GetTokenIdentifier(r),
+		IDToken:         a.cookie.GetIDToken(r),
+		TokenSource:     a.cookie.GetTokenSource(r),
+	}
+}
+
+// BearerExtractor extracts id_token from "Authorization: Bearer".
+type BearerExtractor struct {
+}
+
+func NewBearerExtractor() *BearerExtractor {
+	return &BearerExtractor{}
+}
+
+func (a *BearerExtractor) Name() string {
+	return "bearer"
+}
+
+func (a *BearerExtractor) Extract(r *http.Request) port.Credential {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return port.Credential{}
+	}
+	return port.Credential{IDToken: strings.TrimSpace(token)}
+}
+
+// QueryExtractor extracts credentials from query parameters. Browsers cannot set headers on
+// WebSocket handshakes, so it is only applied to them. Query parameters end up in access logs.
+type QueryExtractor struct {
+	tokenIdentifierName string
+	idTokenName         string
+	tokenSourceName     string
+}
+
+func NewQueryExtractor(tokenIdentifierName, idTokenName, tokenSourceName string) *QueryExtractor {
+	return &QueryExtractor{
+		tokenIdentifierName: tokenIdentifierName,
+		idTokenName:         idTokenName,
+		tokenSourceName:     tokenSourceName,
+	}
+}
+
+func (a *QueryExtractor) Name() string {
+	return "query"
+}
+
+func (a *QueryExtractor) Extract(r *http.Request) port.Credential {
+	if !isWebSocketUpgrade(r) {
+		return port.Credential{}
+	}
+	query := r.URL.Query()
+	return port.Credential{
+		TokenIdentifier: query.Get(a.tokenIdentifierName),
+		IDToken:         query.Get(a.idTokenName),
+		TokenSource:     query.Get(a.tokenSourceName),
+	}
+}
+
+// FormExtractor extracts credentials from fields of url encoded form posts.
+type FormExtractor struct {
+	tokenIdentifierName string
+	idTokenName         string
+	tokenSourceName     string
+}
+
+func NewFormExtractor(tokenIdentifierName, idTokenName, tokenSourceName string) *FormExtractor {
+	return &FormExtractor{
+		tokenIdentifierName: tokenIdentifierName,
+		idTokenName:         idTokenName,
+		tokenSourceName:     tokenSourceName,
+	}
+}
+
+func (a *FormExtractor) Name() string {
+	return "form"
+}
+
+func (a *FormExtractor) Extract(r *http.Request) port.Credential {
+	if r.Method != http.MethodPost {
+		return port.Credential{}
+	}
+	// other bodies, like signed json, must be left unread for handlers
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return port.Credential{}
+	}
+	return port.Credential{
+		TokenIdentifier: r.PostFormValue(a.tokenIdentifierName),
+		IDToken:         r.PostFormValue(a.idTokenName),
+		TokenSource:     r.PostFormValue(a.tokenSourceName),
+	}
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range strings.Split(r.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/w-woong/auth/port"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// dbHealthCheck pings the database.
+type dbHealthCheck struct {
+	db *sql.DB
+}
+
+func NewDBHealthCheck(db *sql.DB) *dbHealthCheck {
+	return &dbHealthCheck{
+		db: db,
+	}
+}
+
+func (c *dbHealthCheck) Name() string {
+	return "db"
+}
+
+func (c *dbHealthCheck) Check(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+// httpHealthCheck checks that url is reachable. Any response but 5xx is fine, since endpoints
+// like the token endpoint reject requests without credentials.
+type httpHealthCheck struct {
+	name   string
+	client *http.Client
+	url    string
+}
+
+func NewHttpHealthCheck(name string, client *http.Client, url string) *httpHealthCheck {
+	return &httpHealthCheck{
+		name:   name,
+		client: client,
+		url:    url,
+	}
+}
+
+func (c *httpHealthCheck) Name() string {
+	return c.name
+}
+
+func (c *httpHealthCheck) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return errors.New(c.url + " responded " + res.Status)
+	}
+	return nil
+}
+
+// CachedHealthCheck runs check at most once per ttl and answers with its last result otherwise,
+// for dependencies which must not be called on every probe, like the provider.
+type CachedHealthCheck struct {
+	check port.HealthCheck
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+func NewCachedHealthCheck(check port.HealthCheck, ttl time.Duration) *CachedHealthCheck {
+	return &CachedHealthCheck{
+		check: check,
+		ttl:   ttl,
+	}
+}
+
+func (c *CachedHealthCheck) Name() string {
+	return c.check.Name()
+}
+
+// Check holds the lock while checking, so concurrent probes share one call.
+func (c *CachedHealthCheck) Check(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.ttl {
+		return c.err
+	}
+	c.err = c.check.Check(ctx)
+	c.checkedAt = time.Now()
+	return c.err
+}
+
+// grpcHealthCheck checks the state of a grpc connection, and wakes it up if it is idle.
+type grpcHealthCheck struct {
+	name string
+	conn *grpc.ClientConn
+}
+
+func NewGrpcHealthCheck(name string, conn *grpc.ClientConn) *grpcHealthCheck {
+	return &grpcHealthCheck{
+		name: name,
+		conn: conn,
+	}
+}
+
+func (c *grpcHealthCheck) Name() string {
+	return c.name
+}
+
+func (c *grpcHealthCheck) Check(ctx context.Context) error {
+	for {
+		state := c.conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Shutdown, connectivity.TransientFailure:
+			return errors.New("grpc connection is " + strings.ToLower(state.String()))
+		case connectivity.Idle:
+			c.conn.Connect()
+		}
+		if !c.conn.WaitForStateChange(ctx, state) {
+			return errors.New("grpc connection is " + strings.ToLower(state.String()))
+		}
+	}
+}
+
+// JwksHealthCheck records successful fetches of jwksUrl made through it, and fails when the
+// last one is older than maxAge.
+type JwksHealthCheck struct {
+	next    http.RoundTripper
+	jwksUrl string
+	maxAge  time.Duration
+
+	mu          sync.RWMutex
+	lastFetched time.Time
+}
+
+func NewJwksHealthCheck(next http.RoundTripper, jwksUrl string, maxAge time.Duration) *JwksHealthCheck {
+	return &JwksHealthCheck{
+		next:    next,
+		jwksUrl: jwksUrl,
+		maxAge:  maxAge,
+	}
+}
+
+func (c *JwksHealthCheck) Name() string {
+	return "jwks"
+}
+
+func (c *JwksHealthCheck) Check(ctx context.Context) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lastFetched.IsZero() {
+		return errors.New("jwks has not been fetched")
+	}
+	if age := time.Since(c.lastFetched); age > c.maxAge {
+		return errors.New("jwks was fetched " + age.Truncate(time.Second).String() + " ago")
+	}
+	return nil
+}
+
+func (c *JwksHealthCheck) RoundTrip(r *http.Request) (*http.Response, error) {
+	res, err := c.next.RoundTrip(r)
+	if err == nil && res.StatusCode < http.StatusBadRequest && strings.HasPrefix(r.URL.String(), c.jwksUrl) {
+		c.mu.Lock()
+		c.lastFetched = time.Now()
+		c.mu.Unlock()
+	}
+	return res, err
+}
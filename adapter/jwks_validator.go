@@ -0,0 +1,94 @@
+package adapter
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/common"
+	commondto "github.com/w-woong/common/dto"
+)
+
+// jwksRefreshRateLimit limits fetches of jwks caused by id tokens signed with unknown keys. It
+// limits periodic fetches as well, so it is shortened to the refresh interval.
+const jwksRefreshRateLimit = time.Minute
+
+var (
+	ErrIDTokenAudience = errors.New("id_token is not issued for the client")
+	ErrIDTokenIssuer   = errors.New("id_token is not issued by the provider")
+)
+
+// JwksIDTokenValidator validates id tokens issued by issuer for clientID with the keys of jwksUrl,
+// which are fetched with the given client. Keys are fetched again every refreshInterval, and when
+// an id token is signed with an unknown key, at most once per minute or refreshInterval.
+type JwksIDTokenValidator struct {
+	jwks   *keyfunc.JWKS
+	issuer string
+
+	mu       sync.RWMutex
+	clientID string
+}
+
+func NewJwksIDTokenValidator(client *http.Client, jwksUrl string, refreshInterval time.Duration,
+	issuer, clientID string) (*JwksIDTokenValidator, error) {
+	rateLimit := jwksRefreshRateLimit
+	if refreshInterval > 0 && refreshInterval < rateLimit {
+		rateLimit = refreshInterval
+	}
+	jwks, err := keyfunc.Get(jwksUrl, keyfunc.Options{
+		Client:            client,
+		RefreshInterval:   refreshInterval,
+		RefreshRateLimit:  rateLimit,
+		RefreshUnknownKID: true,
+		RefreshErrorHandler: func(err error) {
+			logger.Error("jwks: " + err.Error())
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &JwksIDTokenValidator{
+		jwks:     jwks,
+		issuer:   issuer,
+		clientID: clientID,
+	}, nil
+}
+
+// SetClientID replaces the client id tokens must be issued for, like with a reloaded one.
+func (v *JwksIDTokenValidator) SetClientID(clientID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.clientID = clientID
+}
+
+func (v *JwksIDTokenValidator) Validate(idToken string) (*jwt.Token, *commondto.IDTokenClaims, error) {
+	claims := &commondto.IDTokenClaims{}
+	token, err := jwt.ParseWithClaims(idToken, claims, v.jwks.Keyfunc)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, nil, common.ErrTokenExpired
+		}
+		return nil, nil, err
+	}
+	v.mu.RLock()
+	clientID := v.clientID
+	v.mu.RUnlock()
+	if !claims.VerifyAudience(clientID, true) {
+		return nil, nil, ErrIDTokenAudience
+	}
+	// google issues id tokens of https://accounts.google.com without the scheme as well
+	if !claims.VerifyIssuer(v.issuer, true) && !claims.VerifyIssuer(strings.TrimPrefix(v.issuer, "https://"), true) {
+		return nil, nil, ErrIDTokenIssuer
+	}
+	return token, claims, nil
+}
+
+// Close stops fetching keys.
+func (v *JwksIDTokenValidator) Close() {
+	v.jwks.EndBackground()
+}
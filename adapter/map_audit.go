@@ -0,0 +1,53 @@
+package adapter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/common"
+)
+
+type MapAudit struct {
+	events []entity.AuditEvent
+	lastID int64
+	l      sync.RWMutex
+}
+
+func NewMapAudit() *MapAudit {
+	return &MapAudit{}
+}
+
+func (a *MapAudit) Create(ctx context.Context, tx common.TxController, event entity.AuditEvent) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.lastID++
+	event.ID = a.lastID
+	if event.CreatedAt == nil {
+		now := time.Now()
+		event.CreatedAt = &now
+	}
+	a.events = append(a.events, event)
+	return event.ID, nil
+}
+
+func (a *MapAudit) Find(ctx context.Context, filter port.AuditFilter) ([]entity.AuditEvent, error) {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	events := make([]entity.AuditEvent, 0)
+	for i := len(a.events) - 1; i >= 0 && (filter.Limit <= 0 || len(events) < filter.Limit); i-- {
+		event := a.events[i]
+		if (filter.Subject != "" && event.Subject != filter.Subject) ||
+			(filter.Type != "" && event.Type != filter.Type) ||
+			(filter.TokenID != "" && event.TokenID != filter.TokenID) ||
+			(filter.Before > 0 && event.ID >= filter.Before) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -2,15 +2,16 @@ package adapter
 
 import (
 	"context"
-	"errors"
+	"sync"
 
 	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
 	"github.com/w-woong/common"
 )
 
 type MapAuthRequest struct {
 	m map[string]entity.AuthRequest
-	// l sync.RWMutex
+	l sync.RWMutex
 }
 
 func NewMapAuthRequest() *MapAuthRequest {
@@ -19,38 +20,67 @@ func NewMapAuthRequest() *MapAuthRequest {
 	}
 }
 func (a *MapAuthRequest) Create(ctx context.Context, tx common.TxController, authRequest entity.AuthRequest) (int64, error) {
-	// a.l.Lock()
-	// defer a.l.Unlock()
+	a.l.Lock()
+	defer a.l.Unlock()
 
+	if _, ok := a.m[authRequest.ID]; ok {
+		return 0, port.ErrDuplicateRecord
+	}
 	a.m[authRequest.ID] = authRequest
+	onRollback(tx, func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		delete(a.m, authRequest.ID)
+	})
 
 	return 1, nil
 }
 func (a *MapAuthRequest) Read(ctx context.Context, tx common.TxController, id string) (entity.AuthRequest, error) {
-	// a.l.RLock()
-	// defer a.l.RUnlock()
+	a.l.RLock()
+	defer a.l.RUnlock()
 
 	if authRequest, ok := a.m[id]; ok {
 		return authRequest, nil
 	}
 
-	return entity.NilAuthRequest, errors.New("cannot find auth request")
+	return entity.NilAuthRequest, common.ErrRecordNotFound
 }
 func (a *MapAuthRequest) ReadNoTx(ctx context.Context, id string) (entity.AuthRequest, error) {
-	// a.l.RLock()
-	// defer a.l.RUnlock()
+	return a.Read(ctx, nil, id)
+}
+func (a *MapAuthRequest) Delete(ctx context.Context, tx common.TxController, id string) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
 
-	if authRequest, ok := a.m[id]; ok {
-		return authRequest, nil
+	old, ok := a.m[id]
+	if !ok {
+		return 0, nil
 	}
+	delete(a.m, id)
+	onRollback(tx, func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		a.m[id] = old
+	})
 
-	return entity.NilAuthRequest, errors.New("cannot find auth request")
+	return 1, nil
 }
-func (a *MapAuthRequest) Delete(ctx context.Context, tx common.TxController, id string) (int64, error) {
-	// a.l.Lock()
-	// defer a.l.Unlock()
+func (a *MapAuthRequest) UpdateResponseUrl(ctx context.Context, tx common.TxController, id, responseUrl string) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
 
-	delete(a.m, id)
+	old, ok := a.m[id]
+	if !ok {
+		return 0, nil
+	}
+	authRequest := old
+	authRequest.ResponseUrl = responseUrl
+	a.m[id] = authRequest
+	onRollback(tx, func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		a.m[id] = old
+	})
 
 	return 1, nil
 }
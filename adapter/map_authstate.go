@@ -2,14 +2,16 @@ package adapter
 
 import (
 	"context"
-	"errors"
+	"sync"
 
 	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
 	"github.com/w-woong/common"
 )
 
 type MapAuthState struct {
 	m map[string]entity.AuthState
+	l sync.RWMutex
 }
 
 func NewMapAuthState() *MapAuthState {
@@ -18,16 +20,42 @@ func NewMapAuthState() *MapAuthState {
 	}
 }
 func (a *MapAuthState) Create(ctx context.Context, tx common.TxController, authState entity.AuthState) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if _, ok := a.m[authState.State]; ok {
+		return 0, port.ErrDuplicateRecord
+	}
 	a.m[authState.State] = authState
+	onRollback(tx, func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		delete(a.m, authState.State)
+	})
 	return 1, nil
 }
 func (a *MapAuthState) ReadByState(ctx context.Context, tx common.TxController, state string) (entity.AuthState, error) {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
 	if authState, ok := a.m[state]; ok {
 		return authState, nil
 	}
-	return entity.NilAuthState, errors.New("cannot find state")
+	return entity.NilAuthState, common.ErrRecordNotFound
 }
 func (a *MapAuthState) DeleteByState(ctx context.Context, tx common.TxController, state string) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	old, ok := a.m[state]
+	if !ok {
+		return 0, nil
+	}
 	delete(a.m, state)
+	onRollback(tx, func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		a.m[state] = old
+	})
 	return 1, nil
 }
@@ -0,0 +1,60 @@
+package adapter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/common"
+)
+
+type MapRateLimit struct {
+	m map[string]entity.RateLimitBucket
+	l sync.Mutex
+}
+
+func NewMapRateLimit() *MapRateLimit {
+	return &MapRateLimit{
+		m: make(map[string]entity.RateLimitBucket),
+	}
+}
+
+func (a *MapRateLimit) ReadForUpdate(ctx context.Context, tx common.TxController, key string) (entity.RateLimitBucket, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if bucket, ok := a.m[key]; ok {
+		return bucket, nil
+	}
+	return entity.NilRateLimitBucket, common.ErrRecordNotFound
+}
+
+func (a *MapRateLimit) Save(ctx context.Context, tx common.TxController, bucket entity.RateLimitBucket) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	now := time.Now()
+	if old, ok := a.m[bucket.Key]; ok {
+		bucket.CreatedAt = old.CreatedAt
+	} else {
+		bucket.CreatedAt = &now
+	}
+	bucket.UpdatedAt = &now
+	a.m[bucket.Key] = bucket
+	return 1, nil
+}
+
+func (a *MapRateLimit) DeleteUpdatedBefore(ctx context.Context, tx common.TxController, t time.Time) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	var affected int64
+	for key, bucket := range a.m {
+		if bucket.UpdatedAt != nil && bucket.UpdatedAt.Before(t) {
+			delete(a.m, key)
+			affected++
+		}
+	}
+	return affected, nil
+}
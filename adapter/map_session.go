@@ -0,0 +1,87 @@
+package adapter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/common"
+)
+
+type MapSession struct {
+	m map[string]entity.Session
+	l sync.RWMutex
+}
+
+func NewMapSession() *MapSession {
+	return &MapSession{
+		m: make(map[string]entity.Session),
+	}
+}
+
+func (a *MapSession) Save(ctx context.Context, tx common.TxController, session entity.Session) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	now := time.Now()
+	if old, ok := a.m[session.ID]; ok {
+		session.CreatedAt = old.CreatedAt
+	} else {
+		session.CreatedAt = &now
+	}
+	session.UpdatedAt = &now
+	a.m[session.ID] = session
+	return 1, nil
+}
+
+func (a *MapSession) ReadNoTx(ctx context.Context, id string) (entity.Session, error) {
+	return a.Read(ctx, nil, id)
+}
+
+func (a *MapSession) Read(ctx context.Context, tx common.TxController, id string) (entity.Session, error) {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	if session, ok := a.m[id]; ok {
+		return session, nil
+	}
+	return entity.NilSession, common.ErrRecordNotFound
+}
+
+func (a *MapSession) ReadByTokenID(ctx context.Context, tx common.TxController, tokenID string) (entity.Session, error) {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	for _, session := range a.m {
+		if session.TokenID == tokenID {
+			return session, nil
+		}
+	}
+	return entity.NilSession, common.ErrRecordNotFound
+}
+
+func (a *MapSession) Delete(ctx context.Context, tx common.TxController, id string) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if _, ok := a.m[id]; !ok {
+		return 0, nil
+	}
+	delete(a.m, id)
+	return 1, nil
+}
+
+func (a *MapSession) DeleteExpired(ctx context.Context, tx common.TxController, now time.Time) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	var affected int64
+	for id, session := range a.m {
+		if session.ExpiresAt < now.Unix() {
+			delete(a.m, id)
+			affected++
+		}
+	}
+	return affected, nil
+}
@@ -2,13 +2,18 @@ package adapter
 
 import (
 	"context"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
 	"github.com/w-woong/common"
 )
 
 type MapToken struct {
 	m map[string]entity.Token
+	l sync.RWMutex
 }
 
 func NewMapToken() *MapToken {
@@ -18,11 +23,39 @@ func NewMapToken() *MapToken {
 }
 
 func (a *MapToken) Create(ctx context.Context, tx common.TxController, token entity.Token) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if _, ok := a.m[token.ID]; ok || a.accessTokenTaken(token) {
+		return 0, port.ErrDuplicateRecord
+	}
+	now := time.Now()
+	token.CreatedAt = &now
+	token.UpdatedAt = &now
 	a.m[token.ID] = token
+	onRollback(tx, func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		delete(a.m, token.ID)
+	})
 	return 1, nil
 }
 
+// accessTokenTaken tells whether another token has the token source and access token of token, which
+// are unique like idx_tokens_1.
+func (a *MapToken) accessTokenTaken(token entity.Token) bool {
+	for id, t := range a.m {
+		if id != token.ID && t.TokenSource == token.TokenSource && t.AccessToken == token.AccessToken {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *MapToken) Read(ctx context.Context, tx common.TxController, id string) (entity.Token, error) {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
 	if token, ok := a.m[id]; ok {
 		return token, nil
 	}
@@ -30,13 +63,98 @@ func (a *MapToken) Read(ctx context.Context, tx common.TxController, id string)
 }
 
 func (a *MapToken) ReadNoTx(ctx context.Context, id string) (entity.Token, error) {
-	if token, ok := a.m[id]; ok {
-		return token, nil
+	return a.Read(ctx, nil, id)
+}
+
+func (a *MapToken) ReadBySubject(ctx context.Context, tx common.TxController, tokenSource entity.TokenSource, subject string) ([]entity.Token, error) {
+	return a.readTokens(func(token entity.Token) bool {
+		return token.TokenSource == tokenSource && token.Subject == subject
+	}), nil
+}
+
+func (a *MapToken) ReadByUserID(ctx context.Context, userID string) ([]entity.Token, error) {
+	return a.readTokens(func(token entity.Token) bool {
+		return token.UserID == userID
+	}), nil
+}
+
+// ReadForUpdate relies on tx, such as txcom.LockTxBeginner, to serialize updates.
+func (a *MapToken) ReadForUpdate(ctx context.Context, tx common.TxController, id string) (entity.Token, error) {
+	return a.Read(ctx, tx, id)
+}
+
+func (a *MapToken) ReadRefreshable(ctx context.Context, expiry int64, maxFailures, limit int) ([]entity.Token, error) {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	tokens := make([]entity.Token, 0)
+	for _, token := range a.m {
+		if token.RefreshToken != "" && token.Expiry < expiry && token.RefreshFailures < maxFailures {
+			tokens = append(tokens, token)
+		}
 	}
-	return entity.NilToken, common.ErrRecordNotFound
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Expiry < tokens[j].Expiry })
+	if len(tokens) > limit {
+		tokens = tokens[:limit]
+	}
+	return tokens, nil
+}
+
+func (a *MapToken) Update(ctx context.Context, tx common.TxController, token entity.Token) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	old, ok := a.m[token.ID]
+	if !ok {
+		return 0, nil
+	}
+	if a.accessTokenTaken(token) {
+		return 0, port.ErrDuplicateRecord
+	}
+	now := time.Now()
+	token.CreatedAt = old.CreatedAt
+	token.UpdatedAt = &now
+	a.m[token.ID] = token
+	onRollback(tx, func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		a.m[old.ID] = old
+	})
+	return 1, nil
 }
 
 func (a *MapToken) Delete(ctx context.Context, tx common.TxController, id string) (int64, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	old, ok := a.m[id]
+	if !ok {
+		return 0, nil
+	}
 	delete(a.m, id)
+	onRollback(tx, func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		a.m[id] = old
+	})
 	return 1, nil
 }
+
+func (a *MapToken) readTokens(match func(token entity.Token) bool) []entity.Token {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	tokens := make([]entity.Token, 0)
+	for _, token := range a.m {
+		if match(token) {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].CreatedAt == nil || tokens[j].CreatedAt == nil {
+			return tokens[j].CreatedAt == nil && tokens[i].CreatedAt != nil
+		}
+		return tokens[i].CreatedAt.After(*tokens[j].CreatedAt)
+	})
+	return tokens
+}
@@ -0,0 +1,65 @@
+package adapter
+
+import (
+	"sync"
+
+	"github.com/w-woong/common"
+)
+
+// MapTxBeginner serializes transactions of map repositories, like txcom.LockTxBeginner, and undoes
+// their writes on rollback.
+type MapTxBeginner struct {
+	l sync.RWMutex
+}
+
+func NewMapTxBeginner() *MapTxBeginner {
+	return &MapTxBeginner{}
+}
+
+func (b *MapTxBeginner) Begin() (common.TxController, error) {
+	b.l.Lock()
+	return &mapTx{unlock: b.l.Unlock}, nil
+}
+
+// BeginR begins a transaction which only reads.
+func (b *MapTxBeginner) BeginR() (common.TxController, error) {
+	b.l.RLock()
+	return &mapTx{unlock: b.l.RUnlock}, nil
+}
+
+type mapTx struct {
+	unlock func()
+	undo   []func()
+	done   bool
+}
+
+func (tx *mapTx) Commit() error {
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	tx.undo = nil
+	tx.unlock()
+	return nil
+}
+
+// Rollback after Commit does nothing, so that it can be deferred.
+func (tx *mapTx) Rollback() error {
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+	tx.unlock()
+	return nil
+}
+
+// onRollback registers undo of a write made in tx. Writes outside of map transactions can't be undone.
+func onRollback(tx common.TxController, undo func()) {
+	if tx, ok := tx.(*mapTx); ok && !tx.done {
+		tx.undo = append(tx.undo, undo)
+	}
+}
@@ -25,3 +25,10 @@ func (a *NopTokenCookie) GetIDToken(r *http.Request) string {
 
 func (a *NopTokenCookie) SetIDToken(w http.ResponseWriter, idToken string) {
 }
+
+func (a *NopTokenCookie) GetTokenSource(r *http.Request) string {
+	return ""
+}
+
+func (a *NopTokenCookie) SetTokenSource(w http.ResponseWriter, tokenSource string) {
+}
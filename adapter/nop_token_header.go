@@ -25,3 +25,10 @@ func (a *NopTokenHeader) GetIDToken(r *http.Request) string {
 
 func (a *NopTokenHeader) SetIDToken(w http.ResponseWriter, idToken string) {
 }
+
+func (a *NopTokenHeader) GetTokenSource(r *http.Request) string {
+	return ""
+}
+
+func (a *NopTokenHeader) SetTokenSource(w http.ResponseWriter, tokenSource string) {
+}
@@ -0,0 +1,17 @@
+package adapter
+
+import (
+	"errors"
+
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/common/txcom"
+)
+
+// convertErr converts unique violations into port.ErrDuplicateRecord, other errors by txcom.ConvertErr.
+func convertErr(err error) error {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) && pgErr.SQLState() == "23505" {
+		return port.ErrDuplicateRecord
+	}
+	return txcom.ConvertErr(err)
+}
@@ -0,0 +1,69 @@
+package adapter
+
+import (
+	"context"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/common"
+	"github.com/w-woong/common/txcom"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type rateLimitPg struct {
+	db *gorm.DB
+}
+
+func NewRateLimitPg(db *gorm.DB) *rateLimitPg {
+	return &rateLimitPg{
+		db: db,
+	}
+}
+
+func (a *rateLimitPg) ReadForUpdate(ctx context.Context, tx common.TxController, key string) (entity.RateLimitBucket, error) {
+	bucket := entity.RateLimitBucket{}
+	res := tx.(*txcom.GormTxController).Tx.
+		WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("key = ?", key).
+		Limit(1).Find(&bucket)
+
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return entity.NilRateLimitBucket, txcom.ConvertErr(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return entity.NilRateLimitBucket, common.ErrRecordNotFound
+	}
+
+	return bucket, nil
+}
+
+func (a *rateLimitPg) Save(ctx context.Context, tx common.TxController, bucket entity.RateLimitBucket) (int64, error) {
+	res := tx.(*txcom.GormTxController).Tx.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"tokens", "refilled_at", "updated_at"}),
+		}).
+		Create(&bucket)
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return 0, txcom.ConvertErr(res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+func (a *rateLimitPg) DeleteUpdatedBefore(ctx context.Context, tx common.TxController, t time.Time) (int64, error) {
+	res := tx.(*txcom.GormTxController).Tx.
+		WithContext(ctx).
+		Where("updated_at < ?", t).
+		Delete(&entity.RateLimitBucket{})
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return 0, txcom.ConvertErr(res.Error)
+	}
+	return res.RowsAffected, nil
+}
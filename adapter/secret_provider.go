@@ -0,0 +1,121 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/w-woong/auth/port"
+)
+
+// fileSecret reads a file, like file:///run/secrets/client_secret. Trailing newlines are trimmed.
+type fileSecret struct{}
+
+func NewFileSecret() *fileSecret {
+	return &fileSecret{}
+}
+
+func (p *fileSecret) Secret(ctx context.Context, ref string) (string, error) {
+	b, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// envSecret reads an environment variable, like env://CLIENT_SECRET.
+type envSecret struct{}
+
+func NewEnvSecret() *envSecret {
+	return &envSecret{}
+}
+
+func (p *envSecret) Secret(ctx context.Context, ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", port.ErrSecretNotFound
+	}
+	return value, nil
+}
+
+// execSecret runs a command and reads its standard output, like exec:///usr/local/bin/secret client_secret.
+// Arguments are separated by spaces, no shell is involved.
+type execSecret struct{}
+
+func NewExecSecret() *execSecret {
+	return &execSecret{}
+}
+
+func (p *execSecret) Secret(ctx context.Context, ref string) (string, error) {
+	args := strings.Fields(ref)
+	if len(args) == 0 {
+		return "", errors.New("exec secret requires a command")
+	}
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// vaultSecret reads a key of a Vault compatible kv secret, like vault://secret/data/auth#client_secret.
+// Both kv version 1 and 2 responses are understood.
+type vaultSecret struct {
+	client *http.Client
+	addr   string
+	token  string
+}
+
+func NewVaultSecret(client *http.Client, addr string, token string) *vaultSecret {
+	return &vaultSecret{
+		client: client,
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+	}
+}
+
+func (p *vaultSecret) Secret(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || key == "" {
+		return "", errors.New("vault secret requires a key, like path#key")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", port.ErrSecretNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New("vault responded " + res.Status)
+	}
+
+	body := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	data := body.Data
+	// kv version 2 nests values under data.data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", port.ErrSecretNotFound
+	}
+	return value, nil
+}
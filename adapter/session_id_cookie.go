@@ -0,0 +1,62 @@
+package adapter
+
+import (
+	"net/http"
+	"time"
+)
+
+type SessionIDCookie struct {
+	policy *cookiePolicyValue
+	name   string
+}
+
+func NewSessionIDCookie(policy CookiePolicy, name string) *SessionIDCookie {
+	if name == "" {
+		name = "sid"
+	}
+	return &SessionIDCookie{
+		policy: newCookiePolicyValue(sessionIDCookiePolicy(policy)),
+		name:   policy.Prefix + name,
+	}
+}
+
+func sessionIDCookiePolicy(policy CookiePolicy) CookiePolicy {
+	if policy.Path == "" {
+		policy.Path = "/"
+	}
+	return policy
+}
+
+// SetPolicy replaces the policy of cookies set from now on.
+func (a *SessionIDCookie) SetPolicy(policy CookiePolicy) error {
+	return a.policy.set(sessionIDCookiePolicy(policy))
+}
+
+func (a *SessionIDCookie) GetSessionID(r *http.Request) string {
+	return get(r, a.name)
+}
+
+// GetSessionIDFromResponse finds the last session id cookie set on w. A removed cookie yields
+// an empty string.
+func (a *SessionIDCookie) GetSessionIDFromResponse(w http.ResponseWriter) string {
+	res := http.Response{Header: w.Header()}
+	sessionID := ""
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == a.name {
+			sessionID = cookie.Value
+		}
+	}
+	return sessionID
+}
+
+func (a *SessionIDCookie) SetSessionID(w http.ResponseWriter, sessionID string) {
+	setCookie(w, a.policy.get(), a.name, sessionID)
+}
+
+func (a *SessionIDCookie) RemoveSessionID(w http.ResponseWriter) {
+	removeCookie(w, a.policy.get(), a.name)
+}
+
+func (a *SessionIDCookie) ExpireAfter() time.Duration {
+	return a.policy.get().ExpireAfter
+}
@@ -0,0 +1,90 @@
+package adapter
+
+import (
+	"context"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/common"
+	"github.com/w-woong/common/txcom"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type sessionPg struct {
+	db *gorm.DB
+}
+
+func NewSessionPg(db *gorm.DB) *sessionPg {
+	return &sessionPg{
+		db: db,
+	}
+}
+
+func (a *sessionPg) Save(ctx context.Context, tx common.TxController, session entity.Session) (int64, error) {
+	res := tx.(*txcom.GormTxController).Tx.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"token_id", "id_token", "token_source", "expires_at", "updated_at"}),
+		}).
+		Create(&session)
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return 0, txcom.ConvertErr(res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+func (a *sessionPg) ReadNoTx(ctx context.Context, id string) (entity.Session, error) {
+	return a.readSession(ctx, a.db, "id = ?", id)
+}
+
+func (a *sessionPg) Read(ctx context.Context, tx common.TxController, id string) (entity.Session, error) {
+	return a.readSession(ctx, tx.(*txcom.GormTxController).Tx, "id = ?", id)
+}
+
+func (a *sessionPg) ReadByTokenID(ctx context.Context, tx common.TxController, tokenID string) (entity.Session, error) {
+	return a.readSession(ctx, tx.(*txcom.GormTxController).Tx, "token_id = ?", tokenID)
+}
+
+func (a *sessionPg) Delete(ctx context.Context, tx common.TxController, id string) (int64, error) {
+	res := tx.(*txcom.GormTxController).Tx.
+		WithContext(ctx).
+		Delete(&entity.Session{ID: id})
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return 0, txcom.ConvertErr(res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+func (a *sessionPg) DeleteExpired(ctx context.Context, tx common.TxController, now time.Time) (int64, error) {
+	res := tx.(*txcom.GormTxController).Tx.
+		WithContext(ctx).
+		Where("expires_at < ?", now.Unix()).
+		Delete(&entity.Session{})
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return 0, txcom.ConvertErr(res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+func (a *sessionPg) readSession(ctx context.Context, db *gorm.DB, query string, arg string) (entity.Session, error) {
+	session := entity.Session{}
+	res := db.WithContext(ctx).
+		Where(query, arg).
+		Limit(1).Find(&session)
+
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return entity.NilSession, txcom.ConvertErr(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return entity.NilSession, common.ErrRecordNotFound
+	}
+
+	return session, nil
+}
@@ -0,0 +1,111 @@
+package adapter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStateCookieMissing  = errors.New("state cookie is missing")
+	ErrStateCookieInvalid  = errors.New("state cookie is invalid")
+	ErrStateCookieExpired  = errors.New("state cookie is expired")
+	ErrStateCookieMismatch = errors.New("state does not match the state cookie")
+)
+
+// StateCookie binds an oauth state to the browser which started authorization. The cookie holds
+// a hash of the state, the time it was issued at and an hmac of both.
+type StateCookie struct {
+	policy *cookiePolicyValue
+	name   string
+
+	mu     sync.RWMutex
+	secret []byte
+}
+
+// NewStateCookie creates StateCookie. SameSite is always lax, because the callback is a cross site
+// navigation from the authorization server.
+func NewStateCookie(policy CookiePolicy, name string, secret []byte) *StateCookie {
+	return &StateCookie{
+		policy: newCookiePolicyValue(stateCookiePolicy(policy)),
+		name:   policy.Prefix + name,
+		secret: secret,
+	}
+}
+
+func stateCookiePolicy(policy CookiePolicy) CookiePolicy {
+	if policy.Path == "" {
+		policy.Path = "/"
+	}
+	policy.SameSite = http.SameSiteLaxMode
+	return policy
+}
+
+// SetPolicy replaces the policy of cookies set, and verified, from now on.
+func (a *StateCookie) SetPolicy(policy CookiePolicy) error {
+	return a.policy.set(stateCookiePolicy(policy))
+}
+
+// SetSecret replaces the secret cookies are signed, and verified, with from now on. Authorizations
+// started before have to start over.
+func (a *StateCookie) SetSecret(secret []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.secret = secret
+}
+
+func (a *StateCookie) SetState(w http.ResponseWriter, state string) {
+	hashed := hashState(state)
+	issuedAt := strconv.FormatInt(time.Now().Unix(), 10)
+	setCookie(w, a.policy.get(), a.name, hashed+"."+issuedAt+"."+a.sign(hashed, issuedAt))
+}
+
+func (a *StateCookie) VerifyState(r *http.Request, state string) error {
+	value := get(r, a.name)
+	if value == "" {
+		return ErrStateCookieMissing
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return ErrStateCookieInvalid
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(a.sign(parts[0], parts[1]))) {
+		return ErrStateCookieInvalid
+	}
+	issuedAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrStateCookieInvalid
+	}
+	if time.Now().After(time.Unix(issuedAt, 0).Add(a.policy.get().ExpireAfter)) {
+		return ErrStateCookieExpired
+	}
+	if !hmac.Equal([]byte(parts[0]), []byte(hashState(state))) {
+		return ErrStateCookieMismatch
+	}
+	return nil
+}
+
+func (a *StateCookie) RemoveState(w http.ResponseWriter) {
+	removeCookie(w, a.policy.get(), a.name)
+}
+
+func (a *StateCookie) sign(hashed, issuedAt string) string {
+	a.mu.RLock()
+	secret := a.secret
+	a.mu.RUnlock()
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(hashed + "." + issuedAt))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hashState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
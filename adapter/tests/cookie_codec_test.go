@@ -0,0 +1,94 @@
+package adapter_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/w-woong/auth/adapter"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestAesGcmCookieCodec(t *testing.T) {
+	codec, err := adapter.NewAesGcmCookieCodec([]adapter.CookieKey{{ID: "k1", Key: key1}}, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := codec.Encode("token_source", "google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(encoded, "google") {
+		t.Errorf("encoded value %v is readable", encoded)
+	}
+
+	decoded, err := codec.Decode("token_source", encoded)
+	if err != nil || decoded != "google" {
+		t.Errorf("Decode() = %v, %v", decoded, err)
+	}
+
+	if _, err = codec.Decode("tid", encoded); err != adapter.ErrCookieTampered {
+		t.Errorf("value moved to another cookie: err = %v", err)
+	}
+
+	tampered := encoded[:len(encoded)-2] + "AA"
+	if tampered == encoded {
+		tampered = encoded[:len(encoded)-2] + "BB"
+	}
+	if _, err = codec.Decode("token_source", tampered); err != adapter.ErrCookieTampered {
+		t.Errorf("tampered value: err = %v", err)
+	}
+
+	if _, err = codec.Decode("token_source", "google"); err != adapter.ErrCookieMalformed {
+		t.Errorf("plain value: err = %v", err)
+	}
+}
+
+func TestAesGcmCookieCodec_Stale(t *testing.T) {
+	codec, err := adapter.NewAesGcmCookieCodec([]adapter.CookieKey{{ID: "k1", Key: key1}}, time.Nanosecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := codec.Encode("tid", "1234")
+	time.Sleep(10 * time.Millisecond)
+	if _, err = codec.Decode("tid", encoded); err != adapter.ErrCookieStale {
+		t.Errorf("Decode() err = %v, want %v", err, adapter.ErrCookieStale)
+	}
+}
+
+func TestAesGcmCookieCodec_Rotation(t *testing.T) {
+	old, _ := adapter.NewAesGcmCookieCodec([]adapter.CookieKey{{ID: "k1", Key: key1}}, time.Hour, 0)
+	encoded, _ := old.Encode("tid", "1234")
+
+	rotated, err := adapter.NewAesGcmCookieCodec([]adapter.CookieKey{
+		{ID: "k2", Key: key2},
+		{ID: "k1", Key: key1, RetiredAt: time.Now()},
+	}, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := rotated.Decode("tid", encoded); err != nil || decoded != "1234" {
+		t.Errorf("retired key in grace period: Decode() = %v, %v", decoded, err)
+	}
+
+	expired, _ := adapter.NewAesGcmCookieCodec([]adapter.CookieKey{
+		{ID: "k2", Key: key2},
+		{ID: "k1", Key: key1, RetiredAt: time.Now().Add(-2 * time.Hour)},
+	}, time.Hour, time.Hour)
+	if _, err := expired.Decode("tid", encoded); err != adapter.ErrCookieKeyUnknown {
+		t.Errorf("retired key after grace period: err = %v", err)
+	}
+
+	if _, err := adapter.NewAesGcmCookieCodec([]adapter.CookieKey{
+		{ID: "k2", Key: key2},
+		{ID: "k1", Key: key1},
+	}, time.Hour, time.Hour); err == nil {
+		t.Error("retired key without retired_at should be rejected")
+	}
+}
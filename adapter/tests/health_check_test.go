@@ -0,0 +1,95 @@
+package adapter_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/authtest"
+	"github.com/w-woong/common"
+)
+
+type countingHealthCheck struct {
+	calls int
+	err   error
+}
+
+func (c *countingHealthCheck) Name() string { return "provider" }
+
+func (c *countingHealthCheck) Check(ctx context.Context) error {
+	c.calls++
+	return c.err
+}
+
+func TestCachedHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	next := &countingHealthCheck{err: errors.New("unavailable")}
+	check := adapter.NewCachedHealthCheck(next, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if err := check.Check(ctx); err == nil {
+			t.Fatal("Check() = nil, want the cached failure")
+		}
+	}
+	if next.calls != 1 {
+		t.Errorf("checked %d times within ttl, want 1", next.calls)
+	}
+
+	next.err = nil
+	time.Sleep(60 * time.Millisecond)
+	if err := check.Check(ctx); err != nil || next.calls != 2 {
+		t.Errorf("Check() after ttl = %v, checked %d times", err, next.calls)
+	}
+}
+
+func TestJwksIDTokenValidator(t *testing.T) {
+	provider := authtest.NewProvider("client1", "secret1")
+	defer provider.Close()
+	user := authtest.User{Subject: "user1", Email: "user1@example.com"}
+
+	maxAge := 100 * time.Millisecond
+	jwksHealthCheck := adapter.NewJwksHealthCheck(http.DefaultTransport, provider.JwksURL(), maxAge)
+	validator, err := adapter.NewJwksIDTokenValidator(&http.Client{Transport: jwksHealthCheck},
+		provider.JwksURL(), maxAge/4, provider.URL(), "client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := validator.Validate(provider.IDToken(user, time.Hour)); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	if _, _, err := validator.Validate(provider.IDToken(user, -time.Minute)); !errors.Is(err, common.ErrTokenExpired) {
+		t.Errorf("Validate() of an expired id token = %v, want %v", err, common.ErrTokenExpired)
+	}
+
+	// id tokens of other clients or issuers are rejected
+	validator.SetClientID("client2")
+	if _, _, err := validator.Validate(provider.IDToken(user, time.Hour)); !errors.Is(err, adapter.ErrIDTokenAudience) {
+		t.Errorf("Validate() for another client = %v, want %v", err, adapter.ErrIDTokenAudience)
+	}
+	validator.SetClientID("client1")
+	other, err := adapter.NewJwksIDTokenValidator(http.DefaultClient, provider.JwksURL(), time.Hour,
+		"https://other.example.com", "client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, _, err := other.Validate(provider.IDToken(user, time.Hour)); !errors.Is(err, adapter.ErrIDTokenIssuer) {
+		t.Errorf("Validate() of another issuer = %v, want %v", err, adapter.ErrIDTokenIssuer)
+	}
+
+	// keys are fetched again within maxAge
+	time.Sleep(3 * maxAge)
+	if err := jwksHealthCheck.Check(context.Background()); err != nil {
+		t.Errorf("Check() = %v", err)
+	}
+
+	validator.Close()
+	time.Sleep(2 * maxAge)
+	if err := jwksHealthCheck.Check(context.Background()); err == nil {
+		t.Error("Check() after Close() = nil, want jwks to be stale")
+	}
+}
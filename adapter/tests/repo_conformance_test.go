@@ -0,0 +1,79 @@
+package adapter_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-wonk/si"
+	"github.com/go-wonk/si/sigorm"
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/authtest"
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/common"
+	"github.com/w-woong/common/txcom"
+	"gorm.io/gorm"
+)
+
+func TestMapRepoConformance(t *testing.T) {
+	t.Run("token", func(t *testing.T) {
+		authtest.RunTokenRepoSuite(t, func(t *testing.T) (common.TxBeginner, port.TokenRepo) {
+			return adapter.NewMapTxBeginner(), adapter.NewMapToken()
+		})
+	})
+	t.Run("auth state", func(t *testing.T) {
+		authtest.RunAuthStateRepoSuite(t, func(t *testing.T) (common.TxBeginner, port.AuthStateRepo) {
+			return adapter.NewMapTxBeginner(), adapter.NewMapAuthState()
+		})
+	})
+	t.Run("auth request", func(t *testing.T) {
+		authtest.RunAuthRequestRepoSuite(t, func(t *testing.T) (common.TxBeginner, port.AuthRequestRepo) {
+			return adapter.NewMapTxBeginner(), adapter.NewMapAuthRequest()
+		})
+	})
+}
+
+// TestPgRepoConformance runs against the database of AUTH_TEST_PG_CONN_STR, whose tables are cleared.
+func TestPgRepoConformance(t *testing.T) {
+	connStr := os.Getenv("AUTH_TEST_PG_CONN_STR")
+	if connStr == "" {
+		t.Skip("AUTH_TEST_PG_CONN_STR is not set")
+	}
+	db, err := si.OpenSqlDB("pgx", connStr, 1, 16, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	gormDB, err := sigorm.OpenPostgresWithConfig(db, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gormDB.AutoMigrate(&entity.Token{}, &entity.AuthState{}, &entity.AuthRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	truncate := func(t *testing.T, model interface{}) {
+		if err := gormDB.Where("1 = 1").Delete(model).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("token", func(t *testing.T) {
+		authtest.RunTokenRepoSuite(t, func(t *testing.T) (common.TxBeginner, port.TokenRepo) {
+			truncate(t, &entity.Token{})
+			return txcom.NewGormTxBeginner(gormDB), adapter.NewTokenPg(gormDB)
+		})
+	})
+	t.Run("auth state", func(t *testing.T) {
+		authtest.RunAuthStateRepoSuite(t, func(t *testing.T) (common.TxBeginner, port.AuthStateRepo) {
+			truncate(t, &entity.AuthState{})
+			return txcom.NewGormTxBeginner(gormDB), adapter.NewAuthStatePg(gormDB)
+		})
+	})
+	t.Run("auth request", func(t *testing.T) {
+		authtest.RunAuthRequestRepoSuite(t, func(t *testing.T) (common.TxBeginner, port.AuthRequestRepo) {
+			truncate(t, &entity.AuthRequest{})
+			return txcom.NewGormTxBeginner(gormDB), adapter.NewAuthRequestPg(gormDB)
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package adapter_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/port"
+)
+
+func TestVaultSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/auth":
+			w.Write([]byte(`{"data":{"data":{"client_secret":"v2"},"metadata":{"version":3}}}`))
+		case "/v1/kv/auth":
+			w.Write([]byte(`{"data":{"client_secret":"v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	vault := adapter.NewVaultSecret(server.Client(), server.URL+"/", "token")
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr error
+	}{
+		{"secret/data/auth#client_secret", "v2", nil},
+		{"kv/auth#client_secret", "v1", nil},
+		{"kv/auth#client_id", "", port.ErrSecretNotFound},
+		{"kv/missing#client_secret", "", port.ErrSecretNotFound},
+	}
+	for _, tt := range tests {
+		got, err := vault.Secret(ctx, tt.ref)
+		if got != tt.want || err != tt.wantErr {
+			t.Errorf("Secret(%v) = %q, %v, want %q, %v", tt.ref, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := adapter.NewVaultSecret(server.Client(), server.URL, "wrong").Secret(ctx, "kv/auth#client_secret"); err == nil {
+		t.Error("Secret() with a wrong token should fail")
+	}
+}
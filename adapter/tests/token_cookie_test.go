@@ -0,0 +1,123 @@
+package adapter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/w-woong/auth/adapter"
+)
+
+func roundTrip(t *testing.T, tokenCookie *adapter.TokenCookie, prev []*http.Cookie, idToken string) (string, []*http.Cookie) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	tokenCookie.SetIDToken(w, idToken)
+
+	// the browser keeps previous cookies unless they are overwritten or removed
+	jar := make(map[string]*http.Cookie)
+	for _, c := range prev {
+		jar[c.Name] = c
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(jar, c.Name)
+			continue
+		}
+		jar[c.Name] = c
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	cookies := make([]*http.Cookie, 0, len(jar))
+	for _, c := range jar {
+		r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+		cookies = append(cookies, c)
+	}
+	return tokenCookie.GetIDToken(r), cookies
+}
+
+func TestTokenCookie_IDTokenChunks(t *testing.T) {
+	policy := adapter.DefaultCookiePolicy()
+	policy.Prefix = adapter.CookiePrefixHost
+	policy.ChunkSize = 100
+	tokenCookie := adapter.NewTokenCookie(policy, nil, "tid", "id_token", "token_source")
+
+	long := strings.Repeat("a", 250)
+	got, cookies := roundTrip(t, tokenCookie, nil, long)
+	if got != long {
+		t.Fatalf("GetIDToken() = %d bytes, want %d", len(got), len(long))
+	}
+	if len(cookies) != 3 {
+		t.Errorf("stored %d cookies, want 3 chunks", len(cookies))
+	}
+	for _, c := range cookies {
+		if !strings.HasPrefix(c.Name, "__Host-id_token.") {
+			t.Errorf("unexpected cookie name %v", c.Name)
+		}
+	}
+
+	shorter := strings.Repeat("b", 150)
+	got, cookies = roundTrip(t, tokenCookie, cookies, shorter)
+	if got != shorter {
+		t.Fatalf("GetIDToken() = %q, want %q", got, shorter)
+	}
+
+	short := "c"
+	got, cookies = roundTrip(t, tokenCookie, cookies, short)
+	if got != short {
+		t.Fatalf("GetIDToken() = %q, want %q", got, short)
+	}
+
+	// more than 10 chunks would be truncated on read, so the cookie is cleared on write
+	got, cookies = roundTrip(t, tokenCookie, cookies, long)
+	if got != long {
+		t.Fatalf("GetIDToken() = %d bytes, want %d", len(got), len(long))
+	}
+	got, cookies = roundTrip(t, tokenCookie, cookies, strings.Repeat("d", 1001))
+	if got != "" {
+		t.Fatalf("GetIDToken() of an oversized id token = %d bytes, want none", len(got))
+	}
+	if len(cookies) > 2 {
+		t.Errorf("stored %d cookies of an oversized id token", len(cookies))
+	}
+	atLimit := strings.Repeat("e", 1000)
+	if got, _ = roundTrip(t, tokenCookie, cookies, atLimit); got != atLimit {
+		t.Fatalf("GetIDToken() = %d bytes, want %d", len(got), len(atLimit))
+	}
+}
+
+func TestCookiePolicy_Validate(t *testing.T) {
+	policy := adapter.DefaultCookiePolicy()
+	policy.Prefix = adapter.CookiePrefixHost
+	policy.Domain = "woong.com"
+	if err := policy.Validate(); err == nil {
+		t.Error("__Host- cookie with domain should be rejected")
+	}
+
+	policy.Prefix = adapter.CookiePrefixSecure
+	if err := policy.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTokenCookie_SetPolicy(t *testing.T) {
+	policy := adapter.DefaultCookiePolicy()
+	tokenCookie := adapter.NewTokenCookie(policy, nil, "tid", "id_token", "token_source")
+
+	policy.Domain = "woong.com"
+	if err := tokenCookie.SetPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	tokenCookie.SetIDToken(w, "a")
+	if c := w.Result().Cookies()[0]; c.Domain != "woong.com" {
+		t.Errorf("Domain = %v, want woong.com", c.Domain)
+	}
+
+	policy.Domain = ""
+	policy.Prefix = adapter.CookiePrefixHost
+	if err := tokenCookie.SetPolicy(policy); err != adapter.ErrCookiePrefixChanged {
+		t.Errorf("SetPolicy() = %v, want %v", err, adapter.ErrCookiePrefixChanged)
+	}
+}
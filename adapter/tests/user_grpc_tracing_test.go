@@ -0,0 +1,35 @@
+package adapter_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/tracing"
+	commondto "github.com/w-woong/common/dto"
+	"google.golang.org/grpc/metadata"
+)
+
+type userSvc struct {
+	md metadata.MD
+}
+
+func (s *userSvc) RegisterUser(ctx context.Context, user commondto.User) (commondto.User, error) {
+	s.md, _ = metadata.FromOutgoingContext(ctx)
+	return user, nil
+}
+
+func TestUserGrpcTracing(t *testing.T) {
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := tracing.Extract(context.Background(), header)
+
+	next := &userSvc{}
+	if _, err := adapter.NewUserGrpcTracing(next).RegisterUser(ctx, commondto.User{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := next.md.Get("traceparent"); len(got) != 1 || got[0] != header.Get("traceparent") {
+		t.Errorf("metadata = %v", next.md)
+	}
+}
@@ -1,37 +1,179 @@
 package adapter
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+)
+
+const (
+	// DefaultCookieChunkSize keeps a cookie with its attributes under 4KB limit of browsers.
+	DefaultCookieChunkSize = 3800
+
+	CookiePrefixHost   = "__Host-"
+	CookiePrefixSecure = "__Secure-"
+
+	maxCookieChunks = 10
 )
 
+// CookiePolicy holds attributes of cookies set by TokenCookie.
+type CookiePolicy struct {
+	Domain      string
+	Path        string
+	SameSite    http.SameSite
+	ExpireAfter time.Duration
+	// Prefix is prepended to cookie names, either empty, __Host- or __Secure-.
+	Prefix string
+	// ChunkSize is the maximum length of a value stored in a single cookie.
+	ChunkSize int
+}
+
+// DefaultCookiePolicy is the policy TokenCookie used before it became configurable.
+func DefaultCookiePolicy() CookiePolicy {
+	return CookiePolicy{
+		Path:        "/",
+		SameSite:    http.SameSiteStrictMode,
+		ExpireAfter: 1 * time.Hour,
+		ChunkSize:   DefaultCookieChunkSize,
+	}
+}
+
+// Validate checks constraints browsers enforce on prefixed cookies. Secure is always set,
+// which SameSite=None and both prefixes require.
+func (p CookiePolicy) Validate() error {
+	switch p.Prefix {
+	case "", CookiePrefixSecure:
+	case CookiePrefixHost:
+		if p.Domain != "" || p.Path != "/" {
+			return errors.New("__Host- cookies must have path / and no domain")
+		}
+	default:
+		return errors.New("cookie prefix must be empty, __Host- or __Secure-")
+	}
+	return nil
+}
+
+// ErrCookiePrefixChanged is returned when a reloaded policy changes the prefix, which renames cookies.
+var ErrCookiePrefixChanged = errors.New("cookie prefix cannot be changed without a restart")
+
+// cookiePolicyValue holds a policy which may be replaced while cookies are set.
+type cookiePolicyValue struct {
+	mu     sync.RWMutex
+	policy CookiePolicy
+}
+
+func newCookiePolicyValue(policy CookiePolicy) *cookiePolicyValue {
+	return &cookiePolicyValue{
+		policy: policy,
+	}
+}
+
+func (v *cookiePolicyValue) get() CookiePolicy {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.policy
+}
+
+// set replaces the policy, but not its prefix which cookie names are made of.
+func (v *cookiePolicyValue) set(policy CookiePolicy) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if policy.Prefix != v.policy.Prefix {
+		return ErrCookiePrefixChanged
+	}
+	v.policy = policy
+	return nil
+}
+
+// ParseSameSite parses strict, lax or none. Empty string means strict.
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "", "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return http.SameSiteDefaultMode, errors.New("invalid same site mode " + s)
+}
+
 type TokenCookie struct {
-	expireAfter         time.Duration
+	policy              *cookiePolicyValue
+	codec               port.CookieCodec
 	tokenIdentifierName string
 	idTokenName         string
 	tokenSourceName     string
 }
 
-func NewTokenCookie(expireAfter time.Duration,
+// NewTokenCookie creates TokenCookie. Values are encoded with codec unless it is nil.
+func NewTokenCookie(policy CookiePolicy, codec port.CookieCodec,
 	tokenIdentifierName, idTokenName, tokenSourceName string) *TokenCookie {
 
+	policy = tokenCookiePolicy(policy)
 	return &TokenCookie{
-		expireAfter:         expireAfter,
-		tokenIdentifierName: tokenIdentifierName,
-		idTokenName:         idTokenName,
-		tokenSourceName:     tokenSourceName,
+		policy:              newCookiePolicyValue(policy),
+		codec:               codec,
+		tokenIdentifierName: policy.Prefix + tokenIdentifierName,
+		idTokenName:         policy.Prefix + idTokenName,
+		tokenSourceName:     policy.Prefix + tokenSourceName,
 	}
 }
 
-func set(w http.ResponseWriter, sameSiteMode http.SameSite, name, value string, expireAfter time.Duration, maxAge int) {
+func tokenCookiePolicy(policy CookiePolicy) CookiePolicy {
+	if policy.Path == "" {
+		policy.Path = "/"
+	}
+	if policy.ChunkSize <= 0 {
+		policy.ChunkSize = DefaultCookieChunkSize
+	}
+	return policy
+}
+
+// SetPolicy replaces the policy of cookies set from now on.
+func (a *TokenCookie) SetPolicy(policy CookiePolicy) error {
+	return a.policy.set(tokenCookiePolicy(policy))
+}
+
+func (a *TokenCookie) set(w http.ResponseWriter, name, value string) {
+	setCookie(w, a.policy.get(), name, value)
+}
+
+func (a *TokenCookie) remove(w http.ResponseWriter, name string) {
+	removeCookie(w, a.policy.get(), name)
+}
+
+func setCookie(w http.ResponseWriter, policy CookiePolicy, name, value string) {
 	cookie := http.Cookie{
 		Name:     name,
 		Value:    value,
 		HttpOnly: true,
-		SameSite: sameSiteMode,
-		Path:     "/",
-		Expires:  time.Now().Add(expireAfter),
-		MaxAge:   maxAge,
+		SameSite: policy.SameSite,
+		Domain:   policy.Domain,
+		Path:     policy.Path,
+		Expires:  time.Now().Add(policy.ExpireAfter),
+		MaxAge:   int(policy.ExpireAfter.Seconds()),
+		Secure:   true,
+	}
+	http.SetCookie(w, &cookie)
+}
+
+func removeCookie(w http.ResponseWriter, policy CookiePolicy, name string) {
+	cookie := http.Cookie{
+		Name:     name,
+		Value:    "",
+		HttpOnly: true,
+		SameSite: policy.SameSite,
+		Domain:   policy.Domain,
+		Path:     policy.Path,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
 		Secure:   true,
 	}
 	http.SetCookie(w, &cookie)
@@ -45,26 +187,104 @@ func get(r *http.Request, name string) string {
 	return cookie.Value
 }
 
+// setChunked stores value in name, or across name.0, name.1... when it is longer than the chunk size.
+// The cookie following the last chunk is removed so that stale chunks are not reassembled. A value
+// needing more than maxCookieChunks chunks, which getChunked would truncate, clears the cookie instead.
+func (a *TokenCookie) setChunked(w http.ResponseWriter, name, value string) {
+	size := a.policy.get().ChunkSize
+	if len(value) > size*maxCookieChunks {
+		logger.Error(name + " of " + strconv.Itoa(len(value)) + " bytes needs more than " +
+			strconv.Itoa(maxCookieChunks) + " cookies")
+		a.remove(w, name)
+		a.remove(w, chunkName(name, 0))
+		return
+	}
+	if len(value) <= size {
+		a.set(w, name, value)
+		a.remove(w, chunkName(name, 0))
+		return
+	}
+
+	i := 0
+	for ; len(value) > 0; i++ {
+		n := size
+		if len(value) < n {
+			n = len(value)
+		}
+		a.set(w, chunkName(name, i), value[:n])
+		value = value[n:]
+	}
+	a.remove(w, chunkName(name, i))
+	a.remove(w, name)
+}
+
+// getChunked reassembles chunks set by setChunked.
+func (a *TokenCookie) getChunked(r *http.Request, name string) string {
+	if _, err := r.Cookie(chunkName(name, 0)); err != nil {
+		return get(r, name)
+	}
+
+	var b strings.Builder
+	for i := 0; i < maxCookieChunks; i++ {
+		cookie, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			break
+		}
+		b.WriteString(cookie.Value)
+	}
+	return b.String()
+}
+
+func chunkName(name string, i int) string {
+	return name + "." + strconv.Itoa(i)
+}
+
+// encode encodes value with the codec. Empty values are left as they are to clear cookies.
+func (a *TokenCookie) encode(name, value string) string {
+	if a.codec == nil || value == "" {
+		return value
+	}
+	encoded, err := a.codec.Encode(name, value)
+	if err != nil {
+		logger.Error(err.Error())
+		return ""
+	}
+	return encoded
+}
+
+// decode decodes value with the codec. Stale or tampered values are treated as absent.
+func (a *TokenCookie) decode(name, value string) string {
+	if a.codec == nil || value == "" {
+		return value
+	}
+	decoded, err := a.codec.Decode(name, value)
+	if err != nil {
+		logger.Error(name + ": " + err.Error())
+		return ""
+	}
+	return decoded
+}
+
 func (a *TokenCookie) GetTokenIdentifier(r *http.Request) string {
-	return get(r, a.tokenIdentifierName)
+	return a.decode(a.tokenIdentifierName, get(r, a.tokenIdentifierName))
 }
 
 func (a *TokenCookie) SetTokenIdentifier(w http.ResponseWriter, tokenIdentifier string) {
-	set(w, http.SameSiteStrictMode, a.tokenIdentifierName, tokenIdentifier, a.expireAfter, 0)
+	a.set(w, a.tokenIdentifierName, a.encode(a.tokenIdentifierName, tokenIdentifier))
 }
 
 func (a *TokenCookie) GetIDToken(r *http.Request) string {
-	return get(r, a.idTokenName)
+	return a.decode(a.idTokenName, a.getChunked(r, a.idTokenName))
 }
 
 func (a *TokenCookie) SetIDToken(w http.ResponseWriter, idToken string) {
-	set(w, http.SameSiteStrictMode, a.idTokenName, idToken, a.expireAfter, 0)
+	a.setChunked(w, a.idTokenName, a.encode(a.idTokenName, idToken))
 }
 
 func (a *TokenCookie) GetTokenSource(r *http.Request) string {
-	return get(r, a.tokenSourceName)
+	return a.decode(a.tokenSourceName, get(r, a.tokenSourceName))
 }
 
 func (a *TokenCookie) SetTokenSource(w http.ResponseWriter, tokenSource string) {
-	set(w, http.SameSiteStrictMode, a.tokenSourceName, tokenSource, a.expireAfter, 0)
+	a.set(w, a.tokenSourceName, a.encode(a.tokenSourceName, tokenSource))
 }
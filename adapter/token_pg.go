@@ -4,10 +4,11 @@ import (
 	"context"
 
 	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
 	"github.com/w-woong/common"
-	"github.com/w-woong/common/logger"
 	"github.com/w-woong/common/txcom"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type tokenPg struct {
@@ -25,7 +26,7 @@ func (a *tokenPg) Create(ctx context.Context, tx common.TxController, token enti
 	res := tx.(*txcom.GormTxController).Tx.WithContext(ctx).Create(&token)
 	if res.Error != nil {
 		logger.Error(res.Error.Error())
-		return 0, txcom.ConvertErr(res.Error)
+		return 0, convertErr(res.Error)
 	}
 
 	return res.RowsAffected, nil
@@ -39,6 +40,45 @@ func (a *tokenPg) ReadNoTx(ctx context.Context, id string) (entity.Token, error)
 	return a.readToken(ctx, a.db, id)
 }
 
+func (a *tokenPg) ReadForUpdate(ctx context.Context, tx common.TxController, id string) (entity.Token, error) {
+	return a.readToken(ctx, tx.(*txcom.GormTxController).Tx.Clauses(clause.Locking{Strength: "UPDATE"}), id)
+}
+
+func (a *tokenPg) ReadBySubject(ctx context.Context, tx common.TxController, tokenSource entity.TokenSource, subject string) ([]entity.Token, error) {
+	return a.readTokens(ctx, tx.(*txcom.GormTxController).Tx.Clauses(clause.Locking{Strength: "UPDATE"}),
+		"token_source = ? and subject = ?", tokenSource, subject)
+}
+
+func (a *tokenPg) ReadByUserID(ctx context.Context, userID string) ([]entity.Token, error) {
+	return a.readTokens(ctx, a.db, "user_id = ?", userID)
+}
+
+func (a *tokenPg) ReadRefreshable(ctx context.Context, expiry int64, maxFailures, limit int) ([]entity.Token, error) {
+	tokens := make([]entity.Token, 0)
+	res := a.db.WithContext(ctx).
+		Where("refresh_token <> '' and expiry < ? and refresh_failures < ?", expiry, maxFailures).
+		Order("expiry").
+		Limit(limit).Find(&tokens)
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return nil, txcom.ConvertErr(res.Error)
+	}
+	return tokens, nil
+}
+
+func (a *tokenPg) Update(ctx context.Context, tx common.TxController, token entity.Token) (int64, error) {
+	res := tx.(*txcom.GormTxController).Tx.
+		WithContext(ctx).
+		Model(&entity.Token{ID: token.ID}).
+		Select("*").Omit("id", "created_at").
+		Updates(&token)
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return 0, convertErr(res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
 func (a *tokenPg) Delete(ctx context.Context, tx common.TxController, id string) (int64, error) {
 	res := tx.(*txcom.GormTxController).Tx.
 		WithContext(ctx).
@@ -66,3 +106,16 @@ func (a *tokenPg) readToken(ctx context.Context, db *gorm.DB, id string) (entity
 
 	return token, nil
 }
+
+func (a *tokenPg) readTokens(ctx context.Context, db *gorm.DB, query string, args ...interface{}) ([]entity.Token, error) {
+	tokens := make([]entity.Token, 0)
+	res := db.WithContext(ctx).
+		Where(query, args...).
+		Order("created_at desc").
+		Find(&tokens)
+	if res.Error != nil {
+		logger.Error(res.Error.Error())
+		return nil, txcom.ConvertErr(res.Error)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,33 @@
+package adapter
+
+import (
+	"context"
+
+	"github.com/w-woong/auth/tracing"
+	commondto "github.com/w-woong/common/dto"
+	commonport "github.com/w-woong/common/port"
+)
+
+// userGrpcTracing is a client span of every call to a user service over grpc, whose connection has
+// no tracing interceptors. The span is propagated as grpc metadata.
+type userGrpcTracing struct {
+	next commonport.UserSvc
+}
+
+func NewUserGrpcTracing(next commonport.UserSvc) *userGrpcTracing {
+	return &userGrpcTracing{
+		next: next,
+	}
+}
+
+func (a *userGrpcTracing) RegisterUser(ctx context.Context, user commondto.User) (commondto.User, error) {
+	ctx, span := tracing.StartKind(ctx, "UserSvc.RegisterUser", tracing.KindClient)
+	defer span.End()
+	span.SetAttribute("rpc.system", "grpc")
+
+	registered, err := a.next.RegisterUser(tracing.InjectGrpc(ctx), user)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return registered, err
+}
@@ -0,0 +1,404 @@
+package authtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/authtest"
+	"github.com/w-woong/auth/client"
+	"github.com/w-woong/auth/cmd/route"
+	"github.com/w-woong/auth/delivery"
+	"github.com/w-woong/auth/dto"
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/usecase"
+	commondto "github.com/w-woong/common/dto"
+	"github.com/w-woong/common/txcom"
+	"github.com/w-woong/common/utils"
+)
+
+// authServer is the auth service wired like cmd with the map driver, in front of provider.
+type authServer struct {
+	*httptest.Server
+	provider  *authtest.Provider
+	tokenRepo *adapter.MapToken
+	handler   *delivery.AuthorizeHandler
+}
+
+func newAuthServer(t *testing.T) *authServer {
+	t.Helper()
+	provider := authtest.NewProvider("client1", "secret1")
+	t.Cleanup(provider.Close)
+
+	router := mux.NewRouter()
+	server := httptest.NewTLSServer(router)
+	t.Cleanup(server.Close)
+
+	openIDConf, err := utils.GetOpenIDConfig(provider.DiscoveryURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksUrl, err := utils.GetJwksUrl(provider.DiscoveryURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator, err := adapter.NewJwksIDTokenValidator(http.DefaultClient, jwksUrl, time.Hour,
+		openIDConf["issuer"].(string), "client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(validator.Close)
+
+	tokenRepo := adapter.NewMapToken()
+	tokenUsc := usecase.NewTokenUsc(adapter.NewMapTxBeginner(), tokenRepo,
+		entity.TokenSource("test"), openIDConf, provider.OauthConfig(server.URL+"/v1/auth/callback/test"),
+		validator, nil, 0, 0, false)
+	authRequestUsc := usecase.NewAuthRequest(
+		server.URL+"/v1/auth/request/test/{auth_request_id}",
+		server.URL+"/v1/auth/authorize/test/{auth_request_id}",
+		"cluster1", "X-Signature", "signal secret",
+		adapter.NewMapTxBeginner(), adapter.NewMapAuthRequest())
+	stateCookie := adapter.NewStateCookie(adapter.DefaultCookiePolicy(), "auth_state", []byte("state secret"))
+	authStateUsc := usecase.NewAuthStateUsc(adapter.NewMapTxBeginner(), adapter.NewMapAuthState(), nil, stateCookie)
+
+	tokenCookie := adapter.NewTokenCookie(adapter.DefaultCookiePolicy(), nil, "tid", "id_token", "token_source")
+	tokenHeader := adapter.NewTokenHeader("tid", "id_token", "token_source")
+	tokenGetter, err := usecase.NewTokenGetter([]port.CredentialExtractor{
+		adapter.NewTokenCookieExtractor("cookie", tokenCookie),
+		adapter.NewTokenCookieExtractor("header", tokenHeader),
+	}, []string{"cookie", "header"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	completePage, err := delivery.NewCompletePage("../cmd/resources/html", "auth_complete", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditor := delivery.NewAuditor(usecase.NewAuditUsc(txcom.NewLockTxBeginner(), adapter.NewMapAudit(), 0), 0)
+
+	handler := route.AuthorizeHandlerRoute(router, tokenUsc, authStateUsc, authRequestUsc, tokenGetter,
+		usecase.NewTokenSetter(tokenCookie, tokenHeader), 5*time.Second, completePage, nil, auditor, "")
+
+	return &authServer{Server: server, provider: provider, tokenRepo: tokenRepo, handler: handler}
+}
+
+// browser keeps cookies and follows redirects, both to the auth service and to the provider.
+func (s *authServer) browser(t *testing.T) *http.Client {
+	t.Helper()
+	client := s.Client()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Jar = jar
+	return client
+}
+
+// login runs request, wait, authorize and callback, and returns the token the waiter received.
+func (s *authServer) login(t *testing.T, browser *http.Client) commondto.Token {
+	t.Helper()
+	authRequest := s.requestAuth(t)
+	waited := s.wait(t, authRequest)
+	s.waitForWaiter(t)
+	s.authorize(t, browser, authRequest)
+
+	token, ok := <-waited
+	if !ok || token.ID == "" || token.IDToken == "" {
+		t.Fatalf("waiter received %+v", token)
+	}
+	return token
+}
+
+func (s *authServer) requestAuth(t *testing.T) dto.AuthRequest {
+	t.Helper()
+	res, err := s.Client().Get(s.URL + "/v1/auth/request/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authRequest := dto.AuthRequest{}
+	err = json.NewDecoder(res.Body).Decode(&struct {
+		Document *dto.AuthRequest `json:"document"`
+	}{Document: &authRequest})
+	res.Body.Close()
+	if err != nil || authRequest.PollSecret == "" {
+		t.Fatalf("AuthRequest() = %+v, %v", authRequest, err)
+	}
+	return authRequest
+}
+
+// wait long-polls authRequest, the channel is closed without a token if the wait fails.
+func (s *authServer) wait(t *testing.T, authRequest dto.AuthRequest) <-chan commondto.Token {
+	t.Helper()
+	waited := make(chan commondto.Token, 1)
+	go func() {
+		defer close(waited)
+		req, _ := http.NewRequest(http.MethodGet, s.URL+"/v1/auth/request/test/"+authRequest.ID, nil)
+		req.Header.Set(delivery.PollSecretHeader, authRequest.PollSecret)
+		res, err := s.Client().Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer res.Body.Close()
+		token := commondto.Token{}
+		if res.StatusCode == http.StatusOK && json.NewDecoder(res.Body).Decode(&token) == nil {
+			waited <- token
+		}
+	}()
+	return waited
+}
+
+// authorize runs authorize and callback of authRequest in browser.
+func (s *authServer) authorize(t *testing.T, browser *http.Client, authRequest dto.AuthRequest) {
+	t.Helper()
+	res, err := browser.Get(authRequest.AuthUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Request.URL.String(), s.URL+"/v1/auth/callback/test") {
+		t.Fatalf("callback at %v = %v", res.Request.URL, res.Status)
+	}
+}
+
+// waitForWaiter returns once the long-poll waiter is registered, signals are lost otherwise.
+func (s *authServer) waitForWaiter(t *testing.T) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if s.handler.Waiters() == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("waiter is not registered")
+}
+
+func (s *authServer) validate(t *testing.T, browser *http.Client) (int, commondto.Token) {
+	t.Helper()
+	res, err := browser.Get(s.URL + "/v1/auth/validate/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	token := commondto.Token{}
+	if res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode, token
+}
+
+// expire replaces the id_token of token, both stored and in the browser, with an expired one.
+func (s *authServer) expire(t *testing.T, browser *http.Client, token commondto.Token) {
+	t.Helper()
+	ctx := context.Background()
+	stored, err := s.tokenRepo.ReadNoTx(ctx, token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.IDToken = s.provider.IDToken(authtest.User{Subject: stored.Subject}, -time.Minute)
+	if _, err = s.tokenRepo.Update(ctx, nil, stored); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.URL)
+	browser.Jar.SetCookies(u, []*http.Cookie{{Name: "id_token", Value: stored.IDToken, Path: "/"}})
+}
+
+func TestAuthorizationFlow(t *testing.T) {
+	s := newAuthServer(t)
+	browser := s.browser(t)
+
+	token := s.login(t, browser)
+	stored, err := s.tokenRepo.ReadNoTx(context.Background(), token.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Subject != "sub1" || stored.Email != "wonk@woong.com" || stored.RefreshToken == "" {
+		t.Errorf("stored token = %+v", stored)
+	}
+
+	status, validated := s.validate(t, browser)
+	if status != http.StatusOK || validated.ID != token.ID {
+		t.Fatalf("validate = %v, %+v", status, validated)
+	}
+
+	// an expired id_token is refreshed at the provider
+	s.expire(t, browser, token)
+	status, refreshed := s.validate(t, browser)
+	if status != http.StatusOK || refreshed.ID != token.ID || s.provider.Issued() != 2 {
+		t.Fatalf("validate expired = %v, %+v, issued %v", status, refreshed, s.provider.Issued())
+	}
+	if status, _ = s.validate(t, browser); status != http.StatusOK {
+		t.Errorf("validate refreshed = %v", status)
+	}
+}
+
+// id_tokens signed with a key the provider rotated to are validated once jwks is fetched again, and
+// the ones signed before stay valid while the previous key is published.
+func TestAuthorizationFlow_KeyRotation(t *testing.T) {
+	s := newAuthServer(t)
+	browser := s.browser(t)
+	token := s.login(t, browser)
+
+	s.provider.RotateKey(false)
+	if status, _ := s.validate(t, browser); status != http.StatusOK {
+		t.Fatalf("validate with the previous key = %v", status)
+	}
+
+	// the refreshed id_token is signed with the new key
+	s.expire(t, browser, token)
+	status, refreshed := s.validate(t, browser)
+	if status != http.StatusOK || refreshed.ID != token.ID || s.provider.Issued() != 2 {
+		t.Fatalf("validate expired = %v, %+v, issued %v", status, refreshed, s.provider.Issued())
+	}
+	if status, _ = s.validate(t, browser); status != http.StatusOK {
+		t.Errorf("validate with the new key = %v", status)
+	}
+
+	if relogin := s.login(t, s.browser(t)); relogin.IDToken == "" {
+		t.Errorf("login after rotation = %+v", relogin)
+	}
+}
+
+func TestAuthorizationFlow_SignalWithoutWaiter(t *testing.T) {
+	s := newAuthServer(t)
+	browser := s.browser(t)
+
+	// the callback comes before the client waits, its signal is not delivered
+	authRequest := s.requestAuth(t)
+	s.authorize(t, browser, authRequest)
+
+	// the auth request is kept, the client waits and authorizes again
+	waited := s.wait(t, authRequest)
+	s.waitForWaiter(t)
+	s.authorize(t, browser, authRequest)
+	token, ok := <-waited
+	if !ok || token.ID == "" || token.IDToken == "" {
+		t.Fatalf("waiter received %+v", token)
+	}
+}
+
+func TestAuthorizationFlow_Scenarios(t *testing.T) {
+	t.Run("denied", func(t *testing.T) {
+		s := newAuthServer(t)
+		s.provider.SetScenario(authtest.Scenario{Deny: true})
+		res, err := s.Client().Get(s.URL + "/v1/auth/request/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		authRequest := dto.AuthRequest{}
+		json.NewDecoder(res.Body).Decode(&struct {
+			Document *dto.AuthRequest `json:"document"`
+		}{Document: &authRequest})
+		res.Body.Close()
+
+		res, err = s.browser(t).Get(authRequest.AuthUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest || res.Request.URL.Query().Get("error") != "access_denied" {
+			t.Errorf("callback at %v = %v", res.Request.URL, res.Status)
+		}
+	})
+
+	t.Run("refresh rejected", func(t *testing.T) {
+		s := newAuthServer(t)
+		browser := s.browser(t)
+		token := s.login(t, browser)
+		s.provider.SetScenario(authtest.Scenario{RejectRefresh: true})
+		s.expire(t, browser, token)
+		if status, _ := s.validate(t, browser); status != http.StatusInternalServerError {
+			t.Errorf("validate = %v", status)
+		}
+		if _, err := s.tokenRepo.ReadNoTx(context.Background(), token.ID); err == nil {
+			t.Error("the token of a rejected refresh should be removed")
+		}
+	})
+
+	t.Run("no refresh token", func(t *testing.T) {
+		s := newAuthServer(t)
+		s.provider.SetScenario(authtest.Scenario{NoRefreshToken: true})
+		browser := s.browser(t)
+		token := s.login(t, browser)
+		if stored, _ := s.tokenRepo.ReadNoTx(context.Background(), token.ID); stored.RefreshToken != "" {
+			t.Errorf("RefreshToken = %v", stored.RefreshToken)
+		}
+		if status, _ := s.validate(t, browser); status != http.StatusOK {
+			t.Errorf("validate = %v", status)
+		}
+		// without a refresh token the expired token is removed, not retried on every request
+		s.expire(t, browser, token)
+		if status, _ := s.validate(t, browser); status != http.StatusInternalServerError {
+			t.Errorf("validate expired = %v", status)
+		}
+		if _, err := s.tokenRepo.ReadNoTx(context.Background(), token.ID); err == nil {
+			t.Error("the expired token without a refresh token should be removed")
+		}
+	})
+}
+
+func TestClient(t *testing.T) {
+	s := newAuthServer(t)
+	ctx := context.Background()
+	c := client.NewClient(s.Client(), s.URL, "test", "tid", "id_token", "token_source")
+	c.SetRetry(2, 10*time.Millisecond)
+
+	login, err := c.StartLogin(ctx, client.LoginOptions{DeviceID: "device1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waited := make(chan error, 1)
+	go func() {
+		_, err := login.Wait(ctx)
+		waited <- err
+	}()
+	s.waitForWaiter(t)
+	res, err := s.browser(t).Get(login.AuthUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if err = <-waited; err != nil {
+		t.Fatal(err)
+	}
+	token := c.Token()
+	if token.ID == "" || token.IDToken == "" {
+		t.Fatalf("Wait() = %+v", token)
+	}
+
+	if validated, err := c.Validate(ctx); err != nil || validated.ID != token.ID {
+		t.Fatalf("Validate() = %+v, %v", validated, err)
+	}
+	if refreshed, err := c.Refresh(ctx); err != nil || refreshed.ID != token.ID || s.provider.Issued() != 2 {
+		t.Fatalf("Refresh() = %+v, %v, issued %v", refreshed, err, s.provider.Issued())
+	}
+
+	if err = c.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.provider.Revoked()) != 1 {
+		t.Errorf("Revoked() = %v", s.provider.Revoked())
+	}
+	if _, err = s.tokenRepo.ReadNoTx(ctx, token.ID); err == nil {
+		t.Error("the token of a logout should be removed")
+	}
+	if _, err = c.Validate(ctx); !errors.Is(err, client.ErrNoToken) {
+		t.Errorf("Validate() after Logout() = %v", err)
+	}
+	c.SetToken(token)
+	if _, err = c.Refresh(ctx); !errors.Is(err, client.ErrTokenRejected) {
+		t.Errorf("Refresh() of a removed token = %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+// Package authtest provides an OpenID Connect provider running in process, for tests which must
+// not reach google or kakao, and conformance suites every driver of repositories must pass.
+package authtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+)
+
+// User is who consents at the provider.
+type User struct {
+	Subject    string
+	Email      string
+	GivenName  string
+	FamilyName string
+}
+
+// Scenario scripts how the provider answers from now on.
+type Scenario struct {
+	// Deny redirects back with error=access_denied instead of a code.
+	Deny bool
+	// IDTokenTTL is the lifetime of issued id_tokens, 1 hour if 0. Negative ones are expired when issued.
+	IDTokenTTL time.Duration
+	// NoRefreshToken omits refresh_token, like providers do without consent.
+	NoRefreshToken bool
+	// RejectRefresh fails refresh grants with invalid_grant.
+	RejectRefresh bool
+}
+
+type signingKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+type grant struct {
+	user          User
+	redirectUri   string
+	codeChallenge string
+	scope         string
+}
+
+// Provider serves discovery, jwks, authorize, token, revocation and userinfo endpoints. Authorization
+// is consented to by User without any page.
+type Provider struct {
+	server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	mu            sync.Mutex
+	user          User
+	scenario      Scenario
+	keys          []signingKey
+	keyCount      int
+	codes         map[string]grant
+	accessTokens  map[string]User
+	refreshTokens map[string]User
+	revoked       []string
+	issued        int
+}
+
+// NewProvider starts Provider accepting clientID and clientSecret. Close it when done.
+func NewProvider(clientID, clientSecret string) *Provider {
+	p := &Provider{
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		user:          User{Subject: "sub1", Email: "wonk@woong.com", GivenName: "Wonk", FamilyName: "Woong"},
+		codes:         make(map[string]grant),
+		accessTokens:  make(map[string]User),
+		refreshTokens: make(map[string]User),
+	}
+	p.RotateKey(false)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/revoke", p.revoke)
+	mux.HandleFunc("/userinfo", p.userinfo)
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *Provider) Close() {
+	p.server.Close()
+}
+
+// URL is the issuer.
+func (p *Provider) URL() string {
+	return p.server.URL
+}
+
+func (p *Provider) DiscoveryURL() string {
+	return p.server.URL + "/.well-known/openid-configuration"
+}
+
+func (p *Provider) JwksURL() string {
+	return p.server.URL + "/jwks"
+}
+
+// OauthConfig is the client configuration of the provider, redirecting to redirectURL.
+func (p *Provider) OauthConfig(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   p.server.URL + "/authorize",
+			TokenURL:  p.server.URL + "/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+// SetUser changes who consents from now on.
+func (p *Provider) SetUser(user User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = user
+}
+
+func (p *Provider) SetScenario(scenario Scenario) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scenario = scenario
+}
+
+// RotateKey signs id_tokens with a new key from now on. Previous keys stay in jwks unless retire is set,
+// which makes id_tokens signed by them invalid.
+func (p *Provider) RotateKey(retire bool) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if retire {
+		p.keys = nil
+	}
+	p.keyCount++
+	p.keys = append(p.keys, signingKey{id: "key" + strconv.Itoa(p.keyCount), key: key})
+}
+
+// IDToken issues an id_token of user, expiring after ttl.
+func (p *Provider) IDToken(user User, ttl time.Duration) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idToken(user, ttl)
+}
+
+// Token issues tokens of the user, like a completed authorization does.
+func (p *Provider) Token() *oauth2.Token {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	accessToken := randomString(16)
+	p.accessTokens[accessToken] = p.user
+	token := &oauth2.Token{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Hour),
+	}
+	if !p.scenario.NoRefreshToken {
+		token.RefreshToken = randomString(16)
+		p.refreshTokens[token.RefreshToken] = p.user
+	}
+	return token.WithExtra(map[string]interface{}{"id_token": p.idToken(p.user, p.scenario.IDTokenTTL)})
+}
+
+// Revoked lists tokens revoked so far.
+func (p *Provider) Revoked() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.revoked...)
+}
+
+// Issued counts token responses, of both code and refresh grants.
+func (p *Provider) Issued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.issued
+}
+
+func (p *Provider) idToken(user User, ttl time.Duration) string {
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":         p.server.URL,
+		"sub":         user.Subject,
+		"aud":         p.ClientID,
+		"iat":         now.Unix(),
+		"exp":         now.Add(ttl).Unix(),
+		"email":       user.Email,
+		"given_name":  user.GivenName,
+		"family_name": user.FamilyName,
+		"sid":         randomString(8),
+	})
+	key := p.keys[len(p.keys)-1]
+	token.Header["kid"] = key.id
+	signed, err := token.SignedString(key.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/jwks",
+		"userinfo_endpoint":                     p.server.URL + "/userinfo",
+		"revocation_endpoint":                   p.server.URL + "/revoke",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	keys := make([]map[string]string, 0, len(p.keys))
+	for _, key := range p.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": key.id,
+			"n":   base64.RawURLEncoding.EncodeToString(key.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.key.E)).Bytes()),
+		})
+	}
+	p.mu.Unlock()
+	writeJson(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectUri, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	params := url.Values{}
+	params.Set("state", query.Get("state"))
+	if p.scenario.Deny {
+		params.Set("error", "access_denied")
+	} else {
+		code := randomString(16)
+		p.codes[code] = grant{
+			user:          p.user,
+			redirectUri:   redirectUri.String(),
+			codeChallenge: query.Get("code_challenge"),
+			scope:         query.Get("scope"),
+		}
+		params.Set("code", code)
+	}
+	redirectUri.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectUri.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		g, ok := p.codes[code]
+		if !ok || g.redirectUri != r.PostForm.Get("redirect_uri") ||
+			(g.codeChallenge != "" && g.codeChallenge != codeChallenge(r.PostForm.Get("code_verifier"))) {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(p.codes, code)
+		refreshToken := ""
+		if !p.scenario.NoRefreshToken {
+			refreshToken = randomString(16)
+			p.refreshTokens[refreshToken] = g.user
+		}
+		p.writeToken(w, g.user, refreshToken, g.scope)
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		user, ok := p.refreshTokens[refreshToken]
+		if !ok || p.scenario.RejectRefresh {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		// the refresh token is kept, like google does
+		p.writeToken(w, user, "", "")
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+	}
+}
+
+func (p *Provider) writeToken(w http.ResponseWriter, user User, refreshToken, scope string) {
+	accessToken := randomString(16)
+	p.accessTokens[accessToken] = user
+	p.issued++
+	body := map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.idToken(user, p.scenario.IDTokenTTL),
+	}
+	if refreshToken != "" {
+		body["refresh_token"] = refreshToken
+	}
+	if scope != "" {
+		body["scope"] = scope
+	}
+	writeJson(w, http.StatusOK, body)
+}
+
+func (p *Provider) revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	token := r.PostForm.Get("token")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.refreshTokens[token]; !ok {
+		if _, ok := p.accessTokens[token]; !ok {
+			writeError(w, http.StatusBadRequest, "invalid_token")
+			return
+		}
+	}
+	delete(p.refreshTokens, token)
+	delete(p.accessTokens, token)
+	p.revoked = append(p.revoked, token)
+	writeJson(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	user, ok := p.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	p.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"sub":         user.Subject,
+		"email":       user.Email,
+		"given_name":  user.GivenName,
+		"family_name": user.FamilyName,
+	})
+}
+
+func codeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeJson(w, status, map[string]interface{}{"error": code})
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
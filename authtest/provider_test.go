@@ -0,0 +1,119 @@
+package authtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/w-woong/auth/authtest"
+	"golang.org/x/oauth2"
+)
+
+func jwksKeyIDs(t *testing.T, p *authtest.Provider) []string {
+	t.Helper()
+	res, err := http.Get(p.JwksURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body := struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+		} `json:"keys"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(body.Keys))
+	for _, key := range body.Keys {
+		ids = append(ids, key.Kid)
+	}
+	return ids
+}
+
+func TestProvider_RotateKey(t *testing.T) {
+	p := authtest.NewProvider("client1", "secret1")
+	defer p.Close()
+
+	kid := func(idToken string) string {
+		token, _, err := jwt.NewParser().ParseUnverified(idToken, jwt.MapClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.Header["kid"].(string)
+	}
+	first := kid(p.IDToken(authtest.User{Subject: "sub1"}, time.Hour))
+
+	p.RotateKey(false)
+	second := kid(p.IDToken(authtest.User{Subject: "sub1"}, time.Hour))
+	if ids := jwksKeyIDs(t, p); first == second || len(ids) != 2 {
+		t.Errorf("jwks after rotation = %v, signed by %v", ids, second)
+	}
+
+	p.RotateKey(true)
+	if ids := jwksKeyIDs(t, p); len(ids) != 1 || ids[0] == first || ids[0] == second {
+		t.Errorf("jwks after retiring = %v", ids)
+	}
+}
+
+func TestProvider_Token(t *testing.T) {
+	ctx := context.Background()
+	p := authtest.NewProvider("client1", "secret1")
+	defer p.Close()
+	config := p.OauthConfig("https://localhost/callback")
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	authorize := func(challenge string) string {
+		res, err := noRedirect.Get(config.AuthCodeURL("state1",
+			oauth2.SetAuthURLParam("code_challenge", challenge),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		location, err := res.Location()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if location.Query().Get("state") != "state1" {
+			t.Errorf("redirected to %v", location)
+		}
+		return location.Query().Get("code")
+	}
+	// code challenge of the code verifier "verifier"
+	challenge := "iMnq5o6zALKXGivsnlom_0F5_WYda32GHkxlV7mq7hQ"
+
+	if _, err := config.Exchange(ctx, authorize(challenge), oauth2.SetAuthURLParam("code_verifier", "wrong")); err == nil {
+		t.Error("Exchange() with a wrong code verifier should fail")
+	}
+	token, err := config.Exchange(ctx, authorize(challenge), oauth2.SetAuthURLParam("code_verifier", "verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.RefreshToken == "" || token.Extra("id_token") == nil {
+		t.Errorf("token = %+v", token)
+	}
+
+	res, err := config.Client(ctx, token).Get(p.URL() + "/userinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("userinfo = %v", res.Status)
+	}
+
+	p.SetScenario(authtest.Scenario{RejectRefresh: true})
+	if _, err := config.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}).Token(); err == nil {
+		t.Error("refresh should be rejected")
+	}
+	p.SetScenario(authtest.Scenario{NoRefreshToken: true})
+	token, err = config.Exchange(ctx, authorize(""))
+	if err != nil || token.RefreshToken != "" {
+		t.Errorf("Exchange() without refresh token = %+v, %v", token, err)
+	}
+}
@@ -0,0 +1,343 @@
+package authtest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/common"
+)
+
+// RunTokenRepoSuite runs the conformance suite of port.TokenRepo. newRepo returns an empty repository
+// and the beginner of its transactions, both adapters of a driver must behave the same.
+func RunTokenRepoSuite(t *testing.T, newRepo func(t *testing.T) (common.TxBeginner, port.TokenRepo)) {
+	ctx := context.Background()
+
+	t.Run("not found", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			if _, err := repo.Read(ctx, tx, "missing"); !errors.Is(err, common.ErrRecordNotFound) {
+				t.Errorf("Read() = %v", err)
+			}
+			if _, err := repo.ReadForUpdate(ctx, tx, "missing"); !errors.Is(err, common.ErrRecordNotFound) {
+				t.Errorf("ReadForUpdate() = %v", err)
+			}
+			if tokens, err := repo.ReadBySubject(ctx, tx, "test", "missing"); err != nil || len(tokens) != 0 {
+				t.Errorf("ReadBySubject() = %v, %v", tokens, err)
+			}
+			expectAffected(t, "Update()", 0)(repo.Update(ctx, tx, newToken("missing")))
+			expectAffected(t, "Delete()", 0)(repo.Delete(ctx, tx, "missing"))
+		})
+		if _, err := repo.ReadNoTx(ctx, "missing"); !errors.Is(err, common.ErrRecordNotFound) {
+			t.Errorf("ReadNoTx() = %v", err)
+		}
+	})
+
+	t.Run("delete count", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, newToken("t1")))
+			expectAffected(t, "Delete()", 1)(repo.Delete(ctx, tx, "t1"))
+			expectAffected(t, "Delete() again", 0)(repo.Delete(ctx, tx, "t1"))
+		})
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, newToken("kept")))
+		})
+		inTx(t, beginner, false, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, newToken("t1")))
+			if token, err := repo.Read(ctx, tx, "t1"); err != nil || token.AccessToken != "access-t1" {
+				t.Errorf("Read() in tx = %+v, %v", token, err)
+			}
+			updated := newToken("kept")
+			updated.RefreshToken = "updated"
+			expectAffected(t, "Update()", 1)(repo.Update(ctx, tx, updated))
+			if token, err := repo.Read(ctx, tx, "kept"); err != nil || token.RefreshToken != "updated" {
+				t.Errorf("Read() updated in tx = %+v, %v", token, err)
+			}
+			expectAffected(t, "Delete()", 1)(repo.Delete(ctx, tx, "kept"))
+		})
+		if _, err := repo.ReadNoTx(ctx, "t1"); !errors.Is(err, common.ErrRecordNotFound) {
+			t.Errorf("ReadNoTx() of rolled back create = %v", err)
+		}
+		if token, err := repo.ReadNoTx(ctx, "kept"); err != nil || token.RefreshToken != "refresh-kept" {
+			t.Errorf("ReadNoTx() of rolled back update and delete = %+v, %v", token, err)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, newToken("t1")))
+		})
+		inTx(t, beginner, false, func(tx common.TxController) {
+			if _, err := repo.Create(ctx, tx, newToken("t1")); !errors.Is(err, port.ErrDuplicateRecord) {
+				t.Errorf("Create() of the same id = %v", err)
+			}
+		})
+		inTx(t, beginner, false, func(tx common.TxController) {
+			token := newToken("t2")
+			token.AccessToken = "access-t1"
+			if _, err := repo.Create(ctx, tx, token); !errors.Is(err, port.ErrDuplicateRecord) {
+				t.Errorf("Create() of the same access token = %v", err)
+			}
+		})
+		inTx(t, beginner, true, func(tx common.TxController) {
+			token := newToken("t3")
+			token.TokenSource = "other"
+			token.AccessToken = "access-t1"
+			expectAffected(t, "Create() of another token source", 1)(repo.Create(ctx, tx, token))
+		})
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, newToken("t1")))
+		})
+		affected := concurrently(t, beginner, func(tx common.TxController, i int) (int64, error) {
+			if _, err := repo.Create(ctx, tx, newToken("c"+strconv.Itoa(i))); err != nil {
+				return 0, err
+			}
+			return repo.Delete(ctx, tx, "t1")
+		})
+		if affected != 1 {
+			t.Errorf("Delete() of one token affected %v in total", affected)
+		}
+		inTx(t, beginner, true, func(tx common.TxController) {
+			if tokens, err := repo.ReadBySubject(ctx, tx, "test", "sub1"); err != nil || len(tokens) != concurrency {
+				t.Errorf("ReadBySubject() = %v tokens, %v", len(tokens), err)
+			}
+		})
+	})
+}
+
+// RunAuthStateRepoSuite runs the conformance suite of port.AuthStateRepo.
+func RunAuthStateRepoSuite(t *testing.T, newRepo func(t *testing.T) (common.TxBeginner, port.AuthStateRepo)) {
+	ctx := context.Background()
+
+	t.Run("not found", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			if _, err := repo.ReadByState(ctx, tx, "missing"); !errors.Is(err, common.ErrRecordNotFound) {
+				t.Errorf("ReadByState() = %v", err)
+			}
+			expectAffected(t, "DeleteByState()", 0)(repo.DeleteByState(ctx, tx, "missing"))
+		})
+	})
+
+	t.Run("delete count", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthState{State: "s1", CodeVerifier: "v1"}))
+			expectAffected(t, "DeleteByState()", 1)(repo.DeleteByState(ctx, tx, "s1"))
+			expectAffected(t, "DeleteByState() again", 0)(repo.DeleteByState(ctx, tx, "s1"))
+		})
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthState{State: "kept", CodeVerifier: "v1"}))
+		})
+		inTx(t, beginner, false, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthState{State: "s1", CodeVerifier: "v1"}))
+			if authState, err := repo.ReadByState(ctx, tx, "s1"); err != nil || authState.CodeVerifier != "v1" {
+				t.Errorf("ReadByState() in tx = %+v, %v", authState, err)
+			}
+			expectAffected(t, "DeleteByState()", 1)(repo.DeleteByState(ctx, tx, "kept"))
+		})
+		inTx(t, beginner, true, func(tx common.TxController) {
+			if _, err := repo.ReadByState(ctx, tx, "s1"); !errors.Is(err, common.ErrRecordNotFound) {
+				t.Errorf("ReadByState() of rolled back create = %v", err)
+			}
+			if _, err := repo.ReadByState(ctx, tx, "kept"); err != nil {
+				t.Errorf("ReadByState() of rolled back delete = %v", err)
+			}
+		})
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthState{State: "s1"}))
+		})
+		inTx(t, beginner, false, func(tx common.TxController) {
+			if _, err := repo.Create(ctx, tx, entity.AuthState{State: "s1"}); !errors.Is(err, port.ErrDuplicateRecord) {
+				t.Errorf("Create() of the same state = %v", err)
+			}
+		})
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthState{State: "s1"}))
+		})
+		affected := concurrently(t, beginner, func(tx common.TxController, i int) (int64, error) {
+			return repo.DeleteByState(ctx, tx, "s1")
+		})
+		if affected != 1 {
+			t.Errorf("DeleteByState() of one state affected %v in total", affected)
+		}
+	})
+}
+
+// RunAuthRequestRepoSuite runs the conformance suite of port.AuthRequestRepo.
+func RunAuthRequestRepoSuite(t *testing.T, newRepo func(t *testing.T) (common.TxBeginner, port.AuthRequestRepo)) {
+	ctx := context.Background()
+
+	t.Run("not found", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			if _, err := repo.Read(ctx, tx, "missing"); !errors.Is(err, common.ErrRecordNotFound) {
+				t.Errorf("Read() = %v", err)
+			}
+			expectAffected(t, "UpdateResponseUrl()", 0)(repo.UpdateResponseUrl(ctx, tx, "missing", "https://response"))
+			expectAffected(t, "Delete()", 0)(repo.Delete(ctx, tx, "missing"))
+		})
+		if _, err := repo.ReadNoTx(ctx, "missing"); !errors.Is(err, common.ErrRecordNotFound) {
+			t.Errorf("ReadNoTx() = %v", err)
+		}
+	})
+
+	t.Run("delete count", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthRequest{ID: "r1"}))
+			expectAffected(t, "Delete()", 1)(repo.Delete(ctx, tx, "r1"))
+			expectAffected(t, "Delete() again", 0)(repo.Delete(ctx, tx, "r1"))
+		})
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthRequest{ID: "kept", ResponseUrl: "https://kept"}))
+		})
+		inTx(t, beginner, false, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthRequest{ID: "r1"}))
+			if _, err := repo.Read(ctx, tx, "r1"); err != nil {
+				t.Errorf("Read() in tx = %v", err)
+			}
+			expectAffected(t, "UpdateResponseUrl()", 1)(repo.UpdateResponseUrl(ctx, tx, "kept", "https://updated"))
+			if authRequest, err := repo.Read(ctx, tx, "kept"); err != nil || authRequest.ResponseUrl != "https://updated" {
+				t.Errorf("Read() updated in tx = %+v, %v", authRequest, err)
+			}
+		})
+		if _, err := repo.ReadNoTx(ctx, "r1"); !errors.Is(err, common.ErrRecordNotFound) {
+			t.Errorf("ReadNoTx() of rolled back create = %v", err)
+		}
+		if authRequest, err := repo.ReadNoTx(ctx, "kept"); err != nil || authRequest.ResponseUrl != "https://kept" {
+			t.Errorf("ReadNoTx() of rolled back update = %+v, %v", authRequest, err)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthRequest{ID: "r1"}))
+		})
+		inTx(t, beginner, false, func(tx common.TxController) {
+			if _, err := repo.Create(ctx, tx, entity.AuthRequest{ID: "r1"}); !errors.Is(err, port.ErrDuplicateRecord) {
+				t.Errorf("Create() of the same id = %v", err)
+			}
+		})
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		beginner, repo := newRepo(t)
+		inTx(t, beginner, true, func(tx common.TxController) {
+			expectAffected(t, "Create()", 1)(repo.Create(ctx, tx, entity.AuthRequest{ID: "r1"}))
+		})
+		affected := concurrently(t, beginner, func(tx common.TxController, i int) (int64, error) {
+			return repo.Delete(ctx, tx, "r1")
+		})
+		if affected != 1 {
+			t.Errorf("Delete() of one auth request affected %v in total", affected)
+		}
+	})
+}
+
+// concurrency is the number of transactions run at once by the concurrency tests.
+const concurrency = 8
+
+func newToken(id string) entity.Token {
+	return entity.Token{
+		ID:           id,
+		TokenSource:  "test",
+		AccessToken:  "access-" + id,
+		RefreshToken: "refresh-" + id,
+		Subject:      "sub1",
+		Device:       id,
+	}
+}
+
+// inTx runs f in a transaction of beginner, which is committed if commit is true and rolled back otherwise.
+func inTx(t *testing.T, beginner common.TxBeginner, commit bool, f func(tx common.TxController)) {
+	t.Helper()
+	tx, err := beginner.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	f(tx)
+	if commit {
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// concurrently runs f in concurrency transactions at once, and returns the sum of what they affected.
+// Transactions f fails are rolled back.
+func concurrently(t *testing.T, beginner common.TxBeginner, f func(tx common.TxController, i int) (int64, error)) int64 {
+	t.Helper()
+	var mu sync.Mutex
+	var total int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tx, err := beginner.Begin()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer tx.Rollback()
+			affected, err := f(tx, i)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err = tx.Commit(); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			total += affected
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	return total
+}
+
+// expectAffected returns a function checking results of a write, which affects want rows.
+func expectAffected(t *testing.T, name string, want int64) func(int64, error) {
+	t.Helper()
+	return func(affected int64, err error) {
+		t.Helper()
+		if err != nil || affected != want {
+			t.Errorf("%v = %v, %v, want %v", name, affected, err, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package authutil
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// IsAllowedRedirect reports whether raw is an absolute url whose scheme and host equal one of allowed
+// and whose path is under its path. Urls with userinfo, backslashes, control characters or
+// dot segments escaping the allowed path are never allowed.
+func IsAllowedRedirect(raw string, allowed []string) bool {
+	if raw == "" || strings.ContainsAny(raw, "\\\t\r\n ") {
+		return false
+	}
+	for _, c := range raw {
+		if c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Opaque != "" || u.User != nil || u.Host == "" {
+		return false
+	}
+	if strings.Contains(u.Path, "\\") {
+		return false
+	}
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	if cleaned := path.Clean(p); cleaned != p && cleaned+"/" != p {
+		// dot segments or duplicate slashes
+		return false
+	}
+
+	for _, a := range allowed {
+		au, err := url.Parse(a)
+		if err != nil || au.Scheme == "" || au.Host == "" {
+			continue
+		}
+		if !strings.EqualFold(u.Scheme, au.Scheme) || !strings.EqualFold(u.Host, au.Host) {
+			continue
+		}
+		if hasPathPrefix(p, au.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPathPrefix reports whether p is prefix itself or under prefix directory.
+func hasPathPrefix(p, prefix string) bool {
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return strings.HasSuffix(prefix, "/") || len(p) == len(prefix) || p[len(prefix)] == '/'
+}
@@ -0,0 +1,246 @@
+// Package client implements the login flow of the auth service for Go apps: request an auth request,
+// open its auth url, wait for the token and validate, refresh or log out with it.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	commondto "github.com/w-woong/common/dto"
+)
+
+var (
+	// ErrNoToken is returned by calls requiring a token before login or after logout.
+	ErrNoToken = errors.New("no token, log in first")
+	// ErrTokenRejected is returned when the service rejected and removed the token, the user must
+	// log in again.
+	ErrTokenRejected = errors.New("token is rejected")
+)
+
+// StatusError is returned when the service answers with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "auth service responded " + e.Status
+}
+
+const (
+	defaultRetries   = 2
+	defaultRetryWait = time.Second
+)
+
+// Client calls the auth service of a token source. Credentials are sent in headers named like those of
+// adapter.TokenHeader, and cookies as well if the http client has a jar, so either extractor of the
+// service finds them.
+type Client struct {
+	httpClient  *http.Client
+	baseUrl     string
+	tokenSource string
+
+	tokenIdentifierName string
+	idTokenName         string
+	tokenSourceName     string
+
+	mu        sync.RWMutex
+	retries   int
+	retryWait time.Duration
+	token     commondto.Token
+}
+
+// NewClient creates Client of the service at baseUrl. tokenIdentifierName, idTokenName and
+// tokenSourceName are the names of client.oauth2.token of the service, like tid, id_token and token_source.
+func NewClient(httpClient *http.Client, baseUrl, tokenSource,
+	tokenIdentifierName, idTokenName, tokenSourceName string) *Client {
+	return &Client{
+		httpClient:          httpClient,
+		baseUrl:             strings.TrimSuffix(baseUrl, "/"),
+		tokenSource:         tokenSource,
+		tokenIdentifierName: tokenIdentifierName,
+		idTokenName:         idTokenName,
+		tokenSourceName:     tokenSourceName,
+		retries:             defaultRetries,
+		retryWait:           defaultRetryWait,
+	}
+}
+
+// SetRetry sets how many times calls are retried after timeouts and unavailable responses, and how
+// long to wait before each retry.
+func (c *Client) SetRetry(retries int, wait time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retries = retries
+	c.retryWait = wait
+}
+
+func (c *Client) retry() (int, time.Duration) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.retries, c.retryWait
+}
+
+// Token returns the current token, which apps may keep to restore it with SetToken.
+func (c *Client) Token() commondto.Token {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+func (c *Client) SetToken(token commondto.Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// Validate validates the current token. The service refreshes it if it has expired or is about to.
+func (c *Client) Validate(ctx context.Context) (commondto.Token, error) {
+	return c.tokenCall(ctx, http.MethodGet, "/v1/auth/validate/")
+}
+
+// Refresh refreshes the current token at the provider, even if it has not expired yet.
+func (c *Client) Refresh(ctx context.Context) (commondto.Token, error) {
+	return c.tokenCall(ctx, http.MethodPost, "/v1/auth/refresh/")
+}
+
+// Logout removes the current token from the service, which revokes it at the provider.
+func (c *Client) Logout(ctx context.Context) error {
+	token := c.Token()
+	if token.ID == "" {
+		return ErrNoToken
+	}
+	res, err := c.do(ctx, http.MethodPost, c.baseUrl+"/v1/auth/logout/"+c.tokenSource, token, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+	c.SetToken(commondto.Token{})
+	return nil
+}
+
+func (c *Client) tokenCall(ctx context.Context, method, path string) (commondto.Token, error) {
+	token := c.Token()
+	if token.ID == "" {
+		return commondto.Token{}, ErrNoToken
+	}
+	res, err := c.do(ctx, method, c.baseUrl+path+c.tokenSource, token, nil)
+	if err != nil {
+		return commondto.Token{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		// the service clears credentials of tokens it has removed
+		if res.StatusCode == http.StatusUnauthorized || cleared(res.Header, c.tokenIdentifierName) {
+			c.SetToken(commondto.Token{})
+			return commondto.Token{}, ErrTokenRejected
+		}
+		return commondto.Token{}, &StatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+
+	received := commondto.Token{}
+	if err = json.NewDecoder(res.Body).Decode(&received); err != nil {
+		return commondto.Token{}, err
+	}
+	// the body hides what the app must not see, the rest of the token is kept
+	if received.ID != "" {
+		token.ID = received.ID
+	}
+	if received.IDToken != "" {
+		token.IDToken = received.IDToken
+	}
+	if received.TokenSource != "" {
+		token.TokenSource = received.TokenSource
+	}
+	token.Expiry = received.Expiry
+	c.SetToken(token)
+	return token, nil
+}
+
+// do sends a request with credentials of token, retrying it after timeouts and on 502, 503 and 504.
+func (c *Client) do(ctx context.Context, method, url string, token commondto.Token,
+	header http.Header) (*http.Response, error) {
+	retries, retryWait := c.retry()
+	var res *http.Response
+	var err error
+	for i := 0; ; i++ {
+		res, err = c.send(ctx, method, url, token, header)
+		if i >= retries || !retryable(res, err) {
+			return res, err
+		}
+		if res != nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+		if err = sleep(ctx, retryWait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, url string, token commondto.Token,
+	header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if token.ID != "" {
+		req.Header.Set(c.tokenIdentifierName, token.ID)
+		req.Header.Set(c.idTokenName, token.IDToken)
+		req.Header.Set(c.tokenSourceName, c.tokenSource)
+	}
+	return c.httpClient.Do(req)
+}
+
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// cleared reports whether header sets name to empty, which is how the service removes credentials.
+func cleared(header http.Header, name string) bool {
+	values, ok := header[http.CanonicalHeaderKey(name)]
+	return ok && (len(values) == 0 || values[0] == "")
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryAfter is Retry-After of res in seconds, or def.
+func retryAfter(res *http.Response, def time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return def
+}
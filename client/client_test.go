@@ -0,0 +1,81 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/w-woong/auth/client"
+)
+
+func TestLogin_Wait(t *testing.T) {
+	var mu sync.Mutex
+	var waits []string
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/v1/auth/request/test", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":200,"count":1,"document":{"id":"ar1","auth_url":"https://auth","poll_secret":"secret1"}}`))
+	})
+	wait := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		waits = append(waits, r.URL.Path)
+		n := len(waits)
+		mu.Unlock()
+		if r.Header.Get("X-Poll-Secret") != "secret1" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		switch n {
+		case 1:
+			// longer than the client waits
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		case 2:
+			// the poll secret is not sent to other hosts
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":503,"retry":true,"retry_url":"http://other.example/ar1"}`))
+		case 3:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":503,"retry":true,"retry_url":"` + server.URL + `/other/ar1"}`))
+		default:
+			w.Write([]byte(`{"tid":"tid1","id_token":"id1","token_source":"test","sessions_evicted":2}`))
+		}
+	}
+	mux.HandleFunc("/v1/auth/request/test/ar1", wait)
+	mux.HandleFunc("/other/ar1", wait)
+
+	c := client.NewClient(&http.Client{Timeout: 100 * time.Millisecond}, server.URL, "test",
+		"tid", "id_token", "token_source")
+	c.SetRetry(2, 10*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	login, err := c.StartLogin(ctx, client.LoginOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.ID != "ar1" || login.AuthUrl != "https://auth" {
+		t.Errorf("StartLogin() = %+v", login)
+	}
+	token, err := login.Wait(ctx)
+	if err != nil || token.ID != "tid1" || c.Token().IDToken != "id1" {
+		t.Fatalf("Wait() = %+v, %v", token, err)
+	}
+	if login.SessionsEvicted != 2 {
+		t.Errorf("SessionsEvicted = %d", login.SessionsEvicted)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(waits) != 4 || waits[2] != "/v1/auth/request/test/ar1" || waits[3] != "/other/ar1" {
+		t.Errorf("waits = %v", waits)
+	}
+}
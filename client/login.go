@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/w-woong/auth/dto"
+	"github.com/w-woong/common"
+	commondto "github.com/w-woong/common/dto"
+)
+
+// pollSecretHeader is delivery.PollSecretHeader, which the client does not import to stay light.
+const pollSecretHeader = "X-Poll-Secret"
+
+// LoginOptions are optional parameters of an auth request.
+type LoginOptions struct {
+	// ClientID selects the completion page of the client app.
+	ClientID string
+	// RedirectUri is the deep link the completion page leads to, allowed for ClientID.
+	RedirectUri string
+	// DeviceID identifies the installation, a token is kept per user and device.
+	DeviceID string
+}
+
+// Login is an auth request in progress. The user authorizes by opening AuthUrl in a browser while
+// Wait waits for the token.
+type Login struct {
+	ID      string
+	AuthUrl string
+	// SessionsEvicted is the number of sessions of the user on other devices signed out by the login,
+	// known once Wait returns the token.
+	SessionsEvicted int
+
+	client     *Client
+	pollSecret string
+	waitUrl    string
+}
+
+// StartLogin creates an auth request.
+func (c *Client) StartLogin(ctx context.Context, opts LoginOptions) (*Login, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"client_id":    opts.ClientID,
+		"redirect_uri": opts.RedirectUri,
+		"device_id":    opts.DeviceID,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	requestUrl := c.baseUrl + "/v1/auth/request/" + c.tokenSource
+	if len(query) > 0 {
+		requestUrl += "?" + query.Encode()
+	}
+
+	res, err := c.do(ctx, http.MethodGet, requestUrl, commondto.Token{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+
+	authRequest := dto.AuthRequest{}
+	body := common.HttpBody{Document: &authRequest}
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if authRequest.ID == "" || authRequest.PollSecret == "" {
+		return nil, errors.New("auth request has no id or poll secret")
+	}
+	return &Login{
+		ID:         authRequest.ID,
+		AuthUrl:    authRequest.AuthUrl,
+		client:     c,
+		pollSecret: authRequest.PollSecret,
+		waitUrl:    c.baseUrl + "/v1/auth/request/" + c.tokenSource + "/" + url.PathEscape(authRequest.ID),
+	}, nil
+}
+
+// Wait waits for the token of the login, which becomes the token of the client. It waits again after
+// timeouts, gateways cutting the long poll and instances shutting down, until ctx is done.
+func (l *Login) Wait(ctx context.Context) (commondto.Token, error) {
+	header := http.Header{}
+	header.Set(pollSecretHeader, l.pollSecret)
+	for {
+		_, retryWait := l.client.retry()
+		res, err := l.client.send(ctx, http.MethodGet, l.waitUrl, commondto.Token{}, header)
+		if err != nil {
+			if ctx.Err() != nil || !retryable(nil, err) {
+				return commondto.Token{}, err
+			}
+			if err = sleep(ctx, retryWait); err != nil {
+				return commondto.Token{}, err
+			}
+			continue
+		}
+
+		token, retry, err := l.read(res)
+		if err != nil || !retry {
+			return token, err
+		}
+		if err = sleep(ctx, retryAfter(res, retryWait)); err != nil {
+			return commondto.Token{}, err
+		}
+	}
+}
+
+// sameOrigin reports whether retryUrl has the scheme and host of the service, where the poll secret may
+// be sent.
+func (l *Login) sameOrigin(retryUrl string) bool {
+	if retryUrl == "" {
+		return false
+	}
+	retry, err := url.Parse(retryUrl)
+	if err != nil {
+		return false
+	}
+	base, err := url.Parse(l.client.baseUrl)
+	if err != nil {
+		return false
+	}
+	return retry.Scheme == base.Scheme && retry.Host == base.Host
+}
+
+// read reads the response of a wait, and reports whether to wait again.
+func (l *Login) read(res *http.Response) (commondto.Token, bool, error) {
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		token := dto.SignalToken{}
+		if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+			return commondto.Token{}, false, err
+		}
+		l.SessionsEvicted = token.SessionsEvicted
+		l.client.SetToken(token.Token)
+		return token.Token, false, nil
+	case http.StatusServiceUnavailable:
+		// a draining instance tells where to wait again
+		body := struct {
+			Retry    bool   `json:"retry"`
+			RetryUrl string `json:"retry_url"`
+		}{}
+		if json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&body) == nil && l.sameOrigin(body.RetryUrl) {
+			l.waitUrl = body.RetryUrl
+		}
+		return commondto.Token{}, true, nil
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return commondto.Token{}, true, nil
+	}
+	return commondto.Token{}, false, &StatusError{StatusCode: res.StatusCode, Status: res.Status}
+}
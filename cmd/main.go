@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
@@ -17,18 +21,20 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/w-woong/auth/adapter"
 	"github.com/w-woong/auth/cmd/route"
+	"github.com/w-woong/auth/config"
+	"github.com/w-woong/auth/delivery"
 	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/metrics"
 	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/tracing"
 	"github.com/w-woong/auth/usecase"
 	"github.com/w-woong/common"
 	commonadapter "github.com/w-woong/common/adapter"
-	"github.com/w-woong/common/configs"
-	"github.com/w-woong/common/logger"
 	commonport "github.com/w-woong/common/port"
 	"github.com/w-woong/common/txcom"
 	"github.com/w-woong/common/utils"
 	"github.com/w-woong/common/wrapper"
-	"golang.org/x/oauth2"
 	"gorm.io/gorm"
 
 	// "go.elastic.co/apm/module/apmgorilla/v2"
@@ -52,14 +58,18 @@ var (
 	usePprof    = false
 	pprofAddr   = ":56060"
 	autoMigrate = false
+
+	allowExecSecrets = false
+
+	metricsAddr = "localhost:5559"
 )
 
 func init() {
 	flag.StringVar(&addr, "addr", ":5558", "listen address")
 	flag.BoolVar(&printVersion, "version", false, "print version")
 	flag.IntVar(&tickIntervalSec, "tick", 30, "tick interval in second")
-	flag.StringVar(&certKey, "key", "./certs/key.pem", "server key")
-	flag.StringVar(&certPem, "pem", "./certs/cert.pem", "server pem")
+	flag.StringVar(&certKey, "key", "./certs/key.pem", "server key, a path or a secret reference")
+	flag.StringVar(&certPem, "pem", "./certs/cert.pem", "server pem, a path or a secret reference")
 	flag.IntVar(&readTimeout, "readTimeout", 30, "read timeout")
 	flag.IntVar(&writeTimeout, "writeTimeout", 30, "write timeout")
 	flag.StringVar(&configName, "config", "./configs/server-google.yml", "config file name")
@@ -68,18 +78,18 @@ func init() {
 	flag.BoolVar(&usePprof, "pprof", false, "use pprof")
 	flag.StringVar(&pprofAddr, "pprof_addr", ":56060", "pprof listen address")
 	flag.BoolVar(&autoMigrate, "autoMigrate", false, "auto migrate")
-
-	flag.Parse()
+	flag.BoolVar(&allowExecSecrets, "allowExecSecrets", false, "resolve exec:// secret references")
+	flag.StringVar(&metricsAddr, "metricsAddr", "localhost:5559", "internal listen address of /metrics, none if empty")
 }
 
 func main() {
+	flag.Parse()
 	if printVersion {
 		fmt.Printf("version \"%v\"\n", Version)
 		return
 	}
 	runtime.GOMAXPROCS(maxProc)
 
-	var err error
 	// apm
 	apmActive, _ := strconv.ParseBool(os.Getenv("ELASTIC_APM_ACTIVE"))
 	if apmActive {
@@ -88,23 +98,61 @@ func main() {
 	}
 
 	// config
-	conf := common.Config{}
-	if err := configs.ReadConfigInto(configName, &conf); err != nil {
+	conf, authConf, err := readConfig(configName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	secretUsc, err := newSecretUsc(authConf, allowExecSecrets)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err = resolveSecrets(secretUsc, &conf, &authConf); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	conf.Client.Oauth2.RedirectUrl = strings.ReplaceAll(conf.Client.Oauth2.RedirectUrl, "{token_source}", conf.Client.Oauth2.Token.Source)
-	conf.Client.Oauth2.AuthRequest.ResponseUrl = strings.ReplaceAll(conf.Client.Oauth2.AuthRequest.ResponseUrl, "{token_source}", conf.Client.Oauth2.Token.Source)
-	conf.Client.Oauth2.AuthRequest.AuthUrl = strings.ReplaceAll(conf.Client.Oauth2.AuthRequest.AuthUrl, "{token_source}", conf.Client.Oauth2.Token.Source)
 
-	// logger
-	logger.Open(conf.Logger.Level, conf.Logger.Stdout,
+	// logger, its level is reloaded
+	if err = logger.Open(conf.Logger.Level, conf.Logger.Stdout,
 		conf.Logger.File.Name, conf.Logger.File.MaxSize, conf.Logger.File.MaxBackup,
-		conf.Logger.File.MaxAge, conf.Logger.File.Compressed)
+		conf.Logger.File.MaxAge, conf.Logger.File.Compressed); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	defer logger.Close()
 
+	// tracing
+	switch authConf.Auth.Tracing.Exporter {
+	case "otlp":
+		serviceName := authConf.Auth.Tracing.ServiceName
+		if serviceName == "" {
+			serviceName = "auth"
+		}
+		otlpTracer, err := tracing.NewOtlpTracer(context.Background(), authConf.Auth.Tracing.Endpoint,
+			serviceName, authConf.Auth.Tracing.SampleRatio)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			otlpTracer.Shutdown(ctx)
+		}()
+		tracing.SetTracer(otlpTracer)
+	case "apm", "":
+		if apmActive {
+			tracing.SetTracer(tracing.NewApmTracer(apm.DefaultTracer()))
+		}
+	default:
+		logger.Error(authConf.Auth.Tracing.Exporter + " is not allowed for tracing exporter")
+		os.Exit(1)
+	}
+
 	// db, gorm
 	var gormDB *gorm.DB
+	var healthChecks []port.HealthCheck
 	switch conf.Server.Repo.Driver {
 	case "pgx":
 		if apmActive {
@@ -121,6 +169,7 @@ func main() {
 				os.Exit(1)
 			}
 			defer db.Close()
+			healthChecks = append(healthChecks, adapter.NewDBHealthCheck(db))
 		} else {
 			// db
 			// var db *sql.DB
@@ -131,6 +180,7 @@ func main() {
 				os.Exit(1)
 			}
 			defer db.Close()
+			healthChecks = append(healthChecks, adapter.NewDBHealthCheck(db))
 
 			gormDB, err = sigorm.OpenPostgresWithConfig(db,
 				&gorm.Config{Logger: logger.OpenGormLogger(conf.Server.Repo.LogLevel)},
@@ -150,21 +200,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	clientID := os.Getenv("CLIENT_ID")
-	if conf.Client.Oauth2.ClientID != "" {
-		clientID = conf.Client.Oauth2.ClientID
-	}
-	clientSecret := os.Getenv("CLIENT_SECRET")
-	if conf.Client.Oauth2.ClientSecret != "" {
-		clientSecret = conf.Client.Oauth2.ClientSecret
-	}
-	redirectURL := os.Getenv("REDIRECT_URL")
-	if conf.Client.Oauth2.RedirectUrl != "" {
-		redirectURL = conf.Client.Oauth2.RedirectUrl
-	}
-	scopes := conf.Client.Oauth2.Scopes
-	authUrl := conf.Client.Oauth2.AuthUrl
-	tokenUrl := conf.Client.Oauth2.TokenUrl
 	openIDConf, err := utils.GetOpenIDConfig(conf.Client.Oauth2.OpenIDConfUrl)
 	if err != nil {
 		logger.Error(err.Error())
@@ -177,7 +212,22 @@ func main() {
 	}
 	// repo
 
-	tokenCookie := adapter.NewTokenCookie(1*time.Hour, conf.Client.Oauth2.Token.IDKeyName, conf.Client.Oauth2.Token.IDTokenKeyName, conf.Client.Oauth2.Token.TokenSourceKeyName)
+	cookiePolicy, stateCookiePolicy, err := newCookiePolicies(authConf)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	aesGcmCookieCodec, err := newCookieCodec(authConf)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	var cookieCodec port.CookieCodec
+	if aesGcmCookieCodec != nil {
+		cookieCodec = aesGcmCookieCodec
+	}
+	adapterTokenCookie := adapter.NewTokenCookie(cookiePolicy, cookieCodec, conf.Client.Oauth2.Token.IDKeyName, conf.Client.Oauth2.Token.IDTokenKeyName, conf.Client.Oauth2.Token.TokenSourceKeyName)
+	var tokenCookie port.TokenCookie = adapterTokenCookie
 	tokenHeader := adapter.NewTokenHeader(conf.Client.Oauth2.Token.IDKeyName, conf.Client.Oauth2.Token.IDTokenKeyName, conf.Client.Oauth2.Token.TokenSourceKeyName)
 
 	var tokenTxBeginner common.TxBeginner
@@ -186,6 +236,10 @@ func main() {
 	var authStateRepo port.AuthStateRepo
 	var authRequestTxBeginner common.RWTxBeginner
 	var authRequestRepo port.AuthRequestRepo
+	var sessionTxBeginner common.TxBeginner
+	var sessionRepo port.SessionRepo
+	var auditTxBeginner common.TxBeginner
+	var auditRepo port.AuditRepo
 	switch conf.Server.Repo.Driver {
 	case "pgx":
 		tokenTxBeginner = txcom.NewGormTxBeginner(gormDB)
@@ -194,108 +248,344 @@ func main() {
 		authStateRepo = adapter.NewAuthStatePg(gormDB)
 		authRequestTxBeginner = txcom.NewGormTxBeginner(gormDB)
 		authRequestRepo = adapter.NewAuthRequestPg(gormDB)
+		sessionTxBeginner = txcom.NewGormTxBeginner(gormDB)
+		sessionRepo = adapter.NewSessionPg(gormDB)
+		auditTxBeginner = txcom.NewGormTxBeginner(gormDB)
+		auditRepo = adapter.NewAuditPg(gormDB)
 
 	case "map":
-		tokenTxBeginner = txcom.NewLockTxBeginner()
+		tokenTxBeginner = adapter.NewMapTxBeginner()
 		tokenRepo = adapter.NewMapToken()
 
-		authStateTxBeginner = txcom.NewLockTxBeginner()
+		authStateTxBeginner = adapter.NewMapTxBeginner()
 		authStateRepo = adapter.NewMapAuthState()
-		authRequestTxBeginner = txcom.NewLockTxBeginner()
+		authRequestTxBeginner = adapter.NewMapTxBeginner()
 		authRequestRepo = adapter.NewMapAuthRequest()
+		sessionTxBeginner = txcom.NewLockTxBeginner()
+		sessionRepo = adapter.NewMapSession()
+		auditTxBeginner = txcom.NewLockTxBeginner()
+		auditRepo = adapter.NewMapAudit()
 	default:
 		logger.Error(conf.Server.Repo.Driver + " is not allowed")
 		os.Exit(1)
 	}
 
+	var rateLimitTxBeginner common.TxBeginner
+	var rateLimitRepo port.RateLimitRepo
+	switch authConf.Auth.RateLimit.Store {
+	case "db":
+		if gormDB == nil {
+			logger.Error("rate limit store db requires pgx driver")
+			os.Exit(1)
+		}
+		rateLimitTxBeginner = txcom.NewGormTxBeginner(gormDB)
+		rateLimitRepo = adapter.NewRateLimitPg(gormDB)
+	case "map", "":
+		rateLimitTxBeginner = txcom.NewLockTxBeginner()
+		rateLimitRepo = adapter.NewMapRateLimit()
+	default:
+		logger.Error(authConf.Auth.RateLimit.Store + " is not allowed for rate limit store")
+		os.Exit(1)
+	}
+
 	if autoMigrate {
-		gormDB.AutoMigrate(&entity.Token{}, &entity.AuthState{}, &entity.AuthRequest{})
+		gormDB.AutoMigrate(&entity.Token{}, &entity.AuthState{}, &entity.AuthRequest{}, &entity.RateLimitBucket{},
+			&entity.Session{}, &entity.AuditEvent{})
 	}
 	var userSvc commonport.UserSvc
 	if conf.Client.UserHttp.Url != "" {
-		userSvc = commonadapter.NewUserHttp(sihttp.DefaultInsecureClient(),
-			// conf.Client.Oauth2.Token.Source,
-			conf.Client.UserHttp.Url,
-			conf.Client.UserHttp.BearerToken,
-			conf.Client.Oauth2.Token.TokenSourceKeyName,
-			conf.Client.Oauth2.Token.IDKeyName, conf.Client.Oauth2.Token.IDTokenKeyName)
+		userSvc = newUserHttp(conf)
+		healthChecks = append(healthChecks,
+			adapter.NewHttpHealthCheck("user_service", sihttp.DefaultInsecureClient(), conf.Client.UserHttp.Url))
 	} else if conf.Client.UserGrpc.Addr != "" {
 		conn, err := wrapper.NewGrpcClient(conf.Client.UserGrpc, false)
 		if err != nil {
 			logger.Error(err.Error())
 			os.Exit(1)
 		}
-		userSvc = commonadapter.NewUserGrpc(conn)
+		userSvc = adapter.NewUserGrpcTracing(commonadapter.NewUserGrpc(conn))
+		healthChecks = append(healthChecks, adapter.NewGrpcHealthCheck("user_service", conn))
 	} else {
 		userSvc = commonadapter.NewUserSvcNop()
 	}
 
-	oauthConfig := oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  redirectURL,
-		Scopes:       scopes,
-		// Endpoint:     google.Endpoint,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:   authUrl,
-			TokenURL:  tokenUrl,
-			AuthStyle: oauth2.AuthStyleInParams,
-		},
+	// readiness checks are neither traced nor counted
+	healthClient := &http.Client{Transport: http.DefaultTransport}
+	jwksMaxAge := 24 * time.Hour
+	if authConf.Auth.Health.JwksMaxAge > 0 {
+		jwksMaxAge = time.Duration(authConf.Auth.Health.JwksMaxAge) * time.Second
 	}
-
-	jwksStore, err := utils.NewJwksCache(jwksUrl)
+	// the provider is called once per providerInterval, not on every probe
+	providerInterval := time.Minute
+	if authConf.Auth.Health.ProviderInterval > 0 {
+		providerInterval = time.Duration(authConf.Auth.Health.ProviderInterval) * time.Second
+	}
+	jwksHealthCheck := adapter.NewJwksHealthCheck(http.DefaultTransport, jwksUrl, jwksMaxAge)
+	healthChecks = append(healthChecks, jwksHealthCheck,
+		adapter.NewCachedHealthCheck(adapter.NewHttpHealthCheck("openid_configuration", healthClient,
+			conf.Client.Oauth2.OpenIDConfUrl), providerInterval),
+		adapter.NewCachedHealthCheck(adapter.NewHttpHealthCheck("token_endpoint", healthClient,
+			conf.Client.Oauth2.TokenUrl), providerInterval))
+
+	// jwks fetches are traced, counted and recorded for readiness. Keys are fetched again well
+	// within jwksMaxAge, so readiness fails only when fetches keep failing.
+	jwksClient := &http.Client{
+		Transport: tracing.NewTransport(metrics.NewJwksTransport(jwksHealthCheck)),
+		Timeout:   30 * time.Second,
+	}
+	issuer, _ := openIDConf["issuer"].(string)
+	if issuer == "" {
+		logger.Error("issuer is missing from the openid configuration")
+		os.Exit(1)
+	}
+	validator, err := adapter.NewJwksIDTokenValidator(jwksClient, jwksUrl, jwksMaxAge/4,
+		issuer, newOauthConfig(conf).ClientID)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
-	validator := commonadapter.NewJwksIDTokenValidator(jwksStore, conf.Client.Oauth2.Token.TokenSourceKeyName, conf.Client.Oauth2.Token.IDKeyName, conf.Client.Oauth2.Token.IDTokenKeyName)
+	defer validator.Close()
 
 	tokenUsc := usecase.NewTokenUsc(tokenTxBeginner, tokenRepo,
-		entity.TokenSource(conf.Client.Oauth2.Token.Source), openIDConf, &oauthConfig,
-		validator, userSvc)
+		entity.TokenSource(conf.Client.Oauth2.Token.Source), openIDConf, newOauthConfig(conf),
+		validator, userSvc, time.Duration(authConf.Auth.Refresh.Window)*time.Second,
+		authConf.Auth.Sessions.MaxPerUser, authConf.Auth.Sessions.RevokeEvicted)
+
+	var refreshTokens func(ctx context.Context) (int, error)
+	if authConf.Auth.Refresh.Background.Enabled {
+		// tokens expiring before the next tick are refreshed as well
+		refreshTokens = usecase.NewTokenRefresher(tokenUsc, tokenTxBeginner, tokenRepo,
+			time.Duration(authConf.Auth.Refresh.Window)*time.Second+time.Duration(tickIntervalSec)*time.Second,
+			authConf.Auth.Refresh.Background.Batch, authConf.Auth.Refresh.Background.MaxFailures).Refresh
+	}
 
 	authRequestUsc := usecase.NewAuthRequest(
 		conf.Client.Oauth2.AuthRequest.ResponseUrl,
 		conf.Client.Oauth2.AuthRequest.AuthUrl,
+		authConf.Auth.ClusterID,
+		authConf.Auth.Signal.HmacHeader, authConf.Auth.Signal.HmacSecret,
 		authRequestTxBeginner, authRequestRepo)
 
-	authStateUsc := usecase.NewAuthStateUsc(authStateTxBeginner, authStateRepo)
+	if err = checkSecrets(authConf); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	stateCookieName := authConf.Auth.StateCookie.Name
+	if stateCookieName == "" {
+		stateCookieName = "auth_state"
+	}
+	stateCookie := adapter.NewStateCookie(stateCookiePolicy, stateCookieName, []byte(authConf.Auth.StateCookie.Secret))
+
+	authStateUsc := usecase.NewAuthStateUsc(authStateTxBeginner, authStateRepo, authConf.Auth.ReturnTo.Allowed, stateCookie)
+
+	var tokenSetter port.TokenSetter
+	var purgeSessions func(ctx context.Context) (int64, error)
+	var sessionIDCookie *adapter.SessionIDCookie
+	if authConf.Auth.Session.Enabled {
+		sessionIDCookie = adapter.NewSessionIDCookie(cookiePolicy, authConf.Auth.Session.CookieName)
+		sessionCookie := usecase.NewSessionCookie(sessionTxBeginner, sessionRepo, sessionIDCookie)
+		tokenCookie = sessionCookie
+		purgeSessions = sessionCookie.Purge
+		// tokens are never written to headers readable by javascript
+		tokenSetter = usecase.NewTokenSetter(tokenCookie, adapter.NewNopTokenHeader())
+	} else {
+		tokenSetter = usecase.NewTokenSetter(tokenCookie, tokenHeader)
+	}
+	tokenConf := conf.Client.Oauth2.Token
+	credentialOrder := authConf.Auth.Credentials.Order
+	if len(credentialOrder) == 0 {
+		credentialOrder = []string{"cookie", "header"}
+	}
+	tokenGetter, err := usecase.NewTokenGetter([]port.CredentialExtractor{
+		adapter.NewTokenCookieExtractor("cookie", tokenCookie),
+		adapter.NewTokenCookieExtractor("header", tokenHeader),
+		adapter.NewBearerExtractor(),
+		adapter.NewQueryExtractor(tokenConf.IDKeyName, tokenConf.IDTokenKeyName, tokenConf.TokenSourceKeyName),
+		adapter.NewFormExtractor(tokenConf.IDKeyName, tokenConf.IDTokenKeyName, tokenConf.TokenSourceKeyName),
+	}, credentialOrder, authConf.Auth.Credentials.Routes)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	rateLimitUsc := usecase.NewRateLimitUsc(rateLimitTxBeginner, rateLimitRepo)
+	rateLimitRules := make(map[string]delivery.RateLimitRule)
+	for name, rule := range authConf.Auth.RateLimit.Routes {
+		rateLimitRules[name] = delivery.RateLimitRule{
+			IP:  delivery.RateLimit{Rate: rule.IP.Rate, Burst: rule.IP.Burst},
+			Tid: delivery.RateLimit{Rate: rule.Tid.Rate, Burst: rule.Tid.Burst},
+		}
+	}
+	rateLimiter := delivery.NewRateLimiter(rateLimitUsc, tokenGetter,
+		authConf.Auth.RateLimit.TrustedProxies, rateLimitRules)
+
+	completePage, err := newCompletePage(authConf)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-	tokenGetter := usecase.NewTokenGetter(tokenCookie, tokenHeader)
-	tokenSetter := usecase.NewTokenSetter(tokenCookie, tokenHeader)
+	var auditSinks []port.AuditSink
+	if authConf.Auth.Audit.File != "" {
+		auditFileSink, err := adapter.NewAuditFileSink(authConf.Auth.Audit.File)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		defer auditFileSink.Close()
+		auditSinks = append(auditSinks, auditFileSink)
+	}
+	auditUsc := usecase.NewAuditUsc(auditTxBeginner, auditRepo, authConf.Auth.Audit.QueueSize, auditSinks...)
+	auditor := delivery.NewAuditor(auditUsc, authConf.Auth.RateLimit.TrustedProxies)
 
 	// 라우터, gorilla mux를 쓴다
 	router := mux.NewRouter()
-	route.AuthorizeHandlerRoute(router, tokenUsc, authStateUsc, authRequestUsc,
-		tokenGetter, tokenSetter, time.Duration(conf.Client.Oauth2.AuthRequest.Wait)*time.Second)
+	router.Use(tracing.Middleware)
+	healthTimeout := 2 * time.Second
+	if authConf.Auth.Health.Timeout > 0 {
+		healthTimeout = time.Duration(authConf.Auth.Health.Timeout) * time.Second
+	}
+	healthUsc := usecase.NewHealthUsc(healthTimeout, healthChecks...)
+	route.HealthHandlerRoute(router, healthUsc)
+	retryUrl := strings.ReplaceAll(authConf.Auth.Shutdown.RetryUrl, "{token_source}", conf.Client.Oauth2.Token.Source)
+	authorizeHandler := route.AuthorizeHandlerRoute(router, tokenUsc, authStateUsc, authRequestUsc,
+		tokenGetter, tokenSetter, time.Duration(conf.Client.Oauth2.AuthRequest.Wait)*time.Second,
+		completePage, rateLimiter, auditor, retryUrl)
+	var auditHandler *delivery.AuditHandler
+	if authConf.Auth.Audit.ApiToken != "" {
+		auditHandler = route.AuditHandlerRoute(router, auditUsc, authConf.Auth.Audit.ApiToken)
+	}
 
-	// http 서버 생성
+	// http 서버 생성, cors is rebuilt on reload
+	handler := delivery.NewReloadableHandler(newCorsHandler(router, conf))
 	tlsConfig := sihttp.CreateTLSConfigMinTls(tls.VersionTLS12)
-	httpServer := sihttp.NewServerCors(router, tlsConfig, addr,
+	httpServer := sihttp.NewServer(handler, tlsConfig, addr,
 		time.Duration(writeTimeout)*time.Second, time.Duration(readTimeout)*time.Second,
-		certPem, certKey,
-		strings.Split(conf.Server.Http.AllowedOrigins, ","),
-		strings.Split(conf.Server.Http.AllowedHeaders, ","),
-		strings.Split(conf.Server.Http.AllowedMethods, ","),
-	)
+		certPem, certKey)
+
+	// config
+	reloader := &reloader{
+		configName:      configName,
+		conf:            conf,
+		authConf:        authConf,
+		secrets:         secretUsc,
+		tokenUsc:        tokenUsc,
+		authRequestUsc:  authRequestUsc,
+		validator:       validator,
+		tokenCookie:     adapterTokenCookie,
+		cookieCodec:     aesGcmCookieCodec,
+		sessionIDCookie: sessionIDCookie,
+		stateCookie:     stateCookie,
+		auditHandler:    auditHandler,
+		completePage:    completePage,
+		router:          router,
+		handler:         handler,
+	}
+	start := httpServer.Start
+	if strings.Contains(certPem, "://") || strings.Contains(certKey, "://") {
+		// the certificate is resolved from references, and replaced when they change
+		reloader.certPem, reloader.certKey = certPem, certKey
+		if err = reloader.loadCertificate(); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		tlsConfig.GetCertificate = reloader.GetCertificate
+		start = func() error {
+			return httpServer.Server.ListenAndServeTLS("", "")
+		}
+	}
+	config.Watch(configName, reloader.Reload)
+	secretRefreshInterval := 5 * time.Minute
+	if authConf.Auth.Secrets.RefreshInterval > 0 {
+		secretRefreshInterval = time.Duration(authConf.Auth.Secrets.RefreshInterval) * time.Second
+	}
+	secretRefreshedAt := time.Now()
 
 	// ticker
 	ticker := time.NewTicker(time.Duration(tickIntervalSec) * time.Second)
 	tickerDone := make(chan bool)
 	common.StartTicker(tickerDone, ticker, func(t time.Time) {
 		logger.Info(fmt.Sprintf("NoOfGR:%v, %v", runtime.NumGoroutine(), t))
+		if _, err := rateLimitUsc.Purge(context.Background(), time.Hour); err != nil {
+			logger.Error(err.Error())
+		}
+		if purgeSessions != nil {
+			if _, err := purgeSessions(context.Background()); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+		if refreshTokens != nil {
+			if _, err := refreshTokens(context.Background()); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+		if t.Sub(secretRefreshedAt) >= secretRefreshInterval {
+			secretRefreshedAt = t
+			ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+			changed, _ := secretUsc.Refresh(ctx)
+			cancel()
+			if changed {
+				reloader.Reload()
+			}
+		}
 	})
 
-	// signal, wait for it to shutdown http server.
-	common.StartSignalStopper(httpServer, syscall.SIGINT, syscall.SIGTERM)
+	// on signal, readiness fails and waiters are told to retry on another instance. In-flight requests,
+	// with the signals they send, complete within the grace period. Queued audit events are flushed after.
+	gracePeriod := 30 * time.Second
+	if authConf.Auth.Shutdown.GracePeriod > 0 {
+		gracePeriod = time.Duration(authConf.Auth.Shutdown.GracePeriod) * time.Second
+	}
+	stopSignal := make(chan os.Signal, 1)
+	signal.Notify(stopSignal, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-stopSignal
+		logger.Info("draining")
+		healthUsc.SetDraining(true)
+		authorizeHandler.Drain()
+
+		ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+		defer cancel()
+		if err := httpServer.Server.Shutdown(ctx); err != nil {
+			logger.Error(err.Error())
+			httpServer.Server.Close()
+		}
+	}()
+
+	// metrics are served on the internal address only
+	if metricsAddr != "" {
+		metricsRouter := mux.NewRouter()
+		metricsRouter.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+		metricsServer := &http.Server{
+			Addr:              metricsAddr,
+			Handler:           metricsRouter,
+			ReadHeaderTimeout: time.Duration(readTimeout) * time.Second,
+		}
+		go func() {
+			logger.Info("metrics listening on " + metricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error(err.Error())
+			}
+		}()
+		defer metricsServer.Close()
+	}
 
 	// start
 	logger.Info("start listening on " + addr)
-	if err = httpServer.Start(); err != nil {
+	if err = start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Error(err.Error())
+	} else {
+		<-stopped
 	}
 
-	// finish
+	// finish, queued audit events are stored and written before the sinks close
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	if err := auditUsc.Close(ctx); err != nil {
+		logger.Error("audit log is not flushed: " + err.Error())
+	}
+	cancel()
 	ticker.Stop()
 	tickerDone <- true
 	logger.Info("finished")
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-wonk/si/sihttp"
+	"github.com/gorilla/handlers"
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/config"
+	"github.com/w-woong/auth/delivery"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/tracing"
+	"github.com/w-woong/auth/usecase"
+	"github.com/w-woong/common"
+	commonadapter "github.com/w-woong/common/adapter"
+	"github.com/w-woong/common/configs"
+	commonport "github.com/w-woong/common/port"
+	"golang.org/x/oauth2"
+)
+
+// readConfig reads common and auth configurations of configName, with {token_source} of urls replaced.
+func readConfig(configName string) (common.Config, config.Config, error) {
+	conf := common.Config{}
+	if err := configs.ReadConfigInto(configName, &conf); err != nil {
+		return conf, config.Config{}, err
+	}
+	authConf := config.Config{}
+	if err := config.ReadConfigInto(configName, &authConf); err != nil {
+		return conf, authConf, err
+	}
+	conf.Client.Oauth2.RedirectUrl = strings.ReplaceAll(conf.Client.Oauth2.RedirectUrl, "{token_source}", conf.Client.Oauth2.Token.Source)
+	conf.Client.Oauth2.AuthRequest.ResponseUrl = strings.ReplaceAll(conf.Client.Oauth2.AuthRequest.ResponseUrl, "{token_source}", conf.Client.Oauth2.Token.Source)
+	conf.Client.Oauth2.AuthRequest.AuthUrl = strings.ReplaceAll(conf.Client.Oauth2.AuthRequest.AuthUrl, "{token_source}", conf.Client.Oauth2.Token.Source)
+	return conf, authConf, nil
+}
+
+// secretTimeout bounds resolving or refreshing all secrets of the configuration.
+const secretTimeout = 30 * time.Second
+
+// newSecretUsc creates SecretUsc of file and env references, of exec references if allowExec is set,
+// and of vault references if vault is configured.
+func newSecretUsc(authConf config.Config, allowExec bool) (*usecase.SecretUsc, error) {
+	providers := map[string]port.SecretProvider{
+		"file": adapter.NewFileSecret(),
+		"env":  adapter.NewEnvSecret(),
+	}
+	if allowExec {
+		providers["exec"] = adapter.NewExecSecret()
+	}
+	if authConf.Auth.Secrets.Vault.Addr != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+		defer cancel()
+		token, err := usecase.NewSecretUsc(providers).Resolve(ctx, authConf.Auth.Secrets.Vault.Token)
+		if err != nil {
+			return nil, err
+		}
+		providers["vault"] = adapter.NewVaultSecret(&http.Client{Timeout: secretTimeout},
+			authConf.Auth.Secrets.Vault.Addr, token)
+	}
+	return usecase.NewSecretUsc(providers), nil
+}
+
+// resolveSecrets replaces references of conf and authConf with what they refer to.
+func resolveSecrets(secrets port.SecretUsc, conf *common.Config, authConf *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+
+	var err error
+	for _, value := range secretValues(conf, authConf) {
+		if *value, err = secrets.Resolve(ctx, *value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secretValues returns the values of conf and authConf which may be references.
+func secretValues(conf *common.Config, authConf *config.Config) []*string {
+	values := []*string{
+		&conf.Client.Oauth2.ClientID,
+		&conf.Client.Oauth2.ClientSecret,
+		&conf.Client.UserHttp.BearerToken,
+		&conf.Server.Repo.ConnStr,
+		&authConf.Auth.Signal.HmacSecret,
+		&authConf.Auth.StateCookie.Secret,
+		&authConf.Auth.Audit.ApiToken,
+	}
+	// keys of a disabled codec are never read, their references need not exist
+	if authConf.Auth.Cookie.Codec.Enabled {
+		for i := range authConf.Auth.Cookie.Codec.Keys {
+			values = append(values, &authConf.Auth.Cookie.Codec.Keys[i].Secret)
+		}
+	}
+	return values
+}
+
+// newOauthConfig creates oauth2 config of conf. Client id, secret and redirect url fall back to
+// CLIENT_ID, CLIENT_SECRET and REDIRECT_URL.
+func newOauthConfig(conf common.Config) *oauth2.Config {
+	clientID := os.Getenv("CLIENT_ID")
+	if conf.Client.Oauth2.ClientID != "" {
+		clientID = conf.Client.Oauth2.ClientID
+	}
+	clientSecret := os.Getenv("CLIENT_SECRET")
+	if conf.Client.Oauth2.ClientSecret != "" {
+		clientSecret = conf.Client.Oauth2.ClientSecret
+	}
+	redirectURL := os.Getenv("REDIRECT_URL")
+	if conf.Client.Oauth2.RedirectUrl != "" {
+		redirectURL = conf.Client.Oauth2.RedirectUrl
+	}
+
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       conf.Client.Oauth2.Scopes,
+		// Endpoint:     google.Endpoint,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   conf.Client.Oauth2.AuthUrl,
+			TokenURL:  conf.Client.Oauth2.TokenUrl,
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+// newCookiePolicies creates policies of token and state cookies.
+func newCookiePolicies(authConf config.Config) (adapter.CookiePolicy, adapter.CookiePolicy, error) {
+	var err error
+	cookiePolicy := adapter.DefaultCookiePolicy()
+	if authConf.Auth.Cookie.ExpiresIn > 0 {
+		cookiePolicy.ExpireAfter = time.Duration(authConf.Auth.Cookie.ExpiresIn) * time.Second
+	}
+	if authConf.Auth.Cookie.Path != "" {
+		cookiePolicy.Path = authConf.Auth.Cookie.Path
+	}
+	if authConf.Auth.Cookie.ChunkSize > 0 {
+		cookiePolicy.ChunkSize = authConf.Auth.Cookie.ChunkSize
+	}
+	cookiePolicy.Domain = authConf.Auth.Cookie.Domain
+	cookiePolicy.Prefix = authConf.Auth.Cookie.Prefix
+	if cookiePolicy.SameSite, err = adapter.ParseSameSite(authConf.Auth.Cookie.SameSite); err != nil {
+		return cookiePolicy, cookiePolicy, err
+	}
+	if err = cookiePolicy.Validate(); err != nil {
+		return cookiePolicy, cookiePolicy, err
+	}
+
+	stateCookiePolicy := cookiePolicy
+	stateCookiePolicy.ExpireAfter = 10 * time.Minute
+	if authConf.Auth.StateCookie.ExpiresIn > 0 {
+		stateCookiePolicy.ExpireAfter = time.Duration(authConf.Auth.StateCookie.ExpiresIn) * time.Second
+	}
+	return cookiePolicy, stateCookiePolicy, nil
+}
+
+// newCookieCodec creates the codec of token cookies, nil if it is not enabled. Secrets of keys are
+// base64 encoded.
+func newCookieCodec(authConf config.Config) (*adapter.AesGcmCookieCodec, error) {
+	if !authConf.Auth.Cookie.Codec.Enabled {
+		return nil, nil
+	}
+	cookieKeys := make([]adapter.CookieKey, 0, len(authConf.Auth.Cookie.Codec.Keys))
+	for _, key := range authConf.Auth.Cookie.Codec.Keys {
+		secret, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		cookieKey := adapter.CookieKey{ID: key.ID, Key: secret}
+		if key.RetiredAt != "" {
+			if cookieKey.RetiredAt, err = time.Parse(time.RFC3339, key.RetiredAt); err != nil {
+				return nil, err
+			}
+		}
+		cookieKeys = append(cookieKeys, cookieKey)
+	}
+	return adapter.NewAesGcmCookieCodec(cookieKeys,
+		time.Duration(authConf.Auth.Cookie.Codec.MaxAge)*time.Second,
+		time.Duration(authConf.Auth.Cookie.Codec.GracePeriod)*time.Second)
+}
+
+// newUserHttp creates the user service of conf.Client.UserHttp.
+func newUserHttp(conf common.Config) commonport.UserSvc {
+	return commonadapter.NewUserHttp(tracing.NewClient(sihttp.DefaultInsecureClient()),
+		// conf.Client.Oauth2.Token.Source,
+		conf.Client.UserHttp.Url,
+		conf.Client.UserHttp.BearerToken,
+		conf.Client.Oauth2.Token.TokenSourceKeyName,
+		conf.Client.Oauth2.Token.IDKeyName, conf.Client.Oauth2.Token.IDTokenKeyName)
+}
+
+// checkSecrets checks secrets of authConf which can't be told apart from a mistake once applied.
+func checkSecrets(authConf config.Config) error {
+	if authConf.Auth.StateCookie.Secret == "" {
+		return errors.New("auth.state_cookie.secret is required")
+	}
+	if authConf.Auth.StateCookie.Secret == authConf.Auth.Signal.HmacSecret {
+		return errors.New("auth.state_cookie.secret must differ from auth.signal.hmac_secret")
+	}
+	return nil
+}
+
+func newCompletePage(authConf config.Config) (*delivery.CompletePage, error) {
+	completePageClients := make(map[string]delivery.CompletePageClient)
+	for clientID, client := range authConf.Auth.CompletePage.Clients {
+		completePageClients[clientID] = delivery.CompletePageClient{
+			Template:         client.Template,
+			AutoRedirect:     client.AutoRedirect,
+			AllowedRedirects: client.AllowedRedirects,
+		}
+	}
+	return delivery.NewCompletePage(authConf.Auth.CompletePage.Dir,
+		authConf.Auth.CompletePage.Template, completePageClients)
+}
+
+func newCorsHandler(router http.Handler, conf common.Config) http.Handler {
+	return handlers.CORS(
+		handlers.AllowedOrigins(strings.Split(conf.Server.Http.AllowedOrigins, ",")),
+		handlers.AllowedHeaders(strings.Split(conf.Server.Http.AllowedHeaders, ",")),
+		handlers.AllowedMethods(strings.Split(conf.Server.Http.AllowedMethods, ",")),
+	)(router)
+}
+
+// reloader applies the oauth2 config, cookie policies, cookie codec keys, secrets, cors lists,
+// complete page templates and the log level of configName whenever it or a secret of it changes.
+// Anything else, like repositories, the provider or listen addresses, takes a restart.
+type reloader struct {
+	mu         sync.Mutex
+	configName string
+	conf       common.Config
+	authConf   config.Config
+	secrets    port.SecretUsc
+
+	tokenUsc        *usecase.TokenUsc
+	authRequestUsc  *usecase.AuthRequest
+	validator       *adapter.JwksIDTokenValidator
+	tokenCookie     *adapter.TokenCookie
+	cookieCodec     *adapter.AesGcmCookieCodec
+	sessionIDCookie *adapter.SessionIDCookie
+	stateCookie     *adapter.StateCookie
+	auditHandler    *delivery.AuditHandler
+	completePage    *delivery.CompletePage
+	router          http.Handler
+	handler         *delivery.ReloadableHandler
+
+	// certPem and certKey are references of the tls certificate, which is served by GetCertificate.
+	certPem, certKey string
+	certMu           sync.RWMutex
+	cert             *tls.Certificate
+}
+
+// Reload applies configName if all of it is valid, the last good one stays otherwise.
+func (l *reloader) Reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.reload(); err != nil {
+		logger.Error("config is not reloaded: " + err.Error())
+		return
+	}
+	logger.Info("config is reloaded")
+}
+
+func (l *reloader) reload() error {
+	conf, authConf, err := readConfig(l.configName)
+	if err != nil {
+		return err
+	}
+	// references still in use, the others are forgotten once conf is applied
+	refs := []string{l.certPem, l.certKey}
+	for _, value := range secretValues(&conf, &authConf) {
+		refs = append(refs, *value)
+	}
+	if err = resolveSecrets(l.secrets, &conf, &authConf); err != nil {
+		return err
+	}
+	if conf.Client.Oauth2.Token.Source != l.conf.Client.Oauth2.Token.Source {
+		return errors.New("token source cannot be changed without a restart")
+	}
+	if authConf.Auth.Cookie.Prefix != l.authConf.Auth.Cookie.Prefix {
+		return adapter.ErrCookiePrefixChanged
+	}
+	if err = checkSecrets(authConf); err != nil {
+		return err
+	}
+	cookiePolicy, stateCookiePolicy, err := newCookiePolicies(authConf)
+	if err != nil {
+		return err
+	}
+	cookieCodec, err := newCookieCodec(authConf)
+	if err != nil {
+		return err
+	}
+	logLevel, err := logger.ParseLevel(conf.Logger.Level)
+	if err != nil {
+		return err
+	}
+	completePage, err := newCompletePage(authConf)
+	if err != nil {
+		return err
+	}
+	if l.certPem != "" {
+		if err = l.loadCertificate(); err != nil {
+			return err
+		}
+	}
+
+	// cookie policies go first, nothing is applied when they are rejected
+	if err = l.tokenCookie.SetPolicy(cookiePolicy); err != nil {
+		return err
+	}
+	if l.sessionIDCookie != nil {
+		if err = l.sessionIDCookie.SetPolicy(cookiePolicy); err != nil {
+			return err
+		}
+	}
+	if err = l.stateCookie.SetPolicy(stateCookiePolicy); err != nil {
+		return err
+	}
+	oauthConfig := newOauthConfig(conf)
+	l.tokenUsc.SetConfig(oauthConfig)
+	l.validator.SetClientID(oauthConfig.ClientID)
+	l.stateCookie.SetSecret([]byte(authConf.Auth.StateCookie.Secret))
+	if l.authRequestUsc != nil {
+		l.authRequestUsc.SetHmacSecret(authConf.Auth.Signal.HmacSecret)
+	}
+	l.applyCookieCodec(cookieCodec, &authConf)
+	l.applyAuditApiToken(&authConf)
+	l.applyUserHttp(&conf)
+	if conf.Server.Repo.ConnStr != l.conf.Server.Repo.ConnStr {
+		// repositories are opened once and shared by everything
+		logger.Warn("server.repo.conn_str is not reloaded, changes to it take a restart")
+		conf.Server.Repo.ConnStr = l.conf.Server.Repo.ConnStr
+	}
+	l.completePage.Replace(completePage)
+	l.handler.Store(newCorsHandler(l.router, conf))
+	logger.SetLevel(logLevel)
+	if conf.Logger.Stdout != l.conf.Logger.Stdout || conf.Logger.File != l.conf.Logger.File {
+		// outputs are opened once, replacing them would race with everything logging
+		logger.Warn("logger outputs are not reloaded, changes to them take a restart")
+		conf.Logger.Stdout, conf.Logger.File = l.conf.Logger.Stdout, l.conf.Logger.File
+	}
+
+	l.secrets.Retain(refs)
+	l.conf = conf
+	l.authConf = authConf
+	return nil
+}
+
+// applyCookieCodec replaces keys of the cookie codec with those of cookieCodec. The codec is wired
+// into cookies once, turning it on or off keeps the running one.
+func (l *reloader) applyCookieCodec(cookieCodec *adapter.AesGcmCookieCodec, authConf *config.Config) {
+	if (cookieCodec == nil) != (l.cookieCodec == nil) {
+		logger.Warn("auth.cookie.codec.enabled is not reloaded, changes to it take a restart")
+		authConf.Auth.Cookie.Codec = l.authConf.Auth.Cookie.Codec
+		return
+	}
+	if cookieCodec != nil {
+		l.cookieCodec.Replace(cookieCodec)
+	}
+}
+
+// applyAuditApiToken replaces the api token of the audit route. The route is registered only if a
+// token is configured at start.
+func (l *reloader) applyAuditApiToken(authConf *config.Config) {
+	if l.auditHandler == nil {
+		if authConf.Auth.Audit.ApiToken != "" {
+			logger.Warn("auth.audit.api_token is not reloaded, enabling the audit route takes a restart")
+			authConf.Auth.Audit.ApiToken = l.authConf.Auth.Audit.ApiToken
+		}
+		return
+	}
+	l.auditHandler.SetApiToken(authConf.Auth.Audit.ApiToken)
+}
+
+// applyUserHttp replaces the http user service when its bearer token changes. Its url, and which user
+// service is used, take a restart.
+func (l *reloader) applyUserHttp(conf *common.Config) {
+	if l.conf.Client.UserHttp.Url == "" {
+		return
+	}
+	if conf.Client.UserHttp.Url != l.conf.Client.UserHttp.Url {
+		logger.Warn("client.user_http.url is not reloaded, changes to it take a restart")
+		conf.Client.UserHttp.Url = l.conf.Client.UserHttp.Url
+	}
+	if conf.Client.UserHttp.BearerToken != l.conf.Client.UserHttp.BearerToken {
+		l.tokenUsc.SetUserSvc(newUserHttp(*conf))
+	}
+}
+
+// loadCertificate resolves certPem and certKey into the certificate served from now on. Either may be
+// a path, like the default of the flag which is not set.
+func (l *reloader) loadCertificate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+	certPem, err := l.readCertificate(ctx, l.certPem)
+	if err != nil {
+		return err
+	}
+	certKey, err := l.readCertificate(ctx, l.certKey)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair([]byte(certPem), []byte(certKey))
+	if err != nil {
+		return err
+	}
+	l.certMu.Lock()
+	defer l.certMu.Unlock()
+	l.cert = &cert
+	return nil
+}
+
+func (l *reloader) readCertificate(ctx context.Context, value string) (string, error) {
+	if !strings.Contains(value, "://") {
+		b, err := os.ReadFile(value)
+		return string(b), err
+	}
+	return l.secrets.Resolve(ctx, value)
+}
+
+func (l *reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.certMu.RLock()
+	defer l.certMu.RUnlock()
+	return l.cert, nil
+}
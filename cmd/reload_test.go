@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/authtest"
+	"github.com/w-woong/auth/delivery"
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/usecase"
+	"go.uber.org/zap/zapcore"
+)
+
+const reloadTestConfig = `
+logger:
+  level: 'warn'
+client:
+  oauth2:
+    client_id: 'client1'
+    client_secret: 'secret1'
+    token:
+      source: 'test'
+auth:
+  complete_page:
+    dir: './resources/html'
+    template: '{template}'
+  cookie:
+    path: '/'
+    same_site: '{same_site}'
+    expires_in: {expires_in}
+    prefix: '{prefix}'
+  state_cookie:
+    secret: 'state secret'
+`
+
+func writeReloadTestConfig(t *testing.T, name, template, sameSite, expiresIn, prefix string) {
+	t.Helper()
+	content := strings.NewReplacer("{template}", template, "{same_site}", sameSite,
+		"{expires_in}", expiresIn, "{prefix}", prefix).Replace(reloadTestConfig)
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cookieMaxAge returns how long the token cookie set from now on lives.
+func cookieMaxAge(cookie *adapter.TokenCookie) time.Duration {
+	w := httptest.NewRecorder()
+	cookie.SetTokenSource(w, "test")
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		return 0
+	}
+	return time.Until(cookies[0].Expires).Round(time.Minute)
+}
+
+func TestReloader_KeepsLastGoodConfig(t *testing.T) {
+	configName := filepath.Join(t.TempDir(), "server.yml")
+	writeReloadTestConfig(t, configName, "auth_complete", "strict", "3600", "")
+
+	conf, authConf, err := readConfig(configName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookiePolicy, stateCookiePolicy, err := newCookiePolicies(authConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	completePage, err := newCompletePage(authConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := authtest.NewProvider("client1", "secret1")
+	defer provider.Close()
+	validator, err := adapter.NewJwksIDTokenValidator(http.DefaultClient, provider.JwksURL(), time.Hour,
+		provider.URL(), "client0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer validator.Close()
+	tokenCookie := adapter.NewTokenCookie(cookiePolicy, nil, "tid", "id_token", "token_source")
+	router := mux.NewRouter()
+	l := &reloader{
+		configName:   configName,
+		conf:         conf,
+		authConf:     authConf,
+		secrets:      usecase.NewSecretUsc(map[string]port.SecretProvider{}),
+		tokenUsc:     usecase.NewTokenUsc(nil, nil, "test", nil, newOauthConfig(conf), nil, nil, 0, 0, false),
+		validator:    validator,
+		tokenCookie:  tokenCookie,
+		stateCookie:  adapter.NewStateCookie(stateCookiePolicy, "auth_state", []byte("state secret")),
+		completePage: completePage,
+		router:       router,
+		handler:      delivery.NewReloadableHandler(newCorsHandler(router, conf)),
+	}
+
+	writeReloadTestConfig(t, configName, "auth_complete", "lax", "60", "")
+	defer logger.SetLevel(zapcore.DebugLevel)
+	if err := l.reload(); err != nil {
+		t.Fatalf("reload() = %v", err)
+	}
+	if level := logger.Level(); level != zapcore.WarnLevel {
+		t.Fatalf("log level is %v after reload, want %v", level, zapcore.WarnLevel)
+	}
+	if maxAge := cookieMaxAge(tokenCookie); maxAge != time.Minute {
+		t.Fatalf("cookie lives %v after reload, want %v", maxAge, time.Minute)
+	}
+	// id tokens are validated for the reloaded client id
+	if _, _, err := validator.Validate(provider.IDToken(authtest.User{Subject: "user1"}, time.Hour)); err != nil {
+		t.Fatalf("Validate() after reload = %v", err)
+	}
+
+	tests := []struct {
+		name                          string
+		template, sameSite, expiresIn string
+		prefix                        string
+		wantErr                       error
+	}{
+		{name: "invalid same site", template: "auth_complete", sameSite: "bogus", expiresIn: "120"},
+		{name: "missing template", template: "missing", sameSite: "lax", expiresIn: "120"},
+		{name: "prefix changed", template: "auth_complete", sameSite: "lax", expiresIn: "120",
+			prefix: "__Secure-", wantErr: adapter.ErrCookiePrefixChanged},
+		{name: "unreadable", template: "auth_complete", sameSite: "lax", expiresIn: "[120"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeReloadTestConfig(t, configName, tt.template, tt.sameSite, tt.expiresIn, tt.prefix)
+			err := l.reload()
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("reload() = %v, want %v", err, tt.wantErr)
+			}
+			if l.authConf.Auth.Cookie.ExpiresIn != 60 || l.authConf.Auth.Cookie.SameSite != "lax" {
+				t.Errorf("config is %+v, want the last good one", l.authConf.Auth.Cookie)
+			}
+			if maxAge := cookieMaxAge(tokenCookie); maxAge != time.Minute {
+				t.Errorf("cookie lives %v, want %v of the last good config", maxAge, time.Minute)
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if err := completePage.Render(w, r, "", "", delivery.CompletePageData{}); err != nil {
+				t.Errorf("Render() = %v", err)
+			}
+		})
+	}
+}
+
+const reloadSecretsTestConfig = `
+logger:
+  level: 'debug'
+client:
+  oauth2:
+    client_id: 'client1'
+    token:
+      source: 'test'
+auth:
+  complete_page:
+    dir: './resources/html'
+    template: 'auth_complete'
+  cookie:
+    path: '/'
+    codec:
+      enabled: true
+      keys:
+        - id: '{key_id}'
+          secret: 'env://TEST_COOKIE_KEY'
+  state_cookie:
+    secret: 'env://TEST_STATE_COOKIE_SECRET'
+  signal:
+    hmac_secret: 'env://TEST_SIGNAL_HMAC_SECRET'
+  audit:
+    api_token: 'env://TEST_AUDIT_API_TOKEN'
+`
+
+type nopAuditUsc struct{}
+
+func (nopAuditUsc) Record(ctx context.Context, event entity.AuditEvent) {}
+
+func (nopAuditUsc) Find(ctx context.Context, filter port.AuditFilter) ([]entity.AuditEvent, error) {
+	return nil, nil
+}
+
+func TestReloader_AppliesSecrets(t *testing.T) {
+	configName := filepath.Join(t.TempDir(), "server.yml")
+	writeConfig := func(keyID string) {
+		content := strings.ReplaceAll(reloadSecretsTestConfig, "{key_id}", keyID)
+		if err := os.WriteFile(configName, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setSecrets := func(key, state, hmac, apiToken string) {
+		t.Setenv("TEST_COOKIE_KEY", base64.StdEncoding.EncodeToString([]byte(key)))
+		t.Setenv("TEST_STATE_COOKIE_SECRET", state)
+		t.Setenv("TEST_SIGNAL_HMAC_SECRET", hmac)
+		t.Setenv("TEST_AUDIT_API_TOKEN", apiToken)
+	}
+	writeConfig("k1")
+	setSecrets("0123456789abcdef0123456789abcdef", "state1", "hmac1", "api1")
+
+	secrets := usecase.NewSecretUsc(map[string]port.SecretProvider{"env": adapter.NewEnvSecret()})
+	conf, authConf, err := readConfig(configName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resolveSecrets(secrets, &conf, &authConf); err != nil {
+		t.Fatal(err)
+	}
+	cookiePolicy, stateCookiePolicy, err := newCookiePolicies(authConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookieCodec, err := newCookieCodec(authConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	completePage, err := newCompletePage(authConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := authtest.NewProvider("client1", "secret1")
+	defer provider.Close()
+	validator, err := adapter.NewJwksIDTokenValidator(http.DefaultClient, provider.JwksURL(), time.Hour,
+		provider.URL(), "client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer validator.Close()
+	stateCookie := adapter.NewStateCookie(stateCookiePolicy, "auth_state", []byte(authConf.Auth.StateCookie.Secret))
+	auditHandler := delivery.NewAuditHandler(nopAuditUsc{}, authConf.Auth.Audit.ApiToken)
+	router := mux.NewRouter()
+	l := &reloader{
+		configName:   configName,
+		conf:         conf,
+		authConf:     authConf,
+		secrets:      secrets,
+		tokenUsc:     usecase.NewTokenUsc(nil, nil, "test", nil, newOauthConfig(conf), nil, nil, 0, 0, false),
+		validator:    validator,
+		tokenCookie:  adapter.NewTokenCookie(cookiePolicy, cookieCodec, "tid", "id_token", "token_source"),
+		cookieCodec:  cookieCodec,
+		stateCookie:  stateCookie,
+		auditHandler: auditHandler,
+		completePage: completePage,
+		router:       router,
+		handler:      delivery.NewReloadableHandler(newCorsHandler(router, conf)),
+	}
+
+	encoded, err := cookieCodec.Encode("tid", "token1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	stateCookie.SetState(w, "state")
+	stateRequest := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		stateRequest.AddCookie(cookie)
+	}
+
+	writeConfig("k2")
+	setSecrets("fedcba9876543210fedcba9876543210", "state2", "hmac2", "api2")
+	if _, err = secrets.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.reload(); err != nil {
+		t.Fatalf("reload() = %v", err)
+	}
+
+	if _, err := cookieCodec.Decode("tid", encoded); !errors.Is(err, adapter.ErrCookieKeyUnknown) {
+		t.Errorf("Decode() of a removed key = %v, want %v", err, adapter.ErrCookieKeyUnknown)
+	}
+	if err := stateCookie.VerifyState(stateRequest, "state"); err == nil {
+		t.Error("VerifyState() of the previous secret = nil")
+	}
+	for token, want := range map[string]int{"api1": http.StatusUnauthorized, "api2": http.StatusOK} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/auth/audit", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		auditHandler.Find(w, r)
+		if w.Code != want {
+			t.Errorf("Find() with %s = %d, want %d", token, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/w-woong/auth/delivery"
+	"github.com/w-woong/auth/port"
+)
+
+func AuditHandlerRoute(router *mux.Router, usc port.AuditUsc, apiToken string) *delivery.AuditHandler {
+	handler := delivery.NewAuditHandler(usc, apiToken)
+
+	router.HandleFunc("/v1/auth/audit", handler.Find).Methods(http.MethodGet)
+
+	return handler
+}
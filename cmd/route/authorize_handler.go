@@ -15,19 +15,23 @@ import (
 func AuthorizeHandlerRoute(router *mux.Router, usc port.TokenUsc, authStateUsc port.AuthStateUsc,
 	authRequestUsc port.AuthRequestUsc,
 	tokenGetter port.TokenGetter, tokenSetter port.TokenSetter,
-	authRequestWait time.Duration) *delivery.AuthorizeHandler {
+	authRequestWait time.Duration, completePage *delivery.CompletePage,
+	rateLimiter *delivery.RateLimiter, auditor *delivery.Auditor, retryUrl string) *delivery.AuthorizeHandler {
 
-	handler := delivery.NewAuthorizeHandler(usc, authStateUsc, authRequestUsc, tokenGetter, tokenSetter, authRequestWait)
+	handler := delivery.NewAuthorizeHandler(usc, authStateUsc, authRequestUsc, tokenGetter, tokenSetter,
+		authRequestWait, completePage, auditor, retryUrl)
 
 	router.HandleFunc("/v1/auth/authorize/"+usc.TokenSource()+"/{auth_request_id}",
-		handler.AuthorizeWithAuthRequest).Methods(http.MethodGet)
-	router.HandleFunc("/v1/auth/callback/"+usc.TokenSource(), handler.CallbackWithAuthRequest)
+		rateLimiter.Limit("authorize", handler.AuthorizeWithAuthRequest)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/auth/callback/"+usc.TokenSource(), rateLimiter.Limit("callback", handler.CallbackWithAuthRequest))
 
-	router.HandleFunc("/v1/auth/request/"+usc.TokenSource(), handler.AuthRequest).Methods(http.MethodGet)
-	router.HandleFunc("/v1/auth/request/"+usc.TokenSource()+"/{auth_request_id}", handler.AuthRequestWait).Methods(http.MethodGet)
-	router.HandleFunc("/v1/auth/request/"+usc.TokenSource()+"/{auth_request_id}", handler.AuthRequestSignal).Methods(http.MethodPost)
+	router.HandleFunc("/v1/auth/request/"+usc.TokenSource(), rateLimiter.Limit("request", handler.AuthRequest)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/auth/request/"+usc.TokenSource()+"/{auth_request_id}", rateLimiter.Limit("wait", handler.AuthRequestWait)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/auth/request/"+usc.TokenSource()+"/{auth_request_id}", rateLimiter.Limit("signal", handler.AuthRequestSignal)).Methods(http.MethodPost)
 
-	router.HandleFunc("/v1/auth/validate/"+usc.TokenSource(), handler.ValidateIDToken).Methods(http.MethodGet)
+	router.HandleFunc("/v1/auth/validate/"+usc.TokenSource(), rateLimiter.Limit("validate", handler.ValidateIDToken)).Methods(http.MethodGet)
+	router.HandleFunc("/v1/auth/refresh/"+usc.TokenSource(), rateLimiter.Limit("refresh", handler.RefreshIDToken)).Methods(http.MethodPost)
+	router.HandleFunc("/v1/auth/logout/"+usc.TokenSource(), rateLimiter.Limit("logout", handler.Logout)).Methods(http.MethodPost)
 
 	return handler
 }
@@ -0,0 +1,18 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/w-woong/auth/delivery"
+	"github.com/w-woong/auth/port"
+)
+
+func HealthHandlerRoute(router *mux.Router, usc port.HealthUsc) *delivery.HealthHandler {
+	handler := delivery.NewHealthHandler(usc)
+
+	router.HandleFunc("/healthz", handler.Live).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", handler.Ready).Methods(http.MethodGet)
+
+	return handler
+}
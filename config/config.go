@@ -0,0 +1,223 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+// Config holds auth service specific settings which are not part of common.Config.
+// They are read from the same configuration file under the "auth" key.
+type Config struct {
+	Auth Auth `mapstructure:"auth"`
+}
+
+type Auth struct {
+	// ClusterID identifies the group of instances sharing auth requests.
+	ClusterID    string       `mapstructure:"cluster_id"`
+	Signal       Signal       `mapstructure:"signal"`
+	RateLimit    RateLimit    `mapstructure:"rate_limit"`
+	CompletePage CompletePage `mapstructure:"complete_page"`
+	ReturnTo     ReturnTo     `mapstructure:"return_to"`
+	Cookie       Cookie       `mapstructure:"cookie"`
+	Session      Session      `mapstructure:"session"`
+	StateCookie  StateCookie  `mapstructure:"state_cookie"`
+	Credentials  Credentials  `mapstructure:"credentials"`
+	Refresh      Refresh      `mapstructure:"refresh"`
+	Sessions     Sessions     `mapstructure:"sessions"`
+	Audit        Audit        `mapstructure:"audit"`
+	Tracing      Tracing      `mapstructure:"tracing"`
+	Health       Health       `mapstructure:"health"`
+	Shutdown     Shutdown     `mapstructure:"shutdown"`
+	Secrets      Secrets      `mapstructure:"secrets"`
+}
+
+// Signal configures authentication of the auth request signal endpoint.
+type Signal struct {
+	HmacHeader string `mapstructure:"hmac_header"`
+	HmacSecret string `mapstructure:"hmac_secret"`
+}
+
+// RateLimit configures token buckets per route. Routes are request, wait, signal, authorize,
+// callback, validate, refresh and logout.
+type RateLimit struct {
+	// Store is either map(per instance) or db(shared through the repository).
+	Store string `mapstructure:"store"`
+	// TrustedProxies is the number of proxies in front of the service appending to X-Forwarded-For.
+	// The header is ignored if 0.
+	TrustedProxies int                      `mapstructure:"trusted_proxies"`
+	Routes         map[string]RateLimitRule `mapstructure:"routes"`
+}
+
+type RateLimitRule struct {
+	IP  Bucket `mapstructure:"ip"`
+	Tid Bucket `mapstructure:"tid"`
+}
+
+// Bucket refills Rate tokens per second up to Burst.
+type Bucket struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// CompletePage configures templates rendered when authorization is completed.
+type CompletePage struct {
+	Dir      string                        `mapstructure:"dir"`
+	Template string                        `mapstructure:"template"`
+	Clients  map[string]CompletePageClient `mapstructure:"clients"`
+}
+
+type CompletePageClient struct {
+	Template         string   `mapstructure:"template"`
+	AutoRedirect     bool     `mapstructure:"auto_redirect"`
+	AllowedRedirects []string `mapstructure:"allowed_redirects"`
+}
+
+// ReturnTo lists origins with path prefixes, like https://woong.com/app/, which browsers
+// may be redirected to after authorization.
+type ReturnTo struct {
+	Allowed []string `mapstructure:"allowed"`
+}
+
+// Cookie configures cookies which carry tokens to browsers.
+type Cookie struct {
+	Domain string `mapstructure:"domain"`
+	Path   string `mapstructure:"path"`
+	// SameSite is one of strict, lax and none.
+	SameSite  string `mapstructure:"same_site"`
+	ExpiresIn int    `mapstructure:"expires_in"`
+	// Prefix is either empty, __Host- or __Secure-.
+	Prefix    string      `mapstructure:"prefix"`
+	ChunkSize int         `mapstructure:"chunk_size"`
+	Codec     CookieCodec `mapstructure:"codec"`
+}
+
+// CookieCodec encrypts and authenticates cookie values with AES-GCM when it is enabled.
+type CookieCodec struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxAge in seconds, older cookies are rejected.
+	MaxAge int `mapstructure:"max_age"`
+	// GracePeriod in seconds, retired keys are accepted for this long after retired_at.
+	GracePeriod int         `mapstructure:"grace_period"`
+	Keys        []CookieKey `mapstructure:"keys"`
+}
+
+// CookieKey is a base64 encoded AES key. The first key encodes, the rest are retired keys.
+type CookieKey struct {
+	ID     string `mapstructure:"id"`
+	Secret string `mapstructure:"secret"`
+	// RetiredAt is RFC3339 time the key stopped being the first one.
+	RetiredAt string `mapstructure:"retired_at"`
+}
+
+// Session replaces tid, id_token and token_source cookies with a single opaque session id cookie
+// when it is enabled. Tokens are kept in the repository of server.repo.driver.
+type Session struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	CookieName string `mapstructure:"cookie_name"`
+}
+
+// StateCookie binds oauth state to the browser starting authorization.
+type StateCookie struct {
+	Name   string `mapstructure:"name"`
+	Secret string `mapstructure:"secret"`
+	// ExpiresIn in seconds, authorization must complete within it.
+	ExpiresIn int `mapstructure:"expires_in"`
+}
+
+// Credentials configures where credentials are looked for. Extractors are cookie, header,
+// bearer, query(WebSocket handshakes only) and form.
+type Credentials struct {
+	// Order is the default precedence, cookie and header if empty.
+	Order []string `mapstructure:"order"`
+	// Routes overrides Order by route name.
+	Routes map[string][]string `mapstructure:"routes"`
+}
+
+// Refresh configures refreshing tokens ahead of their expiry.
+type Refresh struct {
+	// Window in seconds, tokens expiring within it are refreshed. 0 disables it.
+	Window     int               `mapstructure:"window"`
+	Background BackgroundRefresh `mapstructure:"background"`
+}
+
+// BackgroundRefresh refreshes offline tokens on the ticker.
+type BackgroundRefresh struct {
+	Enabled     bool `mapstructure:"enabled"`
+	Batch       int  `mapstructure:"batch"`
+	MaxFailures int  `mapstructure:"max_failures"`
+}
+
+// Sessions limits tokens kept per user, one per device.
+type Sessions struct {
+	// MaxPerUser evicts the oldest sessions beyond it. 0 is unlimited.
+	MaxPerUser int `mapstructure:"max_per_user"`
+	// RevokeEvicted revokes evicted tokens at the provider.
+	RevokeEvicted bool `mapstructure:"revoke_evicted"`
+}
+
+// Audit configures the audit log, stored in server.repo.
+type Audit struct {
+	// File appends events as json lines if it is not empty.
+	File string `mapstructure:"file"`
+	// ApiToken is the bearer token of GET /v1/auth/audit, which is disabled if it is empty.
+	ApiToken string `mapstructure:"api_token"`
+	// QueueSize is the number of events waiting to be stored, 1024 if 0. Events are dropped beyond it.
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+// Tracing configures where spans of handlers and use cases are exported.
+type Tracing struct {
+	// Exporter is otlp or apm. Empty means apm if ELASTIC_APM_ACTIVE is true, spans are dropped otherwise.
+	Exporter string `mapstructure:"exporter"`
+	// Endpoint of OTLP/HTTP traces, like http://localhost:4318/v1/traces.
+	Endpoint    string `mapstructure:"endpoint"`
+	ServiceName string `mapstructure:"service_name"`
+	// SampleRatio of traces starting here which are exported, all of them if 0. Others follow their parent.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// Health configures readiness checks of /readyz.
+type Health struct {
+	// Timeout of each check in seconds, 2 if 0.
+	Timeout int `mapstructure:"timeout"`
+	// JwksMaxAge in seconds, readiness fails when jwks has not been fetched for longer. 24 hours if 0.
+	JwksMaxAge int `mapstructure:"jwks_max_age"`
+	// ProviderInterval in seconds is how often the openid configuration and the token endpoint of
+	// the provider are checked, the last result is reported in between. 60 if 0.
+	ProviderInterval int `mapstructure:"provider_interval"`
+}
+
+// Shutdown configures draining on SIGINT and SIGTERM.
+type Shutdown struct {
+	// GracePeriod in seconds for in-flight requests, 30 if 0.
+	GracePeriod int `mapstructure:"grace_period"`
+	// RetryUrl is where waiters wait again, usually through the load balancer. It may contain
+	// {token_source} and {auth_request_id}. Waiters retry the same url if it is empty.
+	RetryUrl string `mapstructure:"retry_url"`
+}
+
+// Secrets configures references accepted in place of secrets: client.oauth2.client_id and client_secret,
+// client.user_http.bearer_token, server.repo.conn_str, auth.signal.hmac_secret, auth.state_cookie.secret,
+// auth.cookie.codec.keys, auth.audit.api_token, and the -pem and -key flags. References are file://, env://,
+// exec:// if the -allowExecSecrets flag is set and, if vault.addr is set, vault://.
+type Secrets struct {
+	// RefreshInterval in seconds, 300 if 0.
+	RefreshInterval int   `mapstructure:"refresh_interval"`
+	Vault           Vault `mapstructure:"vault"`
+}
+
+// Vault is a Vault compatible kv store, read with vault://path#key like vault://secret/data/auth#client_secret.
+type Vault struct {
+	Addr string `mapstructure:"addr"`
+	// Token may be a file:// or env:// reference.
+	Token string `mapstructure:"token"`
+}
+
+// ReadConfigInto reads configName and unmarshals it into conf.
+func ReadConfigInto(configName string, conf *Config) error {
+	v := viper.New()
+	v.SetConfigFile(configName)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	return v.Unmarshal(conf)
+}
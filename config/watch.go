@@ -0,0 +1,17 @@
+package config
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// Watch calls onChange whenever configName is written, or replaced like editors and mounted
+// ConfigMaps do.
+func Watch(configName string, onChange func()) {
+	v := viper.New()
+	v.SetConfigFile(configName)
+	v.OnConfigChange(func(fsnotify.Event) {
+		onChange()
+	})
+	v.WatchConfig()
+}
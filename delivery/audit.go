@@ -0,0 +1,179 @@
+package delivery
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/common"
+)
+
+const maxUserAgentSize = 512
+
+// Auditor records authentication events of requests. A nil Auditor records nothing.
+type Auditor struct {
+	usc            port.AuditUsc
+	trustedProxies int
+}
+
+func NewAuditor(usc port.AuditUsc, trustedProxies int) *Auditor {
+	return &Auditor{
+		usc:            usc,
+		trustedProxies: trustedProxies,
+	}
+}
+
+// Record records eventType of r. It is a failure if err is not nil.
+func (a *Auditor) Record(r *http.Request, eventType, tokenSource, tokenID, subject string, err error) {
+	if a == nil {
+		return
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentSize {
+		userAgent = userAgent[:maxUserAgentSize]
+	}
+	event := entity.AuditEvent{
+		Type:        eventType,
+		TokenID:     tokenID,
+		Subject:     subject,
+		TokenSource: tokenSource,
+		IP:          clientIP(r, a.trustedProxies),
+		UserAgent:   userAgent,
+		Outcome:     entity.AuditSuccess,
+	}
+	if err != nil {
+		event.Outcome = entity.AuditFailure
+		event.ErrorCode = auditErrorCode(err)
+	}
+	a.usc.Record(r.Context(), event)
+}
+
+func auditErrorCode(err error) string {
+	switch {
+	case errors.Is(err, common.ErrTokenExpired):
+		return "token_expired"
+	case errors.Is(err, common.ErrIDTokenInconsistent):
+		return "id_token_inconsistent"
+	case errors.Is(err, common.ErrRecordNotFound):
+		return "not_found"
+	case errors.Is(err, port.ErrRefreshRejected):
+		return "refresh_rejected"
+	case errors.Is(err, port.ErrIDTokenNotIssued):
+		return "id_token_not_issued"
+	}
+	return "error"
+}
+
+// clientIP is the address the outermost of trustedProxies received r from. Entries left of it are
+// sent by the client and may be forged. It is the remote address of r if fewer proxies appended.
+func clientIP(r *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		var hops []string
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					hops = append(hops, hop)
+				}
+			}
+		}
+		// fewer hops than proxies means the request bypassed some of them, any entry may be forged
+		if len(hops) >= trustedProxies {
+			return hops[len(hops)-trustedProxies]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type auditPage struct {
+	Events []entity.AuditEvent `json:"events"`
+	// Next is the before parameter of the next page, empty when no events are found.
+	Next string `json:"next,omitempty"`
+}
+
+type AuditHandler struct {
+	usc port.AuditUsc
+
+	mu       sync.RWMutex
+	apiToken string
+}
+
+// NewAuditHandler creates AuditHandler. Requests must carry apiToken as a bearer token.
+func NewAuditHandler(usc port.AuditUsc, apiToken string) *AuditHandler {
+	return &AuditHandler{
+		usc:      usc,
+		apiToken: apiToken,
+	}
+}
+
+// SetApiToken replaces the bearer token requests must carry from now on.
+func (d *AuditHandler) SetApiToken(apiToken string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.apiToken = apiToken
+}
+
+// Find finds audit events by sub, type and tid query parameters. Pages are limited by limit and
+// continued by before.
+func (d *AuditHandler) Find(w http.ResponseWriter, r *http.Request) {
+	setNoCache(w)
+	d.mu.RLock()
+	apiToken := d.apiToken
+	d.mu.RUnlock()
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || apiToken == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := port.AuditFilter{
+		Subject: query.Get("sub"),
+		Type:    query.Get("type"),
+		TokenID: query.Get("tid"),
+	}
+	var err error
+	if v := query.Get("before"); v != "" {
+		if filter.Before, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := d.usc.Find(r.Context(), filter)
+	if err != nil {
+		logger.Error(err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	page := auditPage{Events: events}
+	if len(events) > 0 {
+		page.Next = strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+
+	res := common.HttpBody{
+		Status:   http.StatusOK,
+		Count:    len(events),
+		Document: &page,
+	}
+	if err := res.EncodeTo(w); err != nil {
+		logger.Error(err.Error())
+	}
+}
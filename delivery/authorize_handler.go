@@ -1,28 +1,42 @@
 package delivery
 
 import (
+	"bytes"
 	"errors"
-	"html/template"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-wonk/si"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/w-woong/auth/dto"
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/metrics"
 	"github.com/w-woong/auth/port"
 	"github.com/w-woong/common"
 	commondto "github.com/w-woong/common/dto"
-	"github.com/w-woong/common/logger"
 )
 
 var (
 	dump bool
 )
 
+const (
+	maxSignalBodySize = 1 << 16
+	maxDeviceIDSize   = 128
+
+	// PollSecretHeader carries the poll secret issued by AuthRequest when waiting for its result.
+	PollSecretHeader = "X-Poll-Secret"
+)
+
 func init() {
 	dump, _ = strconv.ParseBool(os.Getenv("DUMP"))
 }
@@ -36,12 +50,22 @@ type AuthorizeHandler struct {
 	tokenGetter port.TokenGetter
 	tokenSetter port.TokenSetter
 
-	authCompleteTemplate *template.Template
+	completePage *CompletePage
+	auditor      *Auditor
+
+	// drain is closed by Drain, waiters are told to retry at retryUrl then.
+	drain     chan struct{}
+	drainOnce sync.Once
+	retryUrl  string
+
+	waiters int32
 }
 
+// NewAuthorizeHandler creates AuthorizeHandler. retryUrl, with {auth_request_id}, is where waiters
+// of a draining instance wait again. They retry the same url if it is empty.
 func NewAuthorizeHandler(usc port.TokenUsc, authStateUsc port.AuthStateUsc, authRequestUsc port.AuthRequestUsc,
 	tokenGetter port.TokenGetter, tokenSetter port.TokenSetter,
-	authRequestWait time.Duration) *AuthorizeHandler {
+	authRequestWait time.Duration, completePage *CompletePage, auditor *Auditor, retryUrl string) *AuthorizeHandler {
 
 	return &AuthorizeHandler{
 		usc:             usc,
@@ -49,9 +73,56 @@ func NewAuthorizeHandler(usc port.TokenUsc, authStateUsc port.AuthStateUsc, auth
 		authRequestUsc:  authRequestUsc,
 		authRequestWait: authRequestWait,
 
-		tokenGetter:          tokenGetter,
-		tokenSetter:          tokenSetter,
-		authCompleteTemplate: template.Must(template.ParseFiles("./resources/html/auth_complete.html")),
+		tokenGetter:  tokenGetter,
+		tokenSetter:  tokenSetter,
+		completePage: completePage,
+		auditor:      auditor,
+
+		drain:    make(chan struct{}),
+		retryUrl: retryUrl,
+	}
+}
+
+// Drain stops accepting waits and tells current waiters to retry on another instance.
+// Pending auth requests are kept for them.
+func (d *AuthorizeHandler) Drain() {
+	d.drainOnce.Do(func() {
+		close(d.drain)
+	})
+}
+
+// Waiters returns the number of clients waiting for the result of auth requests.
+func (d *AuthorizeHandler) Waiters() int {
+	return int(atomic.LoadInt32(&d.waiters))
+}
+
+func (d *AuthorizeHandler) draining() bool {
+	select {
+	case <-d.drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryBody tells a waiter to wait again at RetryUrl.
+type retryBody struct {
+	Status   int    `json:"status"`
+	Retry    bool   `json:"retry"`
+	RetryUrl string `json:"retry_url,omitempty"`
+}
+
+func (d *AuthorizeHandler) writeRetry(w http.ResponseWriter, authRequestID string) {
+	w.Header().Set("Retry-After", "1")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	body := retryBody{
+		Status:   http.StatusServiceUnavailable,
+		Retry:    true,
+		RetryUrl: strings.ReplaceAll(d.retryUrl, "{auth_request_id}", authRequestID),
+	}
+	if err := si.EncodeJson(w, &body); err != nil {
+		logger.Error(err.Error())
 	}
 }
 
@@ -76,22 +147,32 @@ func (d *AuthorizeHandler) AuthorizeWithAuthRequest(w http.ResponseWriter, r *ht
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditAuthorize, d.usc.TokenSource(), "", "", err)
 		return
 	}
 
-	authState, err := d.authStateUsc.Create(ctx, authRequestID)
+	authState, err := d.authStateUsc.Create(w, r, authRequestID, r.URL.Query().Get("return_to"))
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditAuthorize, d.usc.TokenSource(), "", "", err)
 		return
 	}
 
-	err = d.usc.AuthorizeCode(w, r, authState.State, authState.CodeVerifier)
+	// browsers which logged in before may hold a token of the user
+	consent := true
+	if tokenIdentifier := d.tokenGetter.Route("authorize").GetTokenIdentifier(r); tokenIdentifier != "" {
+		consent = !d.usc.HasOfflineToken(ctx, tokenIdentifier)
+	}
+	err = d.usc.AuthorizeCode(w, r, authState.State, authState.CodeVerifier, consent)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditAuthorize, d.usc.TokenSource(), "", "", err)
 		return
 	}
+	metrics.AuthorizeRedirects.WithLabelValues(d.usc.TokenSource()).Inc()
+	d.auditor.Record(r, entity.AuditAuthorize, d.usc.TokenSource(), "", "", nil)
 }
 
 // CallbackWithAuthRequest is the url redirected from authorization server(like google, apple, kakao..)
@@ -103,61 +184,83 @@ func (d *AuthorizeHandler) CallbackWithAuthRequest(w http.ResponseWriter, r *htt
 
 	setNoCache(w)
 	ctx := r.Context()
+	outcome := "failure"
+	defer func() {
+		metrics.Callbacks.WithLabelValues(d.usc.TokenSource(), outcome).Inc()
+	}()
+
 	authState, err := d.authStateUsc.Verify(w, r)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditLogin, d.usc.TokenSource(), "", "", err)
 		return
 	}
 
-	token, err := d.usc.Exchange(r, authState.CodeVerifier)
+	authRequest, err := d.authRequestUsc.Find(ctx, authState.AuthRequestID)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditLogin, d.usc.TokenSource(), "", "", err)
 		return
 	}
 
-	tokenDto, err := d.usc.SaveToken(ctx, w, token)
+	token, err := d.usc.Exchange(r, authState.CodeVerifier)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditLogin, d.usc.TokenSource(), "", "", err)
 		return
 	}
 
-	_, claims, err := d.usc.ValidateIDToken(ctx, tokenDto.IDToken)
+	// browsers logged in before are the device of the token they hold
+	device := authRequest.DeviceID
+	if device == "" {
+		device = d.tokenGetter.Route("callback").GetTokenIdentifier(r)
+	}
+	tokenDto, evicted, err := d.usc.SaveToken(ctx, w, token, device)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditLogin, d.usc.TokenSource(), "", "", err)
 		return
 	}
 
-	registeredUser, err := d.usc.RegisterUser(ctx, tokenDto.ID, *claims)
+	_, claims, err := d.usc.ValidateIDToken(ctx, tokenDto.IDToken)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditLogin, d.usc.TokenSource(), "", "", err)
 		return
 	}
-	logger.Debug(registeredUser.String())
+
+	outcome = "success"
+	d.auditor.Record(r, entity.AuditLogin, d.usc.TokenSource(), tokenDto.ID, claims.Subject, nil)
 
 	d.tokenSetter.SetTokenIdentifier(w, tokenDto.ID)
 	d.tokenSetter.SetIDToken(w, tokenDto.IDToken)
 	d.tokenSetter.SetTokenSource(w, tokenDto.TokenSource)
 
-	// TODO: respond with static page that leads to the app or web page
-	// if err = si.EncodeJson(w, tokenDto.HideSensitive()); err != nil {
-	// 	logger.Error(err.Error())
-	// }
-	if err = d.authCompleteTemplate.Execute(w, nil); err != nil {
-		logger.Error(err.Error())
-	}
-
-	defer func() {
-		_, err := d.authRequestUsc.Remove(ctx, authState.AuthRequestID)
+	if authState.ReturnTo != "" {
+		// validated against the allowlist when the state was created
+		http.Redirect(w, r, authState.ReturnTo, http.StatusFound)
+	} else {
+		err = d.completePage.Render(w, r, authRequest.ClientID, authRequest.RedirectUri, CompletePageData{
+			UserName:      strings.TrimSpace(claims.GivenName + " " + claims.FamilyName),
+			Email:         claims.Email,
+			Provider:      d.usc.TokenSource(),
+			AuthRequestID: authRequest.ID,
+			Evicted:       evicted,
+		})
 		if err != nil {
 			logger.Error(err.Error())
 		}
-	}()
-	err = d.authRequestUsc.Signal(ctx, authState.AuthRequestID, tokenDto)
+	}
+
+	// a delivered signal removes the auth request. one not delivered, like before the client waits,
+	// keeps it, so that the client can wait and authorize again
+	err = d.authRequestUsc.Signal(ctx, authState.AuthRequestID,
+		dto.SignalToken{Token: tokenDto, SessionsEvicted: evicted})
 	if err != nil {
 		logger.Error(err.Error())
 		return
@@ -173,14 +276,31 @@ func (d *AuthorizeHandler) AuthRequest(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	setNoCache(w)
+	clientID := r.URL.Query().Get("client_id")
+	redirectUri := r.URL.Query().Get("redirect_uri")
+	if err := d.completePage.CheckRedirect(clientID, redirectUri); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		logger.Error(err.Error())
+		return
+	}
+
+	// apps identify the installation to keep a token per device
+	deviceID := r.URL.Query().Get("device_id")
+	if len(deviceID) > maxDeviceIDSize {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
 	authRequestID := uuid.New().String()
-	authRequest, err := d.authRequestUsc.Save(ctx, authRequestID)
+	authRequest, err := d.authRequestUsc.Save(ctx, authRequestID, clientID, redirectUri, deviceID)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		logger.Error(err.Error())
 		return
 	}
 
+	metrics.AuthRequestsCreated.WithLabelValues(d.usc.TokenSource()).Inc()
+
 	res := common.HttpBody{
 		Status:   http.StatusOK,
 		Count:    1,
@@ -203,21 +323,31 @@ func (d *AuthorizeHandler) AuthRequestWait(w http.ResponseWriter, r *http.Reques
 	vars := mux.Vars(r)
 	authRequestID := vars["auth_request_id"]
 
-	_, err := d.authRequestUsc.Find(ctx, authRequestID)
+	if d.draining() {
+		d.writeRetry(w, authRequestID)
+		return
+	}
+
+	_, err := d.authRequestUsc.Claim(ctx, authRequestID, r.Header.Get(PollSecretHeader))
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		logger.Error(err.Error())
 		return
 	}
 
-	ch := make(chan commondto.Token, 1)
-	_clientMap.Store(authRequestID, ch)
+	ch := make(chan dto.SignalToken, 1)
+	if _, loaded := _clientMap.LoadOrStore(authRequestID, ch); loaded {
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		logger.Error("auth request is already being waited for")
+		return
+	}
+	atomic.AddInt32(&d.waiters, 1)
+	defer atomic.AddInt32(&d.waiters, -1)
+	metrics.LongPollWaiters.Inc()
+	defer metrics.LongPollWaiters.Dec()
 
-	defer func(arID string) {
-		_clientMap.Delete(arID)
-		d.authRequestUsc.Remove(ctx, arID)
-		// d.usc.RemoveStateByAuthRequestID(ctx, arID)
-	}(authRequestID)
+	// a delivered auth request is removed by its signal, and one waited for again is kept
+	defer _clientMap.Delete(authRequestID)
 
 	ticker := time.NewTicker(d.authRequestWait)
 	defer ticker.Stop()
@@ -227,7 +357,23 @@ func (d *AuthorizeHandler) AuthRequestWait(w http.ResponseWriter, r *http.Reques
 			logger.Error(err.Error())
 			return
 		}
+	case <-d.drain:
+		if _, loaded := _clientMap.LoadAndDelete(authRequestID); !loaded {
+			// a signal has taken the channel, the token is on its way
+			token := <-ch
+			if err := si.EncodeJson(w, &token); err != nil {
+				logger.Error(err.Error())
+			}
+			return
+		}
+		d.writeRetry(w, authRequestID)
+	case <-ctx.Done():
+		// the waiter gave up, like on a client timeout, and may wait again
+		if _, loaded := _clientMap.LoadAndDelete(authRequestID); !loaded {
+			logger.Error("waiter of " + authRequestID + " is gone before its token is delivered")
+		}
 	case <-ticker.C:
+		d.authRequestUsc.Remove(ctx, authRequestID)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		logger.Error("tick expired")
 		return
@@ -247,8 +393,22 @@ func (d *AuthorizeHandler) AuthRequestSignal(w http.ResponseWriter, r *http.Requ
 	vars := mux.Vars(r)
 	authRequestID := vars["auth_request_id"]
 
-	token := commondto.Token{}
-	if err := si.DecodeJson(&token, r.Body); err != nil {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignalBodySize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		logger.Error(err.Error())
+		return
+	}
+
+	if err = d.authRequestUsc.VerifySignal(r, authRequestID, body); err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditSignal, d.usc.TokenSource(), "", "", err)
+		return
+	}
+
+	token := dto.SignalToken{}
+	if err := si.DecodeJson(&token, bytes.NewReader(body)); err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
@@ -258,9 +418,14 @@ func (d *AuthorizeHandler) AuthRequestSignal(w http.ResponseWriter, r *http.Requ
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	ch := val.(chan commondto.Token)
+	ch := val.(chan dto.SignalToken)
 	ch <- token
 	close(ch)
+	// the token is delivered, its poll secret must not be claimed again
+	if _, err := d.authRequestUsc.Remove(r.Context(), authRequestID); err != nil {
+		logger.Error(err.Error())
+	}
+	d.auditor.Record(r, entity.AuditSignal, d.usc.TokenSource(), token.ID, "", nil)
 	w.Write([]byte(`{"status":200}`))
 }
 
@@ -272,14 +437,23 @@ func (d *AuthorizeHandler) ValidateIDToken(w http.ResponseWriter, r *http.Reques
 	setNoCache(w)
 	ctx := r.Context()
 
-	tokenIdentifier := d.tokenGetter.GetTokenIdentifier(r)
+	cred, err := d.tokenGetter.Route("validate").GetCredential(r)
+	if err != nil {
+		logger.Error(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		d.auditor.Record(r, entity.AuditValidate, d.usc.TokenSource(), "", "", err)
+		metrics.Validations.WithLabelValues(d.usc.TokenSource(), "rejected").Inc()
+		return
+	}
+	tokenIdentifier := cred.TokenIdentifier
 	if tokenIdentifier == "" {
 		// return commondto.NilToken, errors.New("token identifier is empty")
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		metrics.Validations.WithLabelValues(d.usc.TokenSource(), "rejected").Inc()
 		return
 	}
 
-	idTokenStr := d.tokenGetter.GetIDToken(r)
+	idTokenStr := cred.IDToken
 	_, claims, err := d.usc.ValidateIDToken(ctx, idTokenStr)
 	// if err == nil {
 	// 	err = common.ErrTokenExpired
@@ -287,64 +461,159 @@ func (d *AuthorizeHandler) ValidateIDToken(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		logger.Error(err.Error())
 		if errors.Is(err, common.ErrTokenExpired) {
-			foundOauth2Token, err := d.usc.FindWithIDToken(ctx, tokenIdentifier, idTokenStr)
-			if err != nil {
-				logger.Error(err.Error())
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-			d.usc.RemoveToken(ctx, tokenIdentifier)
-			d.tokenSetter.SetTokenIdentifier(w, "")
-			d.tokenSetter.SetIDToken(w, "")
-			d.tokenSetter.SetTokenSource(w, "")
-
-			refreshedOauth2Token, err := d.usc.Refresh(ctx, foundOauth2Token)
+			refreshedTokenDto, err := d.usc.RefreshToken(ctx, tokenIdentifier, idTokenStr)
+			d.auditor.Record(r, entity.AuditRefresh, d.usc.TokenSource(), tokenIdentifier, "", err)
 			if err != nil {
 				logger.Error(err.Error())
+				metrics.RefreshFailures.WithLabelValues(d.usc.TokenSource(), "expired").Inc()
+				metrics.Validations.WithLabelValues(d.usc.TokenSource(), "rejected").Inc()
+				if !errors.Is(err, port.ErrRefreshRejected) && !errors.Is(err, common.ErrIDTokenInconsistent) &&
+					!errors.Is(err, common.ErrRecordNotFound) {
+					// the provider may be down or have issued no id_token, keep the token to retry later
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				d.removeToken(r, tokenIdentifier)
+				d.clearToken(w, r)
 				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 				return
 			}
-
-			refreshedTokenDto, err := d.usc.SaveToken(ctx, w, refreshedOauth2Token)
-			if err != nil {
-				logger.Error(err.Error())
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-
-			d.tokenSetter.SetTokenIdentifier(w, refreshedTokenDto.ID)
-			d.tokenSetter.SetIDToken(w, refreshedTokenDto.IDToken)
-			d.tokenSetter.SetTokenSource(w, refreshedTokenDto.TokenSource)
-			if err := si.EncodeJson(w, refreshedTokenDto.HideSensitive()); err != nil {
-				logger.Error(err.Error())
-			}
+			metrics.Validations.WithLabelValues(d.usc.TokenSource(), "refreshed").Inc()
+			d.writeToken(w, refreshedTokenDto)
 			return
 		}
 
-		d.usc.RemoveToken(ctx, tokenIdentifier)
-		d.tokenSetter.SetTokenIdentifier(w, "")
-		d.tokenSetter.SetIDToken(w, "")
-		d.tokenSetter.SetTokenSource(w, "")
+		metrics.Validations.WithLabelValues(d.usc.TokenSource(), "rejected").Inc()
+		d.auditor.Record(r, entity.AuditValidate, d.usc.TokenSource(), tokenIdentifier, "", err)
+		d.removeToken(r, tokenIdentifier)
+		d.clearToken(w, r)
 
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	tokenSource := d.tokenGetter.GetTokenSource(r)
-	d.tokenSetter.SetTokenIdentifier(w, tokenIdentifier)
-	d.tokenSetter.SetIDToken(w, idTokenStr)
-	d.tokenSetter.SetTokenSource(w, tokenSource)
 
-	resTokenDto := commondto.Token{
+	// refresh ahead of expiry, the token is still good if it fails
+	if d.usc.ShouldRefresh(claims.ExpiresAt.Time) {
+		refreshedTokenDto, err := d.usc.RefreshToken(ctx, tokenIdentifier, idTokenStr)
+		d.auditor.Record(r, entity.AuditRefresh, d.usc.TokenSource(), tokenIdentifier, claims.Subject, err)
+		if err == nil {
+			metrics.Validations.WithLabelValues(d.usc.TokenSource(), "refreshed").Inc()
+			d.writeToken(w, refreshedTokenDto)
+			return
+		}
+		logger.Error(err.Error())
+		metrics.RefreshFailures.WithLabelValues(d.usc.TokenSource(), "proactive").Inc()
+	}
+
+	metrics.Validations.WithLabelValues(d.usc.TokenSource(), "valid").Inc()
+	d.auditor.Record(r, entity.AuditValidate, d.usc.TokenSource(), tokenIdentifier, claims.Subject, nil)
+	d.writeToken(w, commondto.Token{
 		ID:          tokenIdentifier,
 		IDToken:     idTokenStr,
-		TokenSource: tokenSource,
+		TokenSource: cred.TokenSource,
 		Expiry:      claims.ExpiresAt.Unix(),
+	})
+}
+
+// RefreshIDToken refreshes the token of the credential at the provider, even if it has not expired yet.
+func (d *AuthorizeHandler) RefreshIDToken(w http.ResponseWriter, r *http.Request) {
+	if dump {
+		dumpRequest(r) // Ignore the error
 	}
 
-	if err := si.EncodeJson(w, resTokenDto.HideSensitive()); err != nil {
+	setNoCache(w)
+	ctx := r.Context()
+
+	cred, err := d.tokenGetter.Route("refresh").GetCredential(r)
+	if err == nil && cred.TokenIdentifier == "" {
+		err = errors.New("token identifier is empty")
+	}
+	if err != nil {
 		logger.Error(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		d.auditor.Record(r, entity.AuditRefresh, d.usc.TokenSource(), "", "", err)
+		return
 	}
 
+	refreshedTokenDto, err := d.usc.RefreshToken(ctx, cred.TokenIdentifier, cred.IDToken)
+	d.auditor.Record(r, entity.AuditRefresh, d.usc.TokenSource(), cred.TokenIdentifier, "", err)
+	if err != nil {
+		logger.Error(err.Error())
+		metrics.RefreshFailures.WithLabelValues(d.usc.TokenSource(), "requested").Inc()
+		if !errors.Is(err, port.ErrRefreshRejected) && !errors.Is(err, common.ErrIDTokenInconsistent) &&
+			!errors.Is(err, common.ErrRecordNotFound) {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		// an inconsistent id_token does not prove the token is of the caller
+		if errors.Is(err, port.ErrRefreshRejected) {
+			d.removeToken(r, cred.TokenIdentifier)
+		}
+		d.clearToken(w, r)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	d.writeToken(w, refreshedTokenDto)
+}
+
+// Logout removes the token of the credential and revokes it at the provider. Credentials of a token
+// which is already removed are cleared as well.
+func (d *AuthorizeHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if dump {
+		dumpRequest(r) // Ignore the error
+	}
+
+	setNoCache(w)
+	ctx := r.Context()
+
+	cred, err := d.tokenGetter.Route("logout").GetCredential(r)
+	if err == nil && cred.TokenIdentifier == "" {
+		err = errors.New("token identifier is empty")
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = d.usc.Logout(ctx, cred.TokenIdentifier, cred.IDToken)
+	if err != nil && !errors.Is(err, common.ErrRecordNotFound) {
+		logger.Error(err.Error())
+		d.auditor.Record(r, entity.AuditTokenRemoved, d.usc.TokenSource(), cred.TokenIdentifier, "", err)
+		if errors.Is(err, common.ErrIDTokenInconsistent) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		d.auditor.Record(r, entity.AuditTokenRemoved, d.usc.TokenSource(), cred.TokenIdentifier, "", nil)
+	}
+	d.clearToken(w, r)
+	w.Write([]byte(`{"status":200}`))
+}
+
+func (d *AuthorizeHandler) removeToken(r *http.Request, tokenIdentifier string) {
+	_, err := d.usc.RemoveToken(r.Context(), tokenIdentifier)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	d.auditor.Record(r, entity.AuditTokenRemoved, d.usc.TokenSource(), tokenIdentifier, "", err)
+}
+
+// clearToken removes credentials of r from the client.
+func (d *AuthorizeHandler) clearToken(w http.ResponseWriter, r *http.Request) {
+	d.tokenSetter.ClearToken(w, r)
+}
+
+func (d *AuthorizeHandler) writeToken(w http.ResponseWriter, token commondto.Token) {
+	d.tokenSetter.SetTokenIdentifier(w, token.ID)
+	d.tokenSetter.SetIDToken(w, token.IDToken)
+	d.tokenSetter.SetTokenSource(w, token.TokenSource)
+	if err := si.EncodeJson(w, token.HideSensitive()); err != nil {
+		logger.Error(err.Error())
+	}
 }
 
 func dumpRequest(r *http.Request) error {
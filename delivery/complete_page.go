@@ -0,0 +1,189 @@
+package delivery
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/w-woong/auth/authutil"
+)
+
+// CompletePageClient customizes the completion page of a client app.
+type CompletePageClient struct {
+	// Template is the template name, which is a file name in the template directory without ".html".
+	Template string
+	// AutoRedirect redirects the browser to the redirect uri of the auth request automatically.
+	AutoRedirect bool
+	// AllowedRedirects lists redirect uri prefixes(custom scheme or universal link) the client may ask for.
+	AllowedRedirects []string
+}
+
+// CompletePageData is passed to completion page templates.
+type CompletePageData struct {
+	UserName      string
+	Email         string
+	Provider      string
+	AuthRequestID string
+	Lang          string
+	RedirectUri   template.URL
+	AutoRedirect  bool
+	// Evicted is the number of sessions on other devices signed out by this one.
+	Evicted int
+}
+
+// CompletePage renders the page shown when authorization is completed.
+// Templates are loaded from "{name}.html" and localized "{name}.{lang}.html" files in a directory.
+type CompletePage struct {
+	mu              sync.RWMutex
+	templates       map[string]*template.Template
+	defaultTemplate string
+	clients         map[string]CompletePageClient
+}
+
+func NewCompletePage(dir, defaultTemplate string, clients map[string]CompletePageClient) (*CompletePage, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]*template.Template)
+	for _, file := range files {
+		t, err := template.ParseFiles(file)
+		if err != nil {
+			return nil, err
+		}
+		templates[strings.TrimSuffix(filepath.Base(file), ".html")] = t
+	}
+	if _, ok := templates[defaultTemplate]; !ok {
+		return nil, errors.New("default complete page template " + defaultTemplate + " does not exist")
+	}
+	for clientID, client := range clients {
+		if client.Template == "" {
+			continue
+		}
+		if _, ok := templates[client.Template]; !ok {
+			return nil, errors.New("complete page template " + client.Template + " of " + clientID + " does not exist")
+		}
+	}
+
+	return &CompletePage{
+		templates:       templates,
+		defaultTemplate: defaultTemplate,
+		clients:         clients,
+	}, nil
+}
+
+// Replace replaces templates and clients with those of loaded, which is validated by NewCompletePage.
+// The maps are replaced, never modified, so readers may use them after releasing the lock.
+func (p *CompletePage) Replace(loaded *CompletePage) {
+	loaded.mu.RLock()
+	defer loaded.mu.RUnlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.templates = loaded.templates
+	p.defaultTemplate = loaded.defaultTemplate
+	p.clients = loaded.clients
+}
+
+// CheckRedirect returns error if clientID is not allowed to be redirected to redirectUri.
+// An empty redirectUri is always allowed.
+func (p *CompletePage) CheckRedirect(clientID, redirectUri string) error {
+	p.mu.RLock()
+	clients := p.clients
+	p.mu.RUnlock()
+	return checkRedirect(clients, clientID, redirectUri)
+}
+
+func checkRedirect(clients map[string]CompletePageClient, clientID, redirectUri string) error {
+	if redirectUri == "" {
+		return nil
+	}
+	client, ok := clients[clientID]
+	if !ok {
+		return errors.New("unknown client " + clientID + " cannot be redirected")
+	}
+	if !authutil.IsAllowedRedirect(redirectUri, client.AllowedRedirects) {
+		return errors.New("redirect uri is not allowed for client " + clientID)
+	}
+	return nil
+}
+
+// Render executes the template of clientID in the language preferred by Accept-Language.
+func (p *CompletePage) Render(w http.ResponseWriter, r *http.Request, clientID, redirectUri string, data CompletePageData) error {
+	// rendered outside the lock, a slow client must not hold up Replace
+	p.mu.RLock()
+	templates, name, clients := p.templates, p.defaultTemplate, p.clients
+	p.mu.RUnlock()
+
+	client, ok := clients[clientID]
+	if ok && client.Template != "" {
+		name = client.Template
+	}
+
+	if checkRedirect(clients, clientID, redirectUri) == nil && redirectUri != "" {
+		// allowlisted, so custom schemes are trusted
+		data.RedirectUri = template.URL(redirectUri)
+		data.AutoRedirect = client.AutoRedirect
+	}
+
+	t := templates[name]
+	for _, lang := range acceptLanguages(r.Header.Get("Accept-Language")) {
+		if lt, ok := templates[name+"."+lang]; ok {
+			t = lt
+			data.Lang = lang
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if data.Lang != "" {
+		w.Header().Set("Content-Language", data.Lang)
+	}
+	return t.Execute(w, data)
+}
+
+// acceptLanguages returns language tags of header ordered by quality. A region tag like ko-KR
+// is followed by its primary language ko.
+func acceptLanguages(header string) []string {
+	type tag struct {
+		lang string
+		q    float64
+	}
+
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, tag{lang: lang, q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	langs := make([]string, 0, len(tags)*2)
+	for _, t := range tags {
+		langs = append(langs, t.lang)
+		if i := strings.Index(t.lang, "-"); i > 0 {
+			langs = append(langs, t.lang[:i])
+		}
+	}
+	return langs
+}
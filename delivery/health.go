@@ -0,0 +1,44 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/w-woong/auth/dto"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+)
+
+type HealthHandler struct {
+	usc port.HealthUsc
+}
+
+func NewHealthHandler(usc port.HealthUsc) *HealthHandler {
+	return &HealthHandler{
+		usc: usc,
+	}
+}
+
+// Live reports that the process is serving, even while draining.
+func (d *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, dto.Health{Status: dto.HealthOk, Draining: d.usc.Draining()})
+}
+
+// Ready reports 503 while draining or while any dependency is unavailable.
+func (d *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	health := d.usc.Ready(r.Context())
+	status := http.StatusOK
+	if health.Status != dto.HealthOk {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, health)
+}
+
+func writeHealth(w http.ResponseWriter, status int, health dto.Health) {
+	setNoCache(w)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(&health); err != nil {
+		logger.Error(err.Error())
+	}
+}
@@ -0,0 +1,91 @@
+package delivery
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/metrics"
+	"github.com/w-woong/auth/port"
+)
+
+// RateLimit is a token bucket refilled by Rate tokens per second up to Burst.
+// A zero Rate disables the bucket.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitRule limits a route per client ip and per token identifier.
+type RateLimitRule struct {
+	IP  RateLimit
+	Tid RateLimit
+}
+
+type RateLimiter struct {
+	usc            port.RateLimitUsc
+	tokenGetter    port.TokenGetter
+	trustedProxies int
+	rules          map[string]RateLimitRule
+}
+
+// NewRateLimiter creates RateLimiter with rules keyed by route name.
+// X-Forwarded-For is used for the client ip only if trustedProxies is not 0.
+func NewRateLimiter(usc port.RateLimitUsc, tokenGetter port.TokenGetter,
+	trustedProxies int, rules map[string]RateLimitRule) *RateLimiter {
+	return &RateLimiter{
+		usc:            usc,
+		tokenGetter:    tokenGetter,
+		trustedProxies: trustedProxies,
+		rules:          rules,
+	}
+}
+
+// Limit wraps next with the rule of route name. Requests pass through if there is no rule.
+func (l *RateLimiter) Limit(name string, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	rule, ok := l.rules[name]
+	if !ok {
+		return next
+	}
+	tokenGetter := l.tokenGetter.Route(name)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.take(w, r, "ip:"+name+":"+clientIP(r, l.trustedProxies), rule.IP) {
+			metrics.RateLimitDecisions.WithLabelValues(name, "limited").Inc()
+			return
+		}
+		if tid := tokenGetter.GetTokenIdentifier(r); tid != "" {
+			if !l.take(w, r, "tid:"+name+":"+tid, rule.Tid) {
+				metrics.RateLimitDecisions.WithLabelValues(name, "limited").Inc()
+				return
+			}
+		}
+		metrics.RateLimitDecisions.WithLabelValues(name, "allowed").Inc()
+		next(w, r)
+	}
+}
+
+// take responds with 429 and returns false when the bucket of key is empty.
+// It fails open when the store is not available.
+func (l *RateLimiter) take(w http.ResponseWriter, r *http.Request, key string, limit RateLimit) bool {
+	if limit.Rate <= 0 {
+		return true
+	}
+
+	allowed, retryAfter, err := l.usc.Take(r.Context(), key, limit.Rate, limit.Burst)
+	if err != nil {
+		logger.Error(err.Error())
+		return true
+	}
+	if allowed {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,32 @@
+package delivery
+
+import (
+	"net/http"
+	"sync"
+)
+
+// ReloadableHandler serves with the handler stored last, so that handlers wrapping the router,
+// like cors, can be rebuilt when the configuration changes.
+type ReloadableHandler struct {
+	mu      sync.RWMutex
+	handler http.Handler
+}
+
+func NewReloadableHandler(handler http.Handler) *ReloadableHandler {
+	return &ReloadableHandler{
+		handler: handler,
+	}
+}
+
+func (h *ReloadableHandler) Store(handler http.Handler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handler = handler
+}
+
+func (h *ReloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	handler := h.handler
+	h.mu.RUnlock()
+	handler.ServeHTTP(w, r)
+}
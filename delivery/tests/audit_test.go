@@ -0,0 +1,53 @@
+package delivery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/w-woong/auth/delivery"
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
+)
+
+type auditUsc struct {
+	events []entity.AuditEvent
+}
+
+func (u *auditUsc) Record(ctx context.Context, event entity.AuditEvent) {
+	u.events = append(u.events, event)
+}
+
+func (u *auditUsc) Find(ctx context.Context, filter port.AuditFilter) ([]entity.AuditEvent, error) {
+	return u.events, nil
+}
+
+func TestAuditor_Record_ClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies int
+		forwardedFor   []string
+		want           string
+	}{
+		{"no proxy", 0, []string{"203.0.113.9"}, "192.0.2.1"},
+		{"one proxy", 1, []string{"203.0.113.9"}, "203.0.113.9"},
+		{"forged by the client", 1, []string{"198.51.100.7, 203.0.113.9"}, "203.0.113.9"},
+		{"two proxies", 2, []string{"198.51.100.7, 203.0.113.9", "10.0.0.2"}, "203.0.113.9"},
+		{"fewer hops than proxies", 2, []string{"203.0.113.9"}, "192.0.2.1"},
+		{"no header", 1, nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usc := &auditUsc{}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			delivery.NewAuditor(usc, tt.trustedProxies).Record(r, entity.AuditLogin, "google", "", "", nil)
+			if len(usc.events) != 1 || usc.events[0].IP != tt.want {
+				t.Errorf("recorded %+v, want ip %v", usc.events, tt.want)
+			}
+		})
+	}
+}
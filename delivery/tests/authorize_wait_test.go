@@ -0,0 +1,180 @@
+package delivery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-wonk/si/sicore"
+	"github.com/gorilla/mux"
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/delivery"
+	"github.com/w-woong/auth/dto"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/usecase"
+	commondto "github.com/w-woong/common/dto"
+	"github.com/w-woong/common/txcom"
+)
+
+// tokenUsc is the token source of handlers which never reach the provider.
+type tokenUsc struct {
+	port.TokenUsc
+}
+
+func (u *tokenUsc) TokenSource() string { return "test" }
+
+// waitServer serves waits and signals of auth requests of one instance.
+type waitServer struct {
+	*httptest.Server
+	handler        *delivery.AuthorizeHandler
+	authRequestUsc *usecase.AuthRequest
+}
+
+func newWaitServer(t *testing.T) *waitServer {
+	t.Helper()
+	router := mux.NewRouter()
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	authRequestUsc := usecase.NewAuthRequest(
+		server.URL+"/v1/auth/request/test/{auth_request_id}",
+		server.URL+"/v1/auth/authorize/test/{auth_request_id}",
+		"cluster1", "X-Signature", "signal secret",
+		txcom.NewLockTxBeginner(), adapter.NewMapAuthRequest())
+	handler := delivery.NewAuthorizeHandler(&tokenUsc{}, nil, authRequestUsc, nil, nil,
+		5*time.Second, nil, nil, "https://lb.local/v1/auth/request/test/{auth_request_id}")
+	router.HandleFunc("/v1/auth/request/test/{auth_request_id}", handler.AuthRequestWait).Methods(http.MethodGet)
+	router.HandleFunc("/v1/auth/request/test/{auth_request_id}", handler.AuthRequestSignal).Methods(http.MethodPost)
+
+	return &waitServer{Server: server, handler: handler, authRequestUsc: authRequestUsc}
+}
+
+type waitResult struct {
+	status int
+	token  dto.SignalToken
+	retry  string
+}
+
+// wait waits for the result of a new auth request in the background, and returns its poll secret
+// once the waiter is registered.
+func (s *waitServer) wait(t *testing.T, id string) (<-chan waitResult, string) {
+	t.Helper()
+	authRequest, err := s.authRequestUsc.Save(context.Background(), id, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiters := s.handler.Waiters()
+
+	result := make(chan waitResult, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, s.URL+"/v1/auth/request/test/"+id, nil)
+		req.Header.Set(delivery.PollSecretHeader, authRequest.PollSecret)
+		res, err := s.Client().Do(req)
+		if err != nil {
+			result <- waitResult{}
+			return
+		}
+		defer res.Body.Close()
+		body := struct {
+			dto.SignalToken
+			RetryUrl string `json:"retry_url"`
+		}{}
+		json.NewDecoder(res.Body).Decode(&body)
+		result <- waitResult{status: res.StatusCode, token: body.SignalToken, retry: body.RetryUrl}
+	}()
+
+	for i := 0; s.handler.Waiters() == waiters; i++ {
+		if i == 200 {
+			t.Fatal("waiter is not registered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return result, authRequest.PollSecret
+}
+
+func (s *waitServer) signal(id string) error {
+	return s.authRequestUsc.Signal(context.Background(), id,
+		dto.SignalToken{Token: commondto.Token{ID: "tid-" + id, IDToken: "id-token"}})
+}
+
+func TestAuthorizeHandler_AuthRequestWait_Signal(t *testing.T) {
+	s := newWaitServer(t)
+	waited, pollSecret := s.wait(t, "id1")
+
+	if err := s.signal("id1"); err != nil {
+		t.Fatal(err)
+	}
+	// the poll secret is invalidated once the token is delivered
+	if _, err := s.authRequestUsc.Claim(context.Background(), "id1", pollSecret); err == nil {
+		t.Error("poll secret is claimed after its token is delivered")
+	}
+	result := <-waited
+	if result.status != http.StatusOK || result.token.ID != "tid-id1" {
+		t.Fatalf("waiter received %+v", result)
+	}
+}
+
+func TestAuthorizeHandler_AuthRequestWait_Drain(t *testing.T) {
+	s := newWaitServer(t)
+	waited, _ := s.wait(t, "id1")
+
+	s.handler.Drain()
+	result := <-waited
+	if result.status != http.StatusServiceUnavailable || result.retry != "https://lb.local/v1/auth/request/test/id1" {
+		t.Fatalf("waiter received %+v, want to retry", result)
+	}
+	// the auth request is kept for the retry, and no signal is taken by the drained waiter
+	if _, err := s.authRequestUsc.Find(context.Background(), "id1"); err != nil {
+		t.Errorf("auth request is removed on drain: %v", err)
+	}
+	if err := s.signal("id1"); err == nil {
+		t.Error("signal is taken by the drained waiter")
+	}
+
+	// later waits are told to retry at once
+	authRequest, _ := s.authRequestUsc.Save(context.Background(), "id2", "", "", "")
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"/v1/auth/request/test/id2", nil)
+	req.Header.Set(delivery.PollSecretHeader, authRequest.PollSecret)
+	res, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") == "" {
+		t.Errorf("wait while draining = %v", res.Status)
+	}
+}
+
+// A signal which takes the channel of a waiter delivers its token, even if Drain has woken the waiter
+// already. With a single P, the waiter woken by Drain runs only after the signal.
+func TestAuthorizeHandler_AuthRequestWait_SignalAfterDrain(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	for i := 0; i < 5; i++ {
+		s := newWaitServer(t)
+		id := "race" + strconv.Itoa(i)
+		waited, _ := s.wait(t, id)
+
+		body, _ := json.Marshal(dto.SignalToken{Token: commondto.Token{ID: "tid-" + id}})
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		signature, _ := sicore.HmacSha256HexEncoded("signal secret", append([]byte(id+"."+timestamp+"."), body...))
+		r := httptest.NewRequest(http.MethodPost, "/v1/auth/request/test/"+id, bytes.NewReader(body))
+		r.Header.Set("X-Signature", signature)
+		r.Header.Set(usecase.SignalTimestampHeader, timestamp)
+		s.handler.Drain()
+		w := httptest.NewRecorder()
+		s.handler.AuthRequestSignal(w, mux.SetURLVars(r, map[string]string{"auth_request_id": id}))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("signal = %v", w.Code)
+		}
+		if result := <-waited; result.status != http.StatusOK || result.token.ID != "tid-"+id {
+			t.Fatalf("waiter received %+v, want the signaled token", result)
+		}
+	}
+}
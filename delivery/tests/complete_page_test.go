@@ -0,0 +1,124 @@
+package delivery_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/w-woong/auth/delivery"
+)
+
+// newCompletePage writes templates rendering their name and data, and loads them with clients.
+func newCompletePage(t *testing.T, clients map[string]delivery.CompletePageClient) *delivery.CompletePage {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"auth_complete", "auth_complete.ko", "app", "app.ja"} {
+		content := name + "|{{.Lang}}|{{.RedirectUri}}|{{.AutoRedirect}}"
+		if err := os.WriteFile(filepath.Join(dir, name+".html"), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page, err := delivery.NewCompletePage(dir, "auth_complete", clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+var completePageClients = map[string]delivery.CompletePageClient{
+	"app1": {Template: "app", AutoRedirect: true, AllowedRedirects: []string{"app1://callback"}},
+	"web1": {AllowedRedirects: []string{"https://web1.example.com/done"}},
+}
+
+func TestNewCompletePage_MissingTemplate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "auth_complete.html"), []byte("ok"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := delivery.NewCompletePage(dir, "missing", nil); err == nil {
+		t.Error("NewCompletePage() with a missing default template = nil")
+	}
+	clients := map[string]delivery.CompletePageClient{"app1": {Template: "missing"}}
+	if _, err := delivery.NewCompletePage(dir, "auth_complete", clients); err == nil {
+		t.Error("NewCompletePage() with a missing client template = nil")
+	}
+}
+
+func TestCompletePage_CheckRedirect(t *testing.T) {
+	page := newCompletePage(t, completePageClients)
+	tests := []struct {
+		name        string
+		clientID    string
+		redirectUri string
+		wantErr     bool
+	}{
+		{"no redirect", "", "", false},
+		{"no redirect of an unknown client", "unknown", "", false},
+		{"allowed", "app1", "app1://callback/home", false},
+		{"allowed universal link", "web1", "https://web1.example.com/done?x=1", false},
+		{"another client's", "web1", "app1://callback/home", true},
+		{"not allowed", "app1", "evil://callback", true},
+		{"unknown client", "unknown", "app1://callback/home", true},
+		{"dot segments", "app1", "app1://callback/../evil", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := page.CheckRedirect(tt.clientID, tt.redirectUri); (err != nil) != tt.wantErr {
+				t.Errorf("CheckRedirect() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompletePage_Render(t *testing.T) {
+	page := newCompletePage(t, completePageClients)
+	tests := []struct {
+		name            string
+		clientID        string
+		redirectUri     string
+		acceptLanguage  string
+		want            string
+		contentLanguage string
+	}{
+		{name: "default", want: "auth_complete|||false"},
+		{name: "region falls back to its language", acceptLanguage: "ko-KR",
+			want: "auth_complete.ko|ko||false", contentLanguage: "ko"},
+		{name: "ordered by quality", acceptLanguage: "en;q=0.5, ko;q=0.8",
+			want: "auth_complete.ko|ko||false", contentLanguage: "ko"},
+		{name: "missing language", acceptLanguage: "fr-FR, fr;q=0.9", want: "auth_complete|||false"},
+		{name: "refused language", acceptLanguage: "ko;q=0", want: "auth_complete|||false"},
+		{name: "wildcard", acceptLanguage: "*", want: "auth_complete|||false"},
+		{name: "client template", clientID: "app1", redirectUri: "app1://callback/home",
+			want: "app||app1://callback/home|true"},
+		{name: "client template in language", clientID: "app1", acceptLanguage: "ja-JP",
+			want: "app.ja|ja||false", contentLanguage: "ja"},
+		{name: "client template missing language", clientID: "app1", acceptLanguage: "ko",
+			want: "app|||false"},
+		{name: "redirect not allowed", clientID: "app1", redirectUri: "evil://callback",
+			want: "app|||false"},
+		{name: "client without template", clientID: "web1", redirectUri: "https://web1.example.com/done",
+			acceptLanguage: "ko", want: "auth_complete.ko|ko|https://web1.example.com/done|false", contentLanguage: "ko"},
+		{name: "unknown client", clientID: "unknown", redirectUri: "app1://callback/home",
+			want: "auth_complete|||false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			if err := page.Render(w, r, tt.clientID, tt.redirectUri, delivery.CompletePageData{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+			if got := w.Header().Get("Content-Language"); got != tt.contentLanguage {
+				t.Errorf("Content-Language = %q, want %q", got, tt.contentLanguage)
+			}
+		})
+	}
+}
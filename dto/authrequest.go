@@ -12,4 +12,9 @@ type AuthRequest struct {
 	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
 	ResponseUrl string     `json:"response_url,omitempty"`
 	AuthUrl     string     `json:"auth_url"`
+	ClusterID   string     `json:"-"`
+	PollSecret  string     `json:"poll_secret,omitempty"`
+	ClientID    string     `json:"client_id,omitempty"`
+	RedirectUri string     `json:"redirect_uri,omitempty"`
+	DeviceID    string     `json:"device_id,omitempty"`
 }
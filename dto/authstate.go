@@ -13,4 +13,5 @@ type AuthState struct {
 
 	CodeVerifier  string `json:"code_verifier,omitempty"`
 	AuthRequestID string `json:"auth_request_id,omitempty"`
+	ReturnTo      string `json:"return_to,omitempty"`
 }
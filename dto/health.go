@@ -0,0 +1,20 @@
+package dto
+
+const (
+	HealthOk       = "ok"
+	HealthFail     = "fail"
+	HealthDraining = "draining"
+)
+
+// Health is the readiness of the service and of each of its dependencies.
+type Health struct {
+	Status   string        `json:"status"`
+	Draining bool          `json:"draining"`
+	Checks   []HealthCheck `json:"checks,omitempty"`
+}
+
+type HealthCheck struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+}
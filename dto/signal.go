@@ -0,0 +1,10 @@
+package dto
+
+import commondto "github.com/w-woong/common/dto"
+
+// SignalToken is the token delivered to the waiter of an auth request.
+type SignalToken struct {
+	commondto.Token
+	// SessionsEvicted is the number of sessions of the user on other devices signed out by the login.
+	SessionsEvicted int `json:"sessions_evicted,omitempty"`
+}
@@ -0,0 +1,36 @@
+package entity
+
+import "time"
+
+var (
+	NilAuditEvent = AuditEvent{}
+)
+
+const (
+	AuditAuthorize    = "authorize"
+	AuditLogin        = "login"
+	AuditValidate     = "validate"
+	AuditRefresh      = "refresh"
+	AuditTokenRemoved = "token_removed"
+	AuditSignal       = "signal"
+)
+
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is a record of an authentication event.
+type AuditEvent struct {
+	ID        int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt *time.Time `gorm:"<-:create;index" json:"created_at,omitempty"`
+
+	Type        string `gorm:"index:idx_audit_events_1;type:string;size:32" json:"type"`
+	TokenID     string `gorm:"index:idx_audit_events_2;type:string;size:64" json:"tid,omitempty"`
+	Subject     string `gorm:"index:idx_audit_events_3;type:string;size:255" json:"sub,omitempty"`
+	TokenSource string `gorm:"type:string;size:32" json:"token_source,omitempty"`
+	IP          string `gorm:"type:string;size:64" json:"ip,omitempty"`
+	UserAgent   string `gorm:"type:string;size:512" json:"user_agent,omitempty"`
+	Outcome     string `gorm:"type:string;size:16" json:"outcome"`
+	ErrorCode   string `gorm:"type:string;size:64" json:"error_code,omitempty"`
+}
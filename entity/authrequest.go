@@ -12,4 +12,9 @@ type AuthRequest struct {
 	UpdatedAt   *time.Time `gorm:"<-" json:"updated_at,omitempty"`
 	ResponseUrl string     `gorm:"type:string;size:4096;comment:url to send token data to connected clients;" json:"response_url,omitempty"`
 	AuthUrl     string     `gorm:"type:string;size:4096;comment:url to request authorization;" json:"auth_url"`
+	ClusterID   string     `gorm:"type:string;size:128;comment:cluster that created the request;" json:"cluster_id,omitempty"`
+	PollSecret  string     `gorm:"type:string;size:64;comment:hashed secret required to wait for the result;" json:"-"`
+	ClientID    string     `gorm:"type:string;size:128;comment:client app that started the request;" json:"client_id,omitempty"`
+	RedirectUri string     `gorm:"type:string;size:4096;comment:deep link to the client app after authorization;" json:"redirect_uri,omitempty"`
+	DeviceID    string     `gorm:"type:string;size:128;comment:device of the client app, tokens are kept per device;" json:"device_id,omitempty"`
 }
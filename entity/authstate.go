@@ -13,4 +13,5 @@ type AuthState struct {
 
 	CodeVerifier  string `gorm:"type:string;size:1024" json:"code_verifier,omitempty"`
 	AuthRequestID string `gorm:"type:string;size:1024" json:"auth_request_id,omitempty"`
+	ReturnTo      string `gorm:"type:string;size:4096" json:"return_to,omitempty"`
 }
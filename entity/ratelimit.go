@@ -0,0 +1,17 @@
+package entity
+
+import "time"
+
+var (
+	NilRateLimitBucket = RateLimitBucket{}
+)
+
+// RateLimitBucket is a token bucket shared by instances through a repository.
+type RateLimitBucket struct {
+	Key       string     `gorm:"primaryKey;type:string;size:512;comment:route and client key" json:"key"`
+	CreatedAt *time.Time `gorm:"<-:create" json:"created_at,omitempty"`
+	UpdatedAt *time.Time `gorm:"<-;index" json:"updated_at,omitempty"`
+
+	Tokens     float64 `gorm:"type:double precision" json:"tokens"`
+	RefilledAt int64   `gorm:"type:bigint;comment:unix milli" json:"refilled_at"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+var (
+	NilSession = Session{}
+)
+
+// Session keeps tokens server-side, browsers only hold its ID in a cookie.
+type Session struct {
+	ID        string     `gorm:"primaryKey;type:string;size:64;comment:id" json:"id"`
+	CreatedAt *time.Time `gorm:"<-:create" json:"created_at,omitempty"`
+	UpdatedAt *time.Time `gorm:"<-" json:"updated_at,omitempty"`
+
+	TokenID     string      `gorm:"index;type:string;size:64" json:"token_id,omitempty"`
+	IDToken     string      `gorm:"type:string" json:"id_token,omitempty"`
+	TokenSource TokenSource `gorm:"type:string;size:32" json:"token_source,omitempty"`
+	ExpiresAt   int64       `gorm:"index;type:bigint" json:"expires_at,omitempty"`
+}
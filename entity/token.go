@@ -18,10 +18,38 @@ type Token struct {
 	CreatedAt *time.Time `gorm:"<-:create" json:"created_at,omitempty"`
 	UpdatedAt *time.Time `gorm:"<-" json:"updated_at,omitempty"`
 
-	TokenSource  TokenSource `gorm:"uniqueIndex:idx_tokens_1;type:string;size:32" json:"token_source,omitempty"`
+	TokenSource  TokenSource `gorm:"uniqueIndex:idx_tokens_1;index:idx_tokens_2,priority:1;uniqueIndex:idx_tokens_6;type:string;size:32" json:"token_source,omitempty"`
 	AccessToken  string      `gorm:"uniqueIndex:idx_tokens_1;type:string" json:"access_token,omitempty"`
 	RefreshToken string      `gorm:"type:string" json:"refresh_token,omitempty"`
 	TokenType    string      `gorm:"type:string;size:32" json:"token_type,omitempty"`
 	IDToken      string      `gorm:"type:string" json:"id_token,omitempty"`
 	Expiry       int64       `gorm:"type:int" json:"expiry,omitempty"`
+
+	// Subject and the others are taken from the validated id_token when the token is saved.
+	Subject  string `gorm:"index:idx_tokens_2,priority:2;uniqueIndex:idx_tokens_6;type:string;size:255" json:"sub,omitempty"`
+	Email    string `gorm:"index:idx_tokens_3;type:string;size:255" json:"email,omitempty"`
+	Issuer   string `gorm:"type:string;size:255" json:"iss,omitempty"`
+	Audience string `gorm:"type:string" json:"aud,omitempty"`
+	Scope    string `gorm:"type:string" json:"scope,omitempty"`
+	SID      string `gorm:"index:idx_tokens_4;type:string;size:255" json:"sid,omitempty"`
+	UserID   string `gorm:"index:idx_tokens_5;type:string;size:64" json:"user_id,omitempty"`
+	// Claims is a json snapshot of the id_token claims.
+	Claims string `gorm:"type:string" json:"claims,omitempty"`
+	// Device is the client device or browser of the session, a token is kept per subject and device.
+	Device string `gorm:"uniqueIndex:idx_tokens_6;type:string;size:128" json:"device,omitempty"`
+
+	// PreviousIDToken is the id_token replaced by the last refresh, so that requests racing
+	// the refresh with it are given the refreshed token.
+	PreviousIDToken string `gorm:"type:string" json:"-"`
+
+	// RefreshFailures counts consecutive failures of background refresh.
+	RefreshFailures int `gorm:"type:int;default:0" json:"-"`
+
+	// RefreshingUntil is the unix time the refresh in progress, of any instance, ends by. Other
+	// refreshes wait for it, rather than presenting the same refresh token.
+	RefreshingUntil int64 `gorm:"type:int;default:0" json:"-"`
+
+	// IDTokenNotIssued is set when the last refresh issued no id_token. The id_token is not
+	// refreshed again before the access token of that refresh expires.
+	IDTokenNotIssued bool `gorm:"default:false" json:"-"`
 }
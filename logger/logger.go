@@ -0,0 +1,125 @@
+// Package logger writes entries through the logger common/logger opens, above a level which can be
+// changed while running. common/logger is opened at the debug level and filtered here.
+package logger
+
+import (
+	"errors"
+	"fmt"
+
+	commonlogger "github.com/w-woong/common/logger"
+	"github.com/w-woong/common/logger/core"
+	"github.com/w-woong/common/logger/factory"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var ErrLevelUnknown = errors.New("unknown log level")
+
+var (
+	level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	// _logger is the global zap logger, which common/logger replaces when it is opened. It is
+	// called one frame closer to the caller than common/logger calls it.
+	_logger *zap.Logger
+)
+
+func init() {
+	wrap()
+}
+
+func wrap() {
+	_logger = zap.L().WithOptions(zap.IncreaseLevel(level), zap.AddCallerSkip(-1))
+}
+
+// Open opens common/logger with outputs of stdOut and fileName, and sets level. It is called before
+// anything logs from other goroutines.
+func Open(level string, stdOut bool,
+	fileName string, maxSize int, maxBackup int, maxAge int, compress bool) error {
+	parsed, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	commonlogger.Open(string(core.DebugLevel), stdOut, fileName, maxSize, maxBackup, maxAge, compress)
+	wrap()
+	SetLevel(parsed)
+	return nil
+}
+
+func Close() {
+	commonlogger.Close()
+}
+
+// ParseLevel parses debug, info, warn, error, fatal or panic.
+func ParseLevel(level string) (zapcore.Level, error) {
+	var parsed zapcore.Level
+	switch core.Level(level) {
+	case core.DebugLevel, core.InfoLevel, core.WarnLevel, core.ErrorLevel, core.FatalLevel, core.PanicLevel:
+		err := parsed.UnmarshalText([]byte(level))
+		return parsed, err
+	}
+	return parsed, fmt.Errorf("%w: %s", ErrLevelUnknown, level)
+}
+
+// SetLevel replaces the level of entries written from now on.
+func SetLevel(l zapcore.Level) {
+	level.SetLevel(l)
+}
+
+func Level() zapcore.Level {
+	return level.Level()
+}
+
+// OpenGormLogger creates the gorm logger of level, which is also filtered by the level of this
+// package.
+func OpenGormLogger(level string) *factory.GormLogger {
+	if level == "" {
+		level = string(core.ErrorLevel)
+	}
+	return factory.NewGormLogger(core.Level(level), gormLogger{})
+}
+
+func Debug(message string, fields ...zap.Field) {
+	_logger.Debug(message, fields...)
+}
+func Info(message string, fields ...zap.Field) {
+	_logger.Info(message, fields...)
+}
+func Warn(message string, fields ...zap.Field) {
+	_logger.Warn(message, fields...)
+}
+func Error(message string, fields ...zap.Field) {
+	_logger.Error(message, fields...)
+}
+
+func UrlField(value string) zap.Field {
+	return zap.String("url", value)
+}
+
+// gormLogger writes entries of gorm through common/logger if they are above level.
+type gormLogger struct{}
+
+func (gormLogger) Debug(message string, fields ...core.Field) {
+	if level.Enabled(zapcore.DebugLevel) {
+		commonlogger.Debug(message, fields...)
+	}
+}
+func (gormLogger) Info(message string, fields ...core.Field) {
+	if level.Enabled(zapcore.InfoLevel) {
+		commonlogger.Info(message, fields...)
+	}
+}
+func (gormLogger) Warn(message string, fields ...core.Field) {
+	if level.Enabled(zapcore.WarnLevel) {
+		commonlogger.Warn(message, fields...)
+	}
+}
+func (gormLogger) Error(message string, fields ...core.Field) {
+	if level.Enabled(zapcore.ErrorLevel) {
+		commonlogger.Error(message, fields...)
+	}
+}
+func (gormLogger) Fatal(message string, fields ...core.Field) {
+	commonlogger.Fatal(message, fields...)
+}
+func (gormLogger) Panic(message string, fields ...core.Field) {
+	commonlogger.Panic(message, fields...)
+}
@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSetLevel(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	global := zap.L()
+	// common/logger skips its two frames
+	zap.ReplaceGlobals(zap.New(core, zap.AddCaller(), zap.AddCallerSkip(2)))
+	wrap()
+	defer func() {
+		zap.ReplaceGlobals(global)
+		wrap()
+		SetLevel(zapcore.DebugLevel)
+	}()
+
+	SetLevel(zapcore.WarnLevel)
+	Info("dropped")
+	Warn("written")
+	SetLevel(zapcore.DebugLevel)
+	Debug("written after the level is lowered")
+
+	entries := logs.AllUntimed()
+	if len(entries) != 2 || entries[0].Message != "written" ||
+		entries[1].Message != "written after the level is lowered" {
+		t.Fatalf("entries = %v", entries)
+	}
+	if file := entries[0].Caller.File; !strings.HasSuffix(file, "logger_test.go") {
+		t.Errorf("caller = %s, want the caller of Warn", file)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel("warn"); err != nil || level != zapcore.WarnLevel {
+		t.Errorf("ParseLevel(warn) = %v, %v", level, err)
+	}
+	for _, level := range []string{"", "WARN", "dpanic", "verbose"} {
+		if _, err := ParseLevel(level); !errors.Is(err, ErrLevelUnknown) {
+			t.Errorf("ParseLevel(%q) = %v, want %v", level, err, ErrLevelUnknown)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics of the authorization funnel.
+var (
+	AuthRequestsCreated = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_requests_created_total",
+		Help: "Auth requests created.",
+	}, []string{"token_source"})
+	AuthorizeRedirects = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_authorize_redirects_total",
+		Help: "Browsers redirected to the authorization server.",
+	}, []string{"token_source"})
+	Callbacks = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_callbacks_total",
+		Help: "Callbacks from the authorization server by outcome.",
+	}, []string{"token_source", "outcome"})
+	ExchangeDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auth_exchange_duration_seconds",
+		Help:    "Latency of exchanging codes at the token endpoint.",
+		Buckets: DefBuckets,
+	}, []string{"token_source", "outcome"})
+	Validations = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_validate_total",
+		Help: "Validations by result, which is valid, refreshed or rejected.",
+	}, []string{"token_source", "result"})
+	RefreshFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_refresh_failures_total",
+		Help: "Failed refreshes by mode, which is expired, proactive, requested or background.",
+	}, []string{"token_source", "mode"})
+	RegisterUserDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auth_register_user_duration_seconds",
+		Help:    "Latency of registering users.",
+		Buckets: DefBuckets,
+	}, []string{"token_source"})
+	RegisterUserErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_register_user_errors_total",
+		Help: "Failed user registrations.",
+	}, []string{"token_source"})
+	LongPollWaiters = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "auth_long_poll_waiters",
+		Help: "Clients waiting for the result of auth requests.",
+	})
+	JwksFetches = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_jwks_fetches_total",
+		Help: "Fetches of jwks by outcome.",
+	}, []string{"outcome"})
+	RateLimitDecisions = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_rate_limit_total",
+		Help: "Rate limit decisions by route and result, which is allowed or limited.",
+	}, []string{"route", "result"})
+	BackgroundRefreshes = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_background_refresh_total",
+		Help: "Background refreshes of offline tokens by outcome.",
+	}, []string{"outcome"})
+)
+
+// Outcome returns the outcome label of err.
+func Outcome(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+type jwksTransport struct {
+	next http.RoundTripper
+}
+
+// NewJwksTransport counts round trips made through next as jwks fetches. It is the transport
+// of the client fetching jwks.
+func NewJwksTransport(next http.RoundTripper) http.RoundTripper {
+	return &jwksTransport{
+		next: next,
+	}
+}
+
+func (t *jwksTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(r)
+	if err == nil && res.StatusCode >= 400 {
+		JwksFetches.WithLabelValues("failure").Inc()
+	} else {
+		JwksFetches.WithLabelValues(Outcome(err)).Inc()
+	}
+	return res, err
+}
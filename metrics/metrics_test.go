@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	Callbacks.WithLabelValues("test", Outcome(nil)).Inc()
+	Callbacks.WithLabelValues("test", Outcome(errors.New("failed"))).Add(2)
+	ExchangeDuration.WithLabelValues("test", "success").Observe(0.05)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE auth_callbacks_total counter\n",
+		`auth_callbacks_total{outcome="success",token_source="test"} 1` + "\n",
+		`auth_callbacks_total{outcome="failure",token_source="test"} 2` + "\n",
+		`auth_exchange_duration_seconds_bucket{outcome="success",token_source="test",le="0.05"} 1` + "\n",
+		`auth_exchange_duration_seconds_count{outcome="success",token_source="test"} 1` + "\n",
+		"auth_long_poll_waiters 0\n",
+		"# TYPE go_goroutines gauge\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestJwksTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jwks" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: NewJwksTransport(http.DefaultTransport)}
+
+	for _, path := range []string{"/jwks", "/missing"} {
+		res, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`auth_jwks_fetches_total{outcome="success"} 1` + "\n",
+		`auth_jwks_fetches_total{outcome="failure"} 1` + "\n",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+}
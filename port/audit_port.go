@@ -0,0 +1,36 @@
+package port
+
+import (
+	"context"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/common"
+)
+
+// AuditFilter selects audit events. Empty fields match all. Events are returned the most recent
+// first, starting before the id Before if it is not 0.
+type AuditFilter struct {
+	Subject string
+	Type    string
+	TokenID string
+	Before  int64
+	Limit   int
+}
+
+type AuditRepo interface {
+	// Create stores event and returns its id.
+	Create(ctx context.Context, tx common.TxController, event entity.AuditEvent) (int64, error)
+	Find(ctx context.Context, filter AuditFilter) ([]entity.AuditEvent, error)
+}
+
+// AuditSink receives every recorded event, for example to ship it to a SIEM.
+type AuditSink interface {
+	Write(event entity.AuditEvent) error
+}
+
+type AuditUsc interface {
+	// Record stores event and writes it to sinks, with the id it is stored with. Failures are logged,
+	// they never fail the request.
+	Record(ctx context.Context, event entity.AuditEvent)
+	Find(ctx context.Context, filter AuditFilter) ([]entity.AuditEvent, error)
+}
@@ -12,4 +12,6 @@ type AuthRequestRepo interface {
 	Read(ctx context.Context, tx common.TxController, id string) (entity.AuthRequest, error)
 	ReadNoTx(ctx context.Context, id string) (entity.AuthRequest, error)
 	Delete(ctx context.Context, tx common.TxController, id string) (int64, error)
+	// UpdateResponseUrl changes where the result of auth request id is sent to.
+	UpdateResponseUrl(ctx context.Context, tx common.TxController, id, responseUrl string) (int64, error)
 }
@@ -2,15 +2,20 @@ package port
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/w-woong/auth/dto"
-	commondto "github.com/w-woong/common/dto"
 )
 
 type AuthRequestUsc interface {
-	Save(ctx context.Context, id string) (dto.AuthRequest, error)
+	Save(ctx context.Context, id, clientID, redirectUri, deviceID string) (dto.AuthRequest, error)
 	Find(ctx context.Context, id string) (dto.AuthRequest, error)
+	// Claim finds auth request id only for the client holding its poll secret, the result is sent to
+	// this instance from now on.
+	Claim(ctx context.Context, id, pollSecret string) (dto.AuthRequest, error)
 	Remove(ctx context.Context, id string) (int64, error)
 
-	Signal(ctx context.Context, id string, token commondto.Token) error
+	Signal(ctx context.Context, id string, token dto.SignalToken) error
+	// VerifySignal authenticates a signal request carrying body for auth request id.
+	VerifySignal(r *http.Request, id string, body []byte) error
 }
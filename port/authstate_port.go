@@ -17,6 +17,16 @@ type AuthStateRepo interface {
 }
 
 type AuthStateUsc interface {
-	Create(ctx context.Context, authRequestID string) (entity.AuthState, error)
+	// Create creates a state for authRequestID and binds it to the browser of w. Browsers are
+	// redirected to returnTo after authorization if it is not empty.
+	Create(w http.ResponseWriter, r *http.Request, authRequestID, returnTo string) (entity.AuthState, error)
+	// Verify verifies the state on the query is bound to the browser of r.
 	Verify(w http.ResponseWriter, r *http.Request) (entity.AuthState, error)
 }
+
+// StateCookie binds a state to the browser.
+type StateCookie interface {
+	SetState(w http.ResponseWriter, state string)
+	VerifyState(r *http.Request, state string) error
+	RemoveState(w http.ResponseWriter)
+}
@@ -0,0 +1,21 @@
+package port
+
+import "net/http"
+
+// Credential is what a client presents to identify its token.
+type Credential struct {
+	TokenIdentifier string
+	IDToken         string
+	TokenSource     string
+}
+
+func (c Credential) IsEmpty() bool {
+	return c.TokenIdentifier == "" && c.IDToken == "" && c.TokenSource == ""
+}
+
+// CredentialExtractor extracts a credential from one place of a request. Fields not present
+// are left empty.
+type CredentialExtractor interface {
+	Name() string
+	Extract(r *http.Request) Credential
+}
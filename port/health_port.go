@@ -0,0 +1,21 @@
+package port
+
+import (
+	"context"
+
+	"github.com/w-woong/auth/dto"
+)
+
+// HealthCheck is a dependency which must be available for the service to be ready.
+type HealthCheck interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+type HealthUsc interface {
+	// Ready runs every check. The service is not ready while draining.
+	Ready(ctx context.Context) dto.Health
+	// SetDraining marks the service as shutting down.
+	SetDraining(draining bool)
+	Draining() bool
+}
@@ -0,0 +1,25 @@
+package port
+
+import (
+	"context"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/common"
+)
+
+type RateLimitRepo interface {
+	// ReadForUpdate reads a bucket by key and locks it until tx ends.
+	ReadForUpdate(ctx context.Context, tx common.TxController, key string) (entity.RateLimitBucket, error)
+	// Save creates or updates a bucket.
+	Save(ctx context.Context, tx common.TxController, bucket entity.RateLimitBucket) (int64, error)
+	// DeleteUpdatedBefore deletes buckets not touched since t.
+	DeleteUpdatedBefore(ctx context.Context, tx common.TxController, t time.Time) (int64, error)
+}
+
+type RateLimitUsc interface {
+	// Take takes a token from the bucket of key which is refilled by rate per second up to burst.
+	// It returns false and how long to wait when the bucket is empty.
+	Take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error)
+	Purge(ctx context.Context, idleFor time.Duration) (int64, error)
+}
@@ -0,0 +1,29 @@
+package port
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrSecretNotFound = errors.New("secret is not found")
+	// ErrSecretSchemeUnknown is returned for references of schemes no provider is registered for,
+	// like vault:// without vault configured. They are never taken as the secret itself.
+	ErrSecretSchemeUnknown = errors.New("no secret provider is registered for the scheme")
+)
+
+// SecretProvider resolves references of a scheme. ref is what follows scheme://, like
+// /run/secrets/client_secret of file:///run/secrets/client_secret.
+type SecretProvider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+type SecretUsc interface {
+	// Resolve returns value as it is unless it is a reference, like scheme://ref. References of
+	// schemes which are not registered fail with ErrSecretSchemeUnknown.
+	Resolve(ctx context.Context, value string) (string, error)
+	// Refresh resolves every reference resolved so far again, and reports whether any changed.
+	Refresh(ctx context.Context) (bool, error)
+	// Retain forgets resolved references other than values.
+	Retain(values []string)
+}
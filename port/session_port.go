@@ -0,0 +1,30 @@
+package port
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/common"
+)
+
+type SessionRepo interface {
+	// Save creates or updates a session.
+	Save(ctx context.Context, tx common.TxController, session entity.Session) (int64, error)
+	ReadNoTx(ctx context.Context, id string) (entity.Session, error)
+	Read(ctx context.Context, tx common.TxController, id string) (entity.Session, error)
+	ReadByTokenID(ctx context.Context, tx common.TxController, tokenID string) (entity.Session, error)
+	Delete(ctx context.Context, tx common.TxController, id string) (int64, error)
+	DeleteExpired(ctx context.Context, tx common.TxController, now time.Time) (int64, error)
+}
+
+// SessionIDCookie carries an opaque session id.
+type SessionIDCookie interface {
+	GetSessionID(r *http.Request) string
+	// GetSessionIDFromResponse returns the session id already set on w, if any.
+	GetSessionIDFromResponse(w http.ResponseWriter) string
+	SetSessionID(w http.ResponseWriter, sessionID string)
+	RemoveSessionID(w http.ResponseWriter)
+	ExpireAfter() time.Duration
+}
@@ -12,3 +12,9 @@ type TokenCookie interface {
 	GetTokenSource(r *http.Request) string
 	SetTokenSource(w http.ResponseWriter, tokenSource string)
 }
+
+// CookieCodec encodes cookie values so that clients can neither read nor modify them.
+type CookieCodec interface {
+	Encode(name, value string) (string, error)
+	Decode(name, encoded string) (string, error)
+}
@@ -2,11 +2,17 @@ package port
 
 import (
 	"context"
+	"errors"
 
 	"github.com/w-woong/auth/entity"
 	"github.com/w-woong/common"
 )
 
+// ErrDuplicateRecord is returned by repositories when a record with the same key exists, like tokens
+// of the same id or of the same token source and access token. Records which are not found are
+// common.ErrRecordNotFound.
+var ErrDuplicateRecord = errors.New("duplicate record")
+
 type TokenRepo interface {
 	// Create save token to a repository.
 	Create(ctx context.Context, tx common.TxController, token entity.Token) (int64, error)
@@ -14,6 +20,21 @@ type TokenRepo interface {
 	// Read reads token by id.
 	Read(ctx context.Context, tx common.TxController, id string) (entity.Token, error)
 	ReadNoTx(ctx context.Context, id string) (entity.Token, error)
+	// ReadForUpdate reads token by id and locks it until tx ends.
+	ReadForUpdate(ctx context.Context, tx common.TxController, id string) (entity.Token, error)
+
+	// ReadBySubject reads tokens of subject issued by tokenSource, the most recent first. They are
+	// locked until tx ends.
+	ReadBySubject(ctx context.Context, tx common.TxController, tokenSource entity.TokenSource, subject string) ([]entity.Token, error)
+	// ReadByUserID reads tokens of userID, the most recent first.
+	ReadByUserID(ctx context.Context, userID string) ([]entity.Token, error)
+
+	// ReadRefreshable reads up to limit tokens having a refresh token which expire before expiry
+	// and failed background refresh less than maxFailures times in a row.
+	ReadRefreshable(ctx context.Context, expiry int64, maxFailures, limit int) ([]entity.Token, error)
+
+	// Update updates a token.
+	Update(ctx context.Context, tx common.TxController, token entity.Token) (int64, error)
 
 	// Delete deletes a token from a repository.
 	Delete(ctx context.Context, tx common.TxController, id string) (int64, error)
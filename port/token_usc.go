@@ -2,13 +2,23 @@ package port
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	commondto "github.com/w-woong/common/dto"
 	"golang.org/x/oauth2"
 )
 
+var (
+	// ErrRefreshRejected is returned when the provider rejects the refresh token.
+	ErrRefreshRejected = errors.New("refresh token is rejected")
+	// ErrIDTokenNotIssued is returned when a refresh succeeds without an id_token. The refreshed
+	// tokens are kept with the previous id_token.
+	ErrIDTokenNotIssued = errors.New("no id_token is issued by the refresh")
+)
+
 type TokenUsc interface {
 	TokenSource() string
 
@@ -16,9 +26,16 @@ type TokenUsc interface {
 	// state is sent on query parameter and codeVeifier is used to generate codeChallenge
 	// which is sent on query parameter as well as state.
 	// RetrieveAuthUrl(ctx context.Context, state, codeVerifier string) (string, error)
-	AuthorizeCode(w http.ResponseWriter, r *http.Request, state, codeVerifier string) error
+	// AuthorizeCode asks for consent only if consent is true, since providers like google issue
+	// refresh tokens only on consent.
+	AuthorizeCode(w http.ResponseWriter, r *http.Request, state, codeVerifier string, consent bool) error
 	Exchange(r *http.Request, codeVerifier string) (*oauth2.Token, error)
 	Refresh(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error)
+	// RefreshToken refreshes the stored token of id presenting idToken, and updates it in place.
+	// The stored token is left as is if the provider fails. Concurrent calls are refreshed once.
+	RefreshToken(ctx context.Context, id, idToken string) (commondto.Token, error)
+	// ShouldRefresh reports whether a token expiring at expiresAt is within the refresh window.
+	ShouldRefresh(expiresAt time.Time) bool
 	Revoke(ctx context.Context, token *oauth2.Token) error
 	Userinfo(ctx context.Context, token *oauth2.Token) error
 	ValidateIDToken(ctx context.Context, idToken string) (*jwt.Token, *commondto.IDTokenClaims, error)
@@ -36,9 +53,16 @@ type TokenUsc interface {
 
 	// ValidateState(w http.ResponseWriter, r *http.Request) (entity.AuthState, error)
 
-	SaveToken(ctx context.Context, w http.ResponseWriter, token *oauth2.Token) (commondto.Token, error)
+	// SaveToken saves token as the session of its subject on device, a new device if empty. It returns
+	// the number of the oldest sessions evicted to keep the maximum sessions per user.
+	SaveToken(ctx context.Context, w http.ResponseWriter, token *oauth2.Token, device string) (commondto.Token, int, error)
 	FindWithIDToken(ctx context.Context, id, idToken string) (*oauth2.Token, error)
+	// HasOfflineToken reports whether a refresh token is held for the subject of the token of id.
+	HasOfflineToken(ctx context.Context, id string) bool
 	RemoveToken(ctx context.Context, id string) (int64, error)
+	// Logout removes the token of id presenting idToken and revokes it at the provider, unless its
+	// refresh token is used by other sessions of the user.
+	Logout(ctx context.Context, id, idToken string) error
 
 	RegisterUser(ctx context.Context, tokenID string, claims commondto.IDTokenClaims) (commondto.User, error)
 }
@@ -48,10 +72,22 @@ type TokenGetter interface {
 	// getIDToken retrieves id_token from cookie or header
 	GetIDToken(r *http.Request) string
 	GetTokenSource(r *http.Request) string
+
+	// GetCredential returns the credential of r. It fails if extractors found different values.
+	GetCredential(r *http.Request) (Credential, error)
+	// Route returns a TokenGetter with the precedence configured for the route name.
+	Route(name string) TokenGetter
 }
 
 type TokenSetter interface {
 	SetTokenIdentifier(w http.ResponseWriter, val string)
 	SetIDToken(w http.ResponseWriter, val string)
 	SetTokenSource(w http.ResponseWriter, val string)
+	// ClearToken removes credentials of r from the client.
+	ClearToken(w http.ResponseWriter, r *http.Request)
+}
+
+// SessionRemover is a TokenCookie keeping credentials in a session, which is deleted with them.
+type SessionRemover interface {
+	RemoveSession(w http.ResponseWriter, r *http.Request)
 }
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+
+	"go.elastic.co/apm/v2"
+)
+
+// ApmTracer sends spans to Elastic APM. Server spans, and spans without a transaction in
+// context, start transactions, so that the postgresapm gorm driver reports queries under them.
+type ApmTracer struct {
+	tracer *apm.Tracer
+}
+
+// NewApmTracer creates ApmTracer of tracer, which is configured by ELASTIC_APM_* variables.
+func NewApmTracer(tracer *apm.Tracer) *ApmTracer {
+	return &ApmTracer{
+		tracer: tracer,
+	}
+}
+
+func (t *ApmTracer) Start(ctx context.Context, name string, kind Kind) (context.Context, Span) {
+	if kind != KindServer && apm.TransactionFromContext(ctx) != nil {
+		spanType := "app"
+		if kind == KindClient {
+			spanType = "external.http"
+		}
+		span, ctx := apm.StartSpan(ctx, name, spanType)
+		return ctx, &apmSpan{ctx: ctx, span: span}
+	}
+
+	var opts apm.TransactionOptions
+	if parent, ok := ParentFromContext(ctx); ok {
+		opts.TraceContext = apm.TraceContext{
+			Trace:   apm.TraceID(parent.TraceID),
+			Span:    apm.SpanID(parent.SpanID),
+			Options: apm.TraceOptions(0).WithRecorded(parent.Sampled),
+		}
+	}
+	txType := "request"
+	if kind != KindServer {
+		txType = "background"
+	}
+	tx := t.tracer.StartTransactionOptions(name, txType, opts)
+	ctx = apm.ContextWithTransaction(ctx, tx)
+	return ctx, &apmSpan{ctx: ctx, tx: tx}
+}
+
+// apmSpan is either a transaction or a span of one.
+type apmSpan struct {
+	ctx  context.Context
+	tx   *apm.Transaction
+	span *apm.Span
+}
+
+func (s *apmSpan) SpanContext() SpanContext {
+	var tc apm.TraceContext
+	if s.span != nil {
+		tc = s.span.TraceContext()
+	} else {
+		tc = s.tx.TraceContext()
+	}
+	return SpanContext{
+		TraceID: tc.Trace,
+		SpanID:  tc.Span,
+		Sampled: tc.Options.Recorded(),
+	}
+}
+
+func (s *apmSpan) SetAttribute(key, value string) {
+	if s.span != nil {
+		if !s.span.Dropped() {
+			s.span.Context.SetLabel(key, value)
+		}
+		return
+	}
+	if s.tx.Sampled() {
+		s.tx.Context.SetLabel(key, value)
+	}
+}
+
+func (s *apmSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	apm.CaptureError(s.ctx, err).Send()
+	if s.span != nil {
+		if !s.span.Dropped() {
+			s.span.Outcome = "failure"
+		}
+		return
+	}
+	s.tx.Outcome = "failure"
+}
+
+func (s *apmSpan) End() {
+	if s.span != nil {
+		s.span.End()
+		return
+	}
+	s.tx.End()
+}
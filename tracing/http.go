@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Middleware starts a server span of every request, named by its method and route template.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				name = tpl
+			}
+		}
+
+		ctx, span := StartKind(Extract(r.Context(), r.Header), r.Method+" "+name, KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", name)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", strconv.Itoa(sw.status))
+	})
+}
+
+// statusWriter keeps the status code written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// NewTransport starts a client span of every round trip made through next, and injects it
+// as traceparent.
+func NewTransport(next http.RoundTripper) http.RoundTripper {
+	return &transport{next: next}
+}
+
+type transport struct {
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := StartKind(r.Context(), r.Method+" "+r.URL.Host, KindClient)
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.url", r.URL.Scheme+"://"+r.URL.Host+r.URL.Path)
+
+	// a RoundTripper must not modify the request
+	r = r.Clone(ctx)
+	Inject(ctx, r.Header)
+
+	res, err := t.next.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		return res, err
+	}
+	span.SetAttribute("http.status_code", strconv.Itoa(res.StatusCode))
+	return res, nil
+}
+
+// NewClient wraps the transport of client with NewTransport and returns client.
+func NewClient(client *http.Client) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = NewTransport(next)
+	return client
+}
@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"context"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OtlpTracer exports spans with the OpenTelemetry SDK to a collector with OTLP/HTTP. Spans without a
+// parent are sampled by ratio, the others follow their parent. OTEL_EXPORTER_OTLP_* variables
+// configure the exporter further, like headers or certificates.
+type OtlpTracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// NewOtlpTracer creates OtlpTracer exporting to endpoint, like http://localhost:4318/v1/traces.
+// ratio is the fraction of traces started here which are sampled, all of them if it is not in (0, 1).
+func NewOtlpTracer(ctx context.Context, endpoint, serviceName string, ratio float64) (*OtlpTracer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Path != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if ratio > 0 && ratio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(ratio)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName))),
+	)
+	return &OtlpTracer{
+		provider: provider,
+		tracer:   provider.Tracer("github.com/w-woong/auth"),
+	}, nil
+}
+
+// Start starts a child of the span of ctx, or of the remote span Extract put in ctx.
+func (t *OtlpTracer) Start(ctx context.Context, name string, kind Kind) (context.Context, Span) {
+	spanKind := trace.SpanKindInternal
+	switch kind {
+	case KindServer:
+		spanKind = trace.SpanKindServer
+	case KindClient:
+		spanKind = trace.SpanKindClient
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(spanKind))
+	return ctx, &otlpSpan{span: span}
+}
+
+// Flush exports queued spans.
+func (t *OtlpTracer) Flush(ctx context.Context) error {
+	return t.provider.ForceFlush(ctx)
+}
+
+// Shutdown exports queued spans and stops exporting.
+func (t *OtlpTracer) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+type otlpSpan struct {
+	span trace.Span
+}
+
+func (s *otlpSpan) SpanContext() SpanContext {
+	sc := s.span.SpanContext()
+	return SpanContext{
+		TraceID: sc.TraceID(),
+		SpanID:  sc.SpanID(),
+		Sampled: sc.IsSampled(),
+	}
+}
+
+func (s *otlpSpan) SetAttribute(key, value string) {
+	s.span.SetAttributes(attribute.String(key, value))
+}
+
+func (s *otlpSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *otlpSpan) End() {
+	s.span.End()
+}
@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// propagator reads and writes w3c traceparent and tracestate.
+var propagator = propagation.TraceContext{}
+
+// Extract returns ctx carrying the remote span of traceparent in header, and its tracestate.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	ctx = propagator.Extract(ctx, propagation.HeaderCarrier(header))
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ctx
+	}
+	return ContextWithRemote(ctx, SpanContext{
+		TraceID: sc.TraceID(),
+		SpanID:  sc.SpanID(),
+		Sampled: sc.IsSampled(),
+	})
+}
+
+// Inject sets traceparent of the span of ctx to header, and tracestate received from upstream.
+func Inject(ctx context.Context, header http.Header) {
+	sc, ok := ParentFromContext(ctx)
+	if !ok {
+		return
+	}
+	config := trace.SpanContextConfig{
+		TraceID: sc.TraceID,
+		SpanID:  sc.SpanID,
+	}
+	if sc.Sampled {
+		config.TraceFlags = trace.FlagsSampled
+	}
+	if upstream := trace.SpanContextFromContext(ctx); upstream.TraceID() == sc.TraceID {
+		config.TraceState = upstream.TraceState()
+	}
+	propagator.Inject(trace.ContextWithSpanContext(ctx, trace.NewSpanContext(config)),
+		propagation.HeaderCarrier(header))
+}
+
+// InjectGrpc returns ctx sending traceparent and tracestate of the span of ctx as grpc metadata,
+// for connections without tracing interceptors.
+func InjectGrpc(ctx context.Context) context.Context {
+	header := http.Header{}
+	Inject(ctx, header)
+	for name := range header {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(name), header.Get(name))
+	}
+	return ctx
+}
@@ -0,0 +1,113 @@
+// Package tracing creates spans of handlers and use cases and propagates them to outgoing calls
+// as w3c trace context. Spans are exported by the Tracer set with SetTracer, OtlpTracer or
+// ApmTracer, and dropped otherwise.
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+// Kind is the role of a span in a trace. Values are those of OTLP.
+type Kind int
+
+const (
+	KindInternal Kind = iota + 1
+	KindServer
+	KindClient
+)
+
+// SpanContext identifies a span across processes.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc has both trace and span id.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Span is an operation of a trace. It must be ended.
+type Span interface {
+	SpanContext() SpanContext
+	SetAttribute(key, value string)
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans. The parent of a span is the span of ctx, or the remote span extracted
+// from an incoming request.
+type Tracer interface {
+	Start(ctx context.Context, name string, kind Kind) (context.Context, Span)
+}
+
+var (
+	mu     sync.RWMutex
+	tracer Tracer = noopTracer{}
+)
+
+// SetTracer replaces the tracer spans are started with.
+func SetTracer(t Tracer) {
+	mu.Lock()
+	defer mu.Unlock()
+	tracer = t
+}
+
+// Start starts an internal span named name.
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	return StartKind(ctx, name, KindInternal)
+}
+
+// StartKind starts a span of kind named name.
+func StartKind(ctx context.Context, name string, kind Kind) (context.Context, Span) {
+	mu.RLock()
+	t := tracer
+	mu.RUnlock()
+
+	ctx, span := t.Start(ctx, name, kind)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the current span of ctx, nil if there is none.
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// ContextWithRemote returns ctx carrying sc, the parent span of another process.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// ParentFromContext returns the span context new spans of ctx are children of.
+func ParentFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		sc := span.SpanContext()
+		return sc, sc.IsValid()
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// noopTracer records nothing, but passes the remote span context through so that
+// downstream services stay in the same trace.
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, kind Kind) (context.Context, Span) {
+	sc, _ := ParentFromContext(ctx)
+	return ctx, noopSpan{sc: sc}
+}
+
+type noopSpan struct {
+	sc SpanContext
+}
+
+func (s noopSpan) SpanContext() SpanContext     { return s.sc }
+func (noopSpan) SetAttribute(key, value string) {}
+func (noopSpan) RecordError(err error)          {}
+func (noopSpan) End()                           {}
@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPropagation(t *testing.T) {
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Set("tracestate", "vendor=value")
+	ctx := Extract(context.Background(), header)
+	sc, ok := ParentFromContext(ctx)
+	if !ok || !sc.Sampled || hex.EncodeToString(sc.TraceID[:]) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("Extract() = %v, %v", sc, ok)
+	}
+
+	// spans which are not recorded pass the remote span through
+	ctx, span := Start(ctx, "TokenUsc.Exchange")
+	span.End()
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	if outgoing.Get("traceparent") != header.Get("traceparent") || outgoing.Get("tracestate") != "vendor=value" {
+		t.Errorf("Inject() = %v", outgoing)
+	}
+
+	md, _ := metadata.FromOutgoingContext(InjectGrpc(ctx))
+	if got := md.Get("traceparent"); len(got) != 1 || got[0] != header.Get("traceparent") {
+		t.Errorf("InjectGrpc() = %v", md)
+	}
+
+	for _, invalid := range []string{"", "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01"} {
+		header := http.Header{}
+		header.Set("traceparent", invalid)
+		if _, ok := ParentFromContext(Extract(context.Background(), header)); ok {
+			t.Errorf("Extract(%v) is ok", invalid)
+		}
+	}
+}
+
+func TestOtlpTracer(t *testing.T) {
+	var mu sync.Mutex
+	var exported []*tracepb.Span
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body := coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(b, &body); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range body.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				exported = append(exported, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	otlpTracer, err := NewOtlpTracer(context.Background(), collector.URL+"/v1/traces", "auth", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTracer(otlpTracer)
+	defer SetTracer(noopTracer{})
+
+	var traceparent, tracestate string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		tracestate = r.Header.Get("tracestate")
+	}))
+	defer upstream.Close()
+	client := NewClient(&http.Client{})
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(r.Context(), "TokenUsc.Exchange")
+		defer span.End()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, upstream.URL, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/v1/auth/callback/google", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set("tracestate", "vendor=value")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || tracestate != "vendor=value" {
+		t.Errorf("traceparent = %v, tracestate = %v", traceparent, tracestate)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := otlpTracer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(exported) != 3 {
+		t.Fatalf("exported %v spans, want 3", len(exported))
+	}
+	for _, span := range exported {
+		if hex.EncodeToString(span.TraceId) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("trace id of %v = %x", span.Name, span.TraceId)
+		}
+	}
+}
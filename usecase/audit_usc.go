@@ -0,0 +1,131 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/tracing"
+	"github.com/w-woong/common"
+)
+
+const (
+	defaultAuditLimit     = 50
+	maxAuditLimit         = 500
+	defaultAuditQueueSize = 1024
+)
+
+// auditUsc stores events and writes them to sinks in the background, in the order they are recorded.
+type auditUsc struct {
+	txBeginner common.TxBeginner
+	repo       port.AuditRepo
+	sinks      []port.AuditSink
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan entity.AuditEvent
+	done   chan struct{}
+}
+
+// NewAuditUsc creates auditUsc queueing up to queueSize events, 1024 if 0. Close flushes the queue.
+func NewAuditUsc(txBeginner common.TxBeginner, repo port.AuditRepo, queueSize int, sinks ...port.AuditSink) *auditUsc {
+	if queueSize <= 0 {
+		queueSize = defaultAuditQueueSize
+	}
+	u := &auditUsc{
+		txBeginner: txBeginner,
+		repo:       repo,
+		sinks:      sinks,
+		queue:      make(chan entity.AuditEvent, queueSize),
+		done:       make(chan struct{}),
+	}
+	go u.run()
+	return u
+}
+
+// Record queues event. It is dropped when the queue is full or closed, requests never wait for it.
+func (u *auditUsc) Record(ctx context.Context, event entity.AuditEvent) {
+	_, span := tracing.Start(ctx, "AuditUsc.Record")
+	defer span.End()
+	now := time.Now()
+	event.CreatedAt = &now
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if u.closed {
+		logger.Error("audit " + event.Type + " is dropped, the audit log is closed")
+		return
+	}
+	select {
+	case u.queue <- event:
+	default:
+		logger.Error("audit " + event.Type + " is dropped, the audit queue is full")
+	}
+}
+
+func (u *auditUsc) run() {
+	defer close(u.done)
+	for event := range u.queue {
+		u.write(event)
+	}
+}
+
+func (u *auditUsc) write(event entity.AuditEvent) {
+	id, err := u.create(context.Background(), event)
+	if err != nil {
+		logger.Error("audit " + event.Type + " is not stored: " + err.Error())
+	}
+	event.ID = id
+	for _, sink := range u.sinks {
+		if err := sink.Write(event); err != nil {
+			logger.Error("audit " + event.Type + " is not written: " + err.Error())
+		}
+	}
+}
+
+func (u *auditUsc) create(ctx context.Context, event entity.AuditEvent) (int64, error) {
+	tx, err := u.txBeginner.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := u.repo.Create(ctx, tx, event)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// Close stops queueing events and returns once queued ones are stored and written, or ctx is done.
+func (u *auditUsc) Close(ctx context.Context) error {
+	u.mu.Lock()
+	if !u.closed {
+		u.closed = true
+		close(u.queue)
+	}
+	u.mu.Unlock()
+
+	select {
+	case <-u.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Find finds events by filter, limiting them to maxAuditLimit.
+func (u *auditUsc) Find(ctx context.Context, filter port.AuditFilter) ([]entity.AuditEvent, error) {
+	ctx, span := tracing.Start(ctx, "AuditUsc.Find")
+	defer span.End()
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditLimit
+	}
+	if filter.Limit > maxAuditLimit {
+		filter.Limit = maxAuditLimit
+	}
+	return u.repo.Find(ctx, filter)
+}
@@ -1,43 +1,95 @@
 package usecase
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-wonk/si/sicore"
 	"github.com/go-wonk/si/sihttp"
+	"github.com/w-woong/auth/authutil"
 	"github.com/w-woong/auth/conv"
 	"github.com/w-woong/auth/dto"
 	"github.com/w-woong/auth/entity"
 	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/tracing"
 	"github.com/w-woong/common"
-	commondto "github.com/w-woong/common/dto"
+)
+
+var (
+	ErrSignalSecretEmpty      = errors.New("signal hmac secret is not configured")
+	ErrSignalInvalidSignature = errors.New("invalid signal signature")
+	ErrSignalNotPending       = errors.New("auth request is not pending in this cluster")
+	ErrPollSecretMismatch     = errors.New("poll secret does not match")
+	ErrSignalStale            = errors.New("signal timestamp is missing or stale")
+)
+
+const (
+	pollSecretSize = 32
+
+	// SignalTimestampHeader carries the unix time a signal is signed at.
+	SignalTimestampHeader = "X-Signal-Timestamp"
+	// signalMaxAge bounds how far the timestamp of a signal may be from now, replays are rejected after it.
+	signalMaxAge = time.Minute
 )
 
 type AuthRequest struct {
 	responseUrl string
 	authUrl     string
+	clusterID   string
+	hmacHeader  string
+
+	secretMu   sync.RWMutex
+	hmacSecret string
 
 	txBeginner  common.RWTxBeginner
 	authRequest port.AuthRequestRepo
 	client      *sihttp.Client
 }
 
-func NewAuthRequest(responseUrl, authUrl string, txBeginner common.RWTxBeginner, authRequest port.AuthRequestRepo) *AuthRequest {
+// NewAuthRequest creates AuthRequest usecase. Tokens sent by Signal are signed with hmacSecret
+// in hmacHeader, and VerifySignal only accepts requests created by the same clusterID.
+func NewAuthRequest(responseUrl, authUrl, clusterID, hmacHeader, hmacSecret string,
+	txBeginner common.RWTxBeginner, authRequest port.AuthRequestRepo) *AuthRequest {
 	return &AuthRequest{
 		responseUrl: responseUrl,
 		authUrl:     authUrl,
+		clusterID:   clusterID,
+		hmacHeader:  hmacHeader,
+		hmacSecret:  hmacSecret,
 		txBeginner:  txBeginner,
 		authRequest: authRequest,
-		client: sihttp.NewClient(sihttp.DefaultInsecureClient(),
+		client: sihttp.NewClient(tracing.NewClient(sihttp.DefaultInsecureClient()),
 			sihttp.WithWriterOpt(sicore.SetJsonEncoder()),
 			sihttp.WithReaderOpt(sicore.SetJsonDecoder())),
 	}
 }
 
-func (u *AuthRequest) Save(ctx context.Context, id string) (dto.AuthRequest, error) {
+// SetHmacSecret replaces the secret signals are signed, and verified, with from now on.
+func (u *AuthRequest) SetHmacSecret(hmacSecret string) {
+	u.secretMu.Lock()
+	defer u.secretMu.Unlock()
+	u.hmacSecret = hmacSecret
+}
+
+func (u *AuthRequest) secret() string {
+	u.secretMu.RLock()
+	defer u.secretMu.RUnlock()
+	return u.hmacSecret
+}
+
+// Save creates an auth request started by clientID on deviceID, which wants to be redirected to
+// redirectUri after authorization.
+func (u *AuthRequest) Save(ctx context.Context, id, clientID, redirectUri, deviceID string) (dto.AuthRequest, error) {
+	ctx, span := tracing.Start(ctx, "AuthRequest.Save")
+	defer span.End()
 	tx, err := u.txBeginner.Begin()
 	if err != nil {
 		return dto.NilAuthRequest, err
@@ -49,10 +101,20 @@ func (u *AuthRequest) Save(ctx context.Context, id string) (dto.AuthRequest, err
 		return dto.NilAuthRequest, errors.New("client request id exists")
 	}
 
+	pollSecret, err := authutil.GenerateSecret(pollSecretSize)
+	if err != nil {
+		return dto.NilAuthRequest, err
+	}
+
 	ar := entity.AuthRequest{
 		ID:          id,
 		ResponseUrl: u.replaceByID(u.responseUrl, id),
 		AuthUrl:     u.replaceByID(u.authUrl, id),
+		ClusterID:   u.clusterID,
+		PollSecret:  authutil.HashSecret(pollSecret),
+		ClientID:    clientID,
+		RedirectUri: redirectUri,
+		DeviceID:    deviceID,
 	}
 	affected, err := u.authRequest.Create(ctx, tx, ar)
 	if err != nil {
@@ -66,10 +128,18 @@ func (u *AuthRequest) Save(ctx context.Context, id string) (dto.AuthRequest, err
 		return dto.NilAuthRequest, err
 	}
 
-	return conv.ToAuthRequestDto(&ar)
+	authRequest, err := conv.ToAuthRequestDto(&ar)
+	if err != nil {
+		return dto.NilAuthRequest, err
+	}
+	// plain poll secret is handed to the initiating client only once
+	authRequest.PollSecret = pollSecret
+	return authRequest, nil
 }
 
 func (u *AuthRequest) Find(ctx context.Context, id string) (dto.AuthRequest, error) {
+	ctx, span := tracing.Start(ctx, "AuthRequest.Find")
+	defer span.End()
 	tx, err := u.txBeginner.BeginR()
 	if err != nil {
 		return dto.NilAuthRequest, err
@@ -84,10 +154,51 @@ func (u *AuthRequest) Find(ctx context.Context, id string) (dto.AuthRequest, err
 		ID:          ar.ID,
 		ResponseUrl: ar.ResponseUrl,
 		AuthUrl:     ar.AuthUrl,
+		ClusterID:   ar.ClusterID,
+		ClientID:    ar.ClientID,
+		RedirectUri: ar.RedirectUri,
+		DeviceID:    ar.DeviceID,
+	}, tx.Commit()
+}
+
+// Claim finds auth request only if pollSecret matches the one issued by Save, and points its response
+// url to this instance, since the client may have been told to wait on another instance by a draining one.
+func (u *AuthRequest) Claim(ctx context.Context, id, pollSecret string) (dto.AuthRequest, error) {
+	ctx, span := tracing.Start(ctx, "AuthRequest.Claim")
+	defer span.End()
+	tx, err := u.txBeginner.Begin()
+	if err != nil {
+		return dto.NilAuthRequest, err
+	}
+	defer tx.Rollback()
+
+	ar, err := u.authRequest.Read(ctx, tx, id)
+	if err != nil {
+		return dto.NilAuthRequest, err
+	}
+	if !authutil.VerifySecret(pollSecret, ar.PollSecret) {
+		return dto.NilAuthRequest, ErrPollSecretMismatch
+	}
+	if responseUrl := u.replaceByID(u.responseUrl, id); ar.ResponseUrl != responseUrl {
+		if _, err = u.authRequest.UpdateResponseUrl(ctx, tx, id, responseUrl); err != nil {
+			return dto.NilAuthRequest, err
+		}
+		ar.ResponseUrl = responseUrl
+	}
+	return dto.AuthRequest{
+		ID:          ar.ID,
+		ResponseUrl: ar.ResponseUrl,
+		AuthUrl:     ar.AuthUrl,
+		ClusterID:   ar.ClusterID,
+		ClientID:    ar.ClientID,
+		RedirectUri: ar.RedirectUri,
+		DeviceID:    ar.DeviceID,
 	}, tx.Commit()
 }
 
 func (u *AuthRequest) Remove(ctx context.Context, id string) (int64, error) {
+	ctx, span := tracing.Start(ctx, "AuthRequest.Remove")
+	defer span.End()
 	tx, err := u.txBeginner.Begin()
 	if err != nil {
 		return 0, err
@@ -103,33 +214,81 @@ func (u *AuthRequest) Remove(ctx context.Context, id string) (int64, error) {
 
 }
 
-func (u *AuthRequest) Signal(ctx context.Context, id string, token commondto.Token) error {
-	tx, err := u.txBeginner.Begin()
+func (u *AuthRequest) Signal(ctx context.Context, id string, token dto.SignalToken) error {
+	ctx, span := tracing.Start(ctx, "AuthRequest.Signal")
+	defer span.End()
+	if u.secret() == "" {
+		return ErrSignalSecretEmpty
+	}
+
+	// the transaction ends before posting, the response url may be this instance which reads the
+	// auth request again to verify the signal
+	authRequest, err := u.Find(ctx, id)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
-	authRequest, err := u.authRequest.Read(ctx, tx, id)
+	body, err := json.Marshal(&token)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := u.sign(authRequest.ID, timestamp, body)
 	if err != nil {
 		return err
 	}
-
-	// _, err = u.authRequest.Delete(ctx, tx, id)
-	// if err != nil {
-	// 	return err
-	// }
 
 	url := u.replaceByID(authRequest.ResponseUrl, authRequest.ID)
 	header := make(http.Header)
 	header.Add("Content-Type", "application/json; charset=utf-8")
+	header.Set(u.hmacHeader, signature)
+	header.Set(SignalTimestampHeader, timestamp)
 	m := make(map[string]interface{})
-	err = u.client.RequestPostDecode(url, header, &token, &m)
+	return u.client.RequestPostDecodeReaderContext(ctx, url, header, bytes.NewReader(body), &m)
+}
+
+// sign signs body of a signal for auth request id at timestamp, so that it can't be replayed to another
+// auth request or later.
+func (u *AuthRequest) sign(id, timestamp string, body []byte) (string, error) {
+	return sicore.HmacSha256HexEncoded(u.secret(), append([]byte(id+"."+timestamp+"."), body...))
+}
+
+// VerifySignal checks that id, the timestamp and body are signed with the shared secret within
+// signalMaxAge, and that the auth request id is still pending and was created by this cluster.
+func (u *AuthRequest) VerifySignal(r *http.Request, id string, body []byte) error {
+	if u.secret() == "" {
+		return ErrSignalSecretEmpty
+	}
+
+	received := strings.ToLower(r.Header.Get(u.hmacHeader))
+	if received == "" {
+		return ErrSignalInvalidSignature
+	}
+	timestamp := r.Header.Get(SignalTimestampHeader)
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignalStale
+	}
+	if age := time.Since(time.Unix(signedAt, 0)); age > signalMaxAge || age < -signalMaxAge {
+		return ErrSignalStale
+	}
+	expected, err := u.sign(id, timestamp, body)
 	if err != nil {
 		return err
 	}
+	if !hmac.Equal([]byte(received), []byte(expected)) {
+		return ErrSignalInvalidSignature
+	}
+
+	authRequest, err := u.Find(r.Context(), id)
+	if err != nil {
+		return ErrSignalNotPending
+	}
+	if authRequest.ClusterID != u.clusterID {
+		return ErrSignalNotPending
+	}
 
-	return tx.Commit()
+	return nil
 }
 
 func (u *AuthRequest) replaceByID(url string, id string) string {
@@ -1,8 +1,8 @@
 package usecase
 
 import (
-	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -10,22 +10,42 @@ import (
 	"github.com/w-woong/auth/authutil"
 	"github.com/w-woong/auth/entity"
 	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/tracing"
 	"github.com/w-woong/common"
 )
 
+var (
+	ErrReturnToNotAllowed = errors.New("return_to is not allowed")
+	// ErrStateNotBound is returned when the callback is not made by the browser which started authorization.
+	ErrStateNotBound = errors.New("state is not bound to this browser")
+)
+
 type authStateUsc struct {
 	authStateTxBeginner common.TxBeginner
 	authStateRepo       port.AuthStateRepo
+	allowedReturnTo     []string
+	stateCookie         port.StateCookie
 }
 
-func NewAuthStateUsc(authStateTxBeginner common.TxBeginner, authStateRepo port.AuthStateRepo) *authStateUsc {
+// NewAuthStateUsc creates authStateUsc. allowedReturnTo lists origins with path prefixes
+// browsers may return to after authorization.
+func NewAuthStateUsc(authStateTxBeginner common.TxBeginner, authStateRepo port.AuthStateRepo,
+	allowedReturnTo []string, stateCookie port.StateCookie) *authStateUsc {
 	return &authStateUsc{
 		authStateTxBeginner: authStateTxBeginner,
 		authStateRepo:       authStateRepo,
+		allowedReturnTo:     allowedReturnTo,
+		stateCookie:         stateCookie,
 	}
 }
 
-func (u *authStateUsc) Create(ctx context.Context, authRequestID string) (entity.AuthState, error) {
+func (u *authStateUsc) Create(w http.ResponseWriter, r *http.Request, authRequestID, returnTo string) (entity.AuthState, error) {
+	ctx, span := tracing.Start(r.Context(), "AuthStateUsc.Create")
+	defer span.End()
+	if returnTo != "" && !authutil.IsAllowedRedirect(returnTo, u.allowedReturnTo) {
+		return entity.NilAuthState, ErrReturnToNotAllowed
+	}
+
 	tx, err := u.authStateTxBeginner.Begin()
 	if err != nil {
 		return entity.NilAuthState, err
@@ -39,6 +59,7 @@ func (u *authStateUsc) Create(ctx context.Context, authRequestID string) (entity
 		State:         state,
 		CodeVerifier:  codeVerifier,
 		AuthRequestID: authRequestID,
+		ReturnTo:      returnTo,
 	}
 
 	_, err = u.authStateRepo.Create(ctx, tx, authState)
@@ -50,11 +71,13 @@ func (u *authStateUsc) Create(ctx context.Context, authRequestID string) (entity
 		return entity.NilAuthState, err
 	}
 
+	u.stateCookie.SetState(w, state)
 	return authState, nil
 }
 
 func (u *authStateUsc) Verify(w http.ResponseWriter, r *http.Request) (entity.AuthState, error) {
-	ctx := r.Context()
+	ctx, span := tracing.Start(r.Context(), "AuthStateUsc.Verify")
+	defer span.End()
 	tx, err := u.authStateTxBeginner.Begin()
 	if err != nil {
 		return entity.NilAuthState, err
@@ -75,5 +98,11 @@ func (u *authStateUsc) Verify(w http.ResponseWriter, r *http.Request) (entity.Au
 	if authState.State != receivedState {
 		return entity.NilAuthState, errors.New("invalid state")
 	}
+
+	if err = u.stateCookie.VerifyState(r, receivedState); err != nil {
+		return entity.NilAuthState, fmt.Errorf("%w: %v", ErrStateNotBound, err)
+	}
+	u.stateCookie.RemoveState(w)
+
 	return authState, tx.Commit()
 }
@@ -0,0 +1,81 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/w-woong/auth/dto"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+)
+
+type healthUsc struct {
+	timeout  time.Duration
+	checks   []port.HealthCheck
+	draining int32
+}
+
+// NewHealthUsc creates healthUsc running checks concurrently, each within timeout.
+func NewHealthUsc(timeout time.Duration, checks ...port.HealthCheck) *healthUsc {
+	return &healthUsc{
+		timeout: timeout,
+		checks:  checks,
+	}
+}
+
+func (u *healthUsc) Ready(ctx context.Context) dto.Health {
+	health := dto.Health{
+		Status:   dto.HealthOk,
+		Draining: u.Draining(),
+		Checks:   make([]dto.HealthCheck, len(u.checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range u.checks {
+		wg.Add(1)
+		go func(i int, check port.HealthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, u.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Check(ctx)
+			result := dto.HealthCheck{
+				Name:      check.Name(),
+				Status:    dto.HealthOk,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				// the cause is logged only, since readiness is public
+				result.Status = dto.HealthFail
+				logger.Error("health check " + check.Name() + ": " + err.Error())
+			}
+			health.Checks[i] = result
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, check := range health.Checks {
+		if check.Status != dto.HealthOk {
+			health.Status = dto.HealthFail
+		}
+	}
+	if health.Draining {
+		health.Status = dto.HealthDraining
+	}
+	return health
+}
+
+func (u *healthUsc) SetDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&u.draining, v)
+}
+
+func (u *healthUsc) Draining() bool {
+	return atomic.LoadInt32(&u.draining) == 1
+}
@@ -0,0 +1,91 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/tracing"
+	"github.com/w-woong/common"
+)
+
+type rateLimitUsc struct {
+	txBeginner    common.TxBeginner
+	rateLimitRepo port.RateLimitRepo
+	now           func() time.Time
+}
+
+func NewRateLimitUsc(txBeginner common.TxBeginner, rateLimitRepo port.RateLimitRepo) *rateLimitUsc {
+	return &rateLimitUsc{
+		txBeginner:    txBeginner,
+		rateLimitRepo: rateLimitRepo,
+		now:           time.Now,
+	}
+}
+
+func (u *rateLimitUsc) Take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	ctx, span := tracing.Start(ctx, "RateLimitUsc.Take")
+	defer span.End()
+	if rate <= 0 || burst <= 0 {
+		return true, 0, nil
+	}
+
+	tx, err := u.txBeginner.Begin()
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	now := u.now().UnixMilli()
+	bucket, err := u.rateLimitRepo.ReadForUpdate(ctx, tx, key)
+	if err != nil {
+		if !errors.Is(err, common.ErrRecordNotFound) {
+			return false, 0, err
+		}
+		bucket = entity.RateLimitBucket{
+			Key:        key,
+			Tokens:     float64(burst),
+			RefilledAt: now,
+		}
+	}
+
+	elapsed := float64(now-bucket.RefilledAt) / 1000
+	if elapsed > 0 {
+		bucket.Tokens = math.Min(float64(burst), bucket.Tokens+elapsed*rate)
+		bucket.RefilledAt = now
+	}
+
+	allowed := bucket.Tokens >= 1
+	var retryAfter time.Duration
+	if allowed {
+		bucket.Tokens--
+	} else {
+		retryAfter = time.Duration((1 - bucket.Tokens) / rate * float64(time.Second))
+	}
+
+	if _, err = u.rateLimitRepo.Save(ctx, tx, bucket); err != nil {
+		return false, 0, err
+	}
+
+	return allowed, retryAfter, tx.Commit()
+}
+
+// Purge removes buckets idle for idleFor, by then they would have been refilled anyway.
+func (u *rateLimitUsc) Purge(ctx context.Context, idleFor time.Duration) (int64, error) {
+	ctx, span := tracing.Start(ctx, "RateLimitUsc.Purge")
+	defer span.End()
+	tx, err := u.txBeginner.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	affected, err := u.rateLimitRepo.DeleteUpdatedBefore(ctx, tx, u.now().Add(-idleFor))
+	if err != nil {
+		return 0, err
+	}
+	return affected, tx.Commit()
+}
@@ -0,0 +1,49 @@
+package usecase
+
+import (
+	"sync"
+	"time"
+
+	commondto "github.com/w-woong/common/dto"
+)
+
+// refreshGrace is how long requests presenting the id_token replaced by a refresh are given
+// the refreshed token.
+const refreshGrace = time.Minute
+
+// refreshGroup runs one refresh per key at a time in this process, callers arriving during it
+// share its result.
+type refreshGroup struct {
+	mu    sync.Mutex
+	calls map[string]*refreshCall
+}
+
+type refreshCall struct {
+	wg    sync.WaitGroup
+	token commondto.Token
+	err   error
+}
+
+func (g *refreshGroup) do(key string, fn func() (commondto.Token, error)) (commondto.Token, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*refreshCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.token, c.err
+	}
+	c := &refreshCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.token, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return c.token, c.err
+}
@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/common"
+)
+
+const (
+	// refreshLease bounds a refresh in progress, longer than a call to the provider. A lease of an
+	// instance which is gone meanwhile expires after it.
+	refreshLease = time.Minute
+	// refreshLeasePoll is how often a refresh waiting for the lease of another one checks the token.
+	refreshLeasePoll = 100 * time.Millisecond
+)
+
+var (
+	// errRefreshLeased is returned while another refresh of the token holds its lease.
+	errRefreshLeased = errors.New("token is being refreshed")
+	// errRefreshLeaseLost is returned when the lease expired and the token changed meanwhile.
+	errRefreshLeaseLost = errors.New("refresh lease is lost")
+)
+
+// takeRefreshLease leases the refresh of token id to the caller if check passes, so that instances
+// never present the same refresh token at once. The token is locked only while the lease is taken,
+// not while the provider is called.
+func takeRefreshLease(ctx context.Context, txBeginner common.TxBeginner, repo port.TokenRepo,
+	id string, check func(token entity.Token) error) (entity.Token, error) {
+	tx, err := txBeginner.Begin()
+	if err != nil {
+		return entity.Token{}, err
+	}
+	defer tx.Rollback()
+
+	token, err := repo.ReadForUpdate(ctx, tx, id)
+	if err != nil {
+		return entity.Token{}, err
+	}
+	if err = check(token); err != nil {
+		return token, err
+	}
+	now := time.Now()
+	if token.RefreshingUntil > now.Unix() {
+		return token, errRefreshLeased
+	}
+	token.RefreshingUntil = now.Add(refreshLease).Unix()
+	if _, err = repo.Update(ctx, tx, token); err != nil {
+		return entity.Token{}, err
+	}
+	return token, tx.Commit()
+}
+
+// waitRefreshLease takes the refresh lease of token id like takeRefreshLease, waiting for the
+// refresh holding it to end. check sees the token that refresh left.
+func waitRefreshLease(ctx context.Context, txBeginner common.TxBeginner, repo port.TokenRepo,
+	id string, check func(token entity.Token) error) (entity.Token, error) {
+	for {
+		token, err := takeRefreshLease(ctx, txBeginner, repo, id, check)
+		if !errors.Is(err, errRefreshLeased) {
+			return token, err
+		}
+		select {
+		case <-ctx.Done():
+			return entity.Token{}, ctx.Err()
+		case <-time.After(refreshLeasePoll):
+		}
+	}
+}
+
+// endRefreshLease applies the result of the refresh of leased, if any, and releases its lease under
+// the lock. It returns the current token with errRefreshLeaseLost if another refresh took over.
+func endRefreshLease(ctx context.Context, txBeginner common.TxBeginner, repo port.TokenRepo,
+	leased entity.Token, apply func(token *entity.Token)) (entity.Token, error) {
+	tx, err := txBeginner.Begin()
+	if err != nil {
+		return entity.Token{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := repo.ReadForUpdate(ctx, tx, leased.ID)
+	if err != nil {
+		return entity.Token{}, err
+	}
+	if current.RefreshingUntil != leased.RefreshingUntil || current.RefreshToken != leased.RefreshToken {
+		return current, errRefreshLeaseLost
+	}
+	if apply != nil {
+		apply(&current)
+	}
+	current.RefreshingUntil = 0
+	if _, err = repo.Update(ctx, tx, current); err != nil {
+		return entity.Token{}, err
+	}
+	return current, tx.Commit()
+}
@@ -0,0 +1,121 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/tracing"
+)
+
+type SecretUsc struct {
+	providers map[string]port.SecretProvider
+
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+// NewSecretUsc creates SecretUsc resolving references of the schemes of providers, like file and vault.
+func NewSecretUsc(providers map[string]port.SecretProvider) *SecretUsc {
+	return &SecretUsc{
+		providers: providers,
+		values:    make(map[string]string),
+	}
+}
+
+// provider returns the provider of the reference value, ok is false if value is not a reference.
+func (u *SecretUsc) provider(value string) (provider port.SecretProvider, ref string, ok bool, err error) {
+	scheme, ref, ok := strings.Cut(value, "://")
+	if !ok {
+		return nil, "", false, nil
+	}
+	if provider, ok = u.providers[scheme]; !ok {
+		// the scheme only, the rest may be a secret like the password of a url
+		return nil, "", true, fmt.Errorf("%w: %s", port.ErrSecretSchemeUnknown, scheme)
+	}
+	return provider, ref, true, nil
+}
+
+// Resolve caches resolved values until they are refreshed.
+func (u *SecretUsc) Resolve(ctx context.Context, value string) (string, error) {
+	provider, ref, ok, err := u.provider(value)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return value, nil
+	}
+
+	u.mu.RLock()
+	secret, ok := u.values[value]
+	u.mu.RUnlock()
+	if ok {
+		return secret, nil
+	}
+
+	ctx, span := tracing.Start(ctx, "SecretUsc.Resolve")
+	defer span.End()
+
+	secret, err = provider.Secret(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	u.mu.Lock()
+	u.values[value] = secret
+	u.mu.Unlock()
+	return secret, nil
+}
+
+// Refresh keeps the last value of references failing to resolve, and returns the last error.
+func (u *SecretUsc) Refresh(ctx context.Context) (bool, error) {
+	ctx, span := tracing.Start(ctx, "SecretUsc.Refresh")
+	defer span.End()
+
+	u.mu.RLock()
+	refs := make([]string, 0, len(u.values))
+	for value := range u.values {
+		refs = append(refs, value)
+	}
+	u.mu.RUnlock()
+
+	var lastErr error
+	changed := false
+	for _, value := range refs {
+		provider, ref, _, _ := u.provider(value)
+		secret, err := provider.Secret(ctx, ref)
+		if err != nil {
+			// the reference itself is logged, never the secret
+			logger.Error("cannot refresh secret " + value + ": " + err.Error())
+			lastErr = err
+			continue
+		}
+		u.mu.Lock()
+		// retained references only
+		if last, ok := u.values[value]; ok && last != secret {
+			u.values[value] = secret
+			changed = true
+		}
+		u.mu.Unlock()
+	}
+	return changed, lastErr
+}
+
+// Retain is called with the references of a reloaded configuration, so that removed ones are no
+// longer refreshed.
+func (u *SecretUsc) Retain(values []string) {
+	retained := make(map[string]bool, len(values))
+	for _, value := range values {
+		retained[value] = true
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for value := range u.values {
+		if !retained[value] {
+			delete(u.values, value)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/w-woong/auth/authutil"
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/common"
+)
+
+const (
+	sessionIDSize = 32
+)
+
+// sessionCookie is a port.TokenCookie which keeps token identifier, id_token and token source
+// in a session repository and sends browsers a single opaque session id cookie.
+type sessionCookie struct {
+	txBeginner  common.TxBeginner
+	sessionRepo port.SessionRepo
+	cookie      port.SessionIDCookie
+}
+
+func NewSessionCookie(txBeginner common.TxBeginner, sessionRepo port.SessionRepo, cookie port.SessionIDCookie) *sessionCookie {
+	return &sessionCookie{
+		txBeginner:  txBeginner,
+		sessionRepo: sessionRepo,
+		cookie:      cookie,
+	}
+}
+
+func (u *sessionCookie) GetTokenIdentifier(r *http.Request) string {
+	return u.find(r).TokenID
+}
+
+// SetTokenIdentifier binds tokenIdentifier to the session of this response, or to the session
+// it was bound to before. An empty tokenIdentifier removes the session cookie.
+func (u *sessionCookie) SetTokenIdentifier(w http.ResponseWriter, tokenIdentifier string) {
+	if tokenIdentifier == "" {
+		u.cookie.RemoveSessionID(w)
+		return
+	}
+	u.update(w, tokenIdentifier, func(session *entity.Session) {
+		session.TokenID = tokenIdentifier
+	})
+}
+
+func (u *sessionCookie) GetIDToken(r *http.Request) string {
+	return u.find(r).IDToken
+}
+
+func (u *sessionCookie) SetIDToken(w http.ResponseWriter, idToken string) {
+	if idToken == "" {
+		u.cookie.RemoveSessionID(w)
+		return
+	}
+	u.update(w, "", func(session *entity.Session) {
+		session.IDToken = idToken
+	})
+}
+
+func (u *sessionCookie) GetTokenSource(r *http.Request) string {
+	return string(u.find(r).TokenSource)
+}
+
+func (u *sessionCookie) SetTokenSource(w http.ResponseWriter, tokenSource string) {
+	if tokenSource == "" {
+		u.cookie.RemoveSessionID(w)
+		return
+	}
+	u.update(w, "", func(session *entity.Session) {
+		session.TokenSource = entity.TokenSource(tokenSource)
+	})
+}
+
+// RemoveSession deletes the session of r and removes its id cookie.
+func (u *sessionCookie) RemoveSession(w http.ResponseWriter, r *http.Request) {
+	u.cookie.RemoveSessionID(w)
+	sessionID := u.cookie.GetSessionID(r)
+	if sessionID == "" {
+		return
+	}
+
+	tx, err := u.txBeginner.Begin()
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err = u.sessionRepo.Delete(r.Context(), tx, sessionID); err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// Purge deletes expired sessions.
+func (u *sessionCookie) Purge(ctx context.Context) (int64, error) {
+	tx, err := u.txBeginner.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	affected, err := u.sessionRepo.DeleteExpired(ctx, tx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return affected, tx.Commit()
+}
+
+func (u *sessionCookie) find(r *http.Request) entity.Session {
+	sessionID := u.cookie.GetSessionID(r)
+	if sessionID == "" {
+		return entity.NilSession
+	}
+	session, err := u.sessionRepo.ReadNoTx(r.Context(), sessionID)
+	if err != nil {
+		return entity.NilSession
+	}
+	if session.ExpiresAt < time.Now().Unix() {
+		return entity.NilSession
+	}
+	return session
+}
+
+// update applies fn to the session already set on w. Otherwise it reuses the session of tokenID
+// or creates a new one, and sets its id cookie.
+func (u *sessionCookie) update(w http.ResponseWriter, tokenID string, fn func(session *entity.Session)) {
+	ctx := context.Background()
+	tx, err := u.txBeginner.Begin()
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	session := entity.NilSession
+	if sessionID := u.cookie.GetSessionIDFromResponse(w); sessionID != "" {
+		session, err = u.sessionRepo.Read(ctx, tx, sessionID)
+	} else if tokenID != "" {
+		session, err = u.sessionRepo.ReadByTokenID(ctx, tx, tokenID)
+	}
+	if err != nil && !errors.Is(err, common.ErrRecordNotFound) {
+		logger.Error(err.Error())
+		return
+	}
+	if session.ID == "" {
+		if session.ID, err = authutil.GenerateSecret(sessionIDSize); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+	}
+
+	fn(&session)
+	session.ExpiresAt = time.Now().Add(u.cookie.ExpireAfter()).Unix()
+	if _, err = u.sessionRepo.Save(ctx, tx, session); err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	if u.cookie.GetSessionIDFromResponse(w) != session.ID {
+		u.cookie.SetSessionID(w, session.ID)
+	}
+}
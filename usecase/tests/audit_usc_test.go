@@ -0,0 +1,114 @@
+package usecase_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/usecase"
+	"github.com/w-woong/common"
+	"github.com/w-woong/common/txcom"
+)
+
+func Test_auditUsc_Find(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := adapter.NewAuditFileSink(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	auditUsc := usecase.NewAuditUsc(txcom.NewLockTxBeginner(), adapter.NewMapAudit(), 0, sink)
+	for i := 0; i < 5; i++ {
+		auditUsc.Record(ctx, entity.AuditEvent{Type: entity.AuditLogin, Subject: "sub1", Outcome: entity.AuditSuccess})
+	}
+	auditUsc.Record(ctx, entity.AuditEvent{Type: entity.AuditRefresh, Subject: "sub1", Outcome: entity.AuditFailure})
+	auditUsc.Record(ctx, entity.AuditEvent{Type: entity.AuditLogin, Subject: "sub2", Outcome: entity.AuditSuccess})
+	if err := auditUsc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// dropped once closed
+	auditUsc.Record(ctx, entity.AuditEvent{Type: entity.AuditLogin, Subject: "sub2", Outcome: entity.AuditSuccess})
+
+	page, err := auditUsc.Find(ctx, port.AuditFilter{Subject: "sub1", Type: entity.AuditLogin, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 3 || page[0].ID != 5 {
+		t.Fatalf("Find() = %+v", page)
+	}
+	page, _ = auditUsc.Find(ctx, port.AuditFilter{Subject: "sub1", Type: entity.AuditLogin, Limit: 3,
+		Before: page[len(page)-1].ID})
+	if len(page) != 2 || page[1].ID != 1 {
+		t.Fatalf("Find() of the next page = %+v", page)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+		event := entity.AuditEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Type == "" || event.CreatedAt == nil ||
+			event.ID != int64(lines+1) {
+			t.Errorf("line %v = %s, %v", lines, scanner.Text(), err)
+		}
+	}
+	if lines != 7 {
+		t.Errorf("lines = %v, want 7", lines)
+	}
+}
+
+// blockingTxBeginner blocks Begin until release is closed.
+type blockingTxBeginner struct {
+	common.TxBeginner
+	release chan struct{}
+}
+
+func (b *blockingTxBeginner) Begin() (common.TxController, error) {
+	<-b.release
+	return b.TxBeginner.Begin()
+}
+
+func Test_auditUsc_Record_Async(t *testing.T) {
+	ctx := context.Background()
+	txBeginner := &blockingTxBeginner{TxBeginner: txcom.NewLockTxBeginner(), release: make(chan struct{})}
+	repo := adapter.NewMapAudit()
+	auditUsc := usecase.NewAuditUsc(txBeginner, repo, 2)
+
+	// the first is taken by the writer, two are queued and the rest are dropped, without waiting
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			auditUsc.Record(ctx, entity.AuditEvent{Type: entity.AuditLogin, Subject: "sub1", Outcome: entity.AuditSuccess})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Record() waits for the repository")
+	}
+
+	close(txBeginner.release)
+	if err := auditUsc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	events, err := repo.Find(ctx, port.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) < 2 || len(events) > 3 {
+		t.Errorf("stored %d events, want the queued ones", len(events))
+	}
+}
@@ -0,0 +1,141 @@
+package usecase_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-wonk/si/sicore"
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/usecase"
+	"github.com/w-woong/common/txcom"
+)
+
+func Test_authRequestUsc_VerifySignal(t *testing.T) {
+	secret := "secret"
+	header := "X-Signature-Sha256"
+	repo := adapter.NewMapAuthRequest()
+	authRequestUsc := usecase.NewAuthRequest("https://localhost/{auth_request_id}", "https://localhost/{auth_request_id}",
+		"cluster-a", header, secret,
+		txcom.NewLockTxBeginner(), repo)
+	otherUsc := usecase.NewAuthRequest("https://localhost/{auth_request_id}", "https://localhost/{auth_request_id}",
+		"cluster-b", header, secret,
+		txcom.NewLockTxBeginner(), repo)
+
+	ctx := context.Background()
+	if _, err := authRequestUsc.Save(ctx, "id1", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherUsc.Save(ctx, "id2", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authRequestUsc.Save(ctx, "id4", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"tid":"1234"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	sign := func(secret, id, timestamp string) string {
+		signature, _ := sicore.HmacSha256HexEncoded(secret, []byte(id+"."+timestamp+"."+string(body)))
+		return signature
+	}
+
+	tests := []struct {
+		name      string
+		id        string
+		timestamp string
+		signature string
+		wantErr   error
+	}{
+		{"valid", "id1", now, sign(secret, "id1", now), nil},
+		{"missing signature", "id1", now, "", usecase.ErrSignalInvalidSignature},
+		{"forged signature", "id1", now, sign("wrong", "id1", now), usecase.ErrSignalInvalidSignature},
+		{"replayed to another request", "id4", now, sign(secret, "id1", now), usecase.ErrSignalInvalidSignature},
+		{"missing timestamp", "id1", "", sign(secret, "id1", ""), usecase.ErrSignalStale},
+		{"stale", "id1", stale, sign(secret, "id1", stale), usecase.ErrSignalStale},
+		{"unknown request", "id3", now, sign(secret, "id3", now), usecase.ErrSignalNotPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/auth/request/google/"+tt.id, bytes.NewReader(body))
+			r.Header.Set(header, tt.signature)
+			r.Header.Set(usecase.SignalTimestampHeader, tt.timestamp)
+			if err := authRequestUsc.VerifySignal(r, tt.id, body); err != tt.wantErr {
+				t.Errorf("VerifySignal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// requests of another cluster are not pending here even if they share the repository
+	r := httptest.NewRequest("POST", "/v1/auth/request/google/id2", bytes.NewReader(body))
+	r.Header.Set(header, sign(secret, "id2", now))
+	r.Header.Set(usecase.SignalTimestampHeader, now)
+	if err := authRequestUsc.VerifySignal(r, "id2", body); err != usecase.ErrSignalNotPending {
+		t.Errorf("VerifySignal() error = %v, wantErr %v", err, usecase.ErrSignalNotPending)
+	}
+}
+
+func Test_authRequestUsc_Save_PollSecret(t *testing.T) {
+	authRequestUsc := usecase.NewAuthRequest("https://localhost/{auth_request_id}", "https://localhost/{auth_request_id}",
+		"cluster-a", "X-Signature-Sha256", "secret",
+		txcom.NewLockTxBeginner(), adapter.NewMapAuthRequest())
+
+	ctx := context.Background()
+	saved, err := authRequestUsc.Save(ctx, "id1", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.PollSecret == "" {
+		t.Fatal("poll secret is empty")
+	}
+
+	if _, err = authRequestUsc.Claim(ctx, "id1", saved.PollSecret); err != nil {
+		t.Errorf("Claim() error = %v", err)
+	}
+	if _, err = authRequestUsc.Claim(ctx, "id1", ""); err != usecase.ErrPollSecretMismatch {
+		t.Errorf("Claim() error = %v, wantErr %v", err, usecase.ErrPollSecretMismatch)
+	}
+
+	found, err := authRequestUsc.Find(ctx, "id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.PollSecret != "" {
+		t.Error("Find() must not expose poll secret")
+	}
+}
+
+func Test_authRequestUsc_Claim(t *testing.T) {
+	repo := adapter.NewMapAuthRequest()
+	draining := usecase.NewAuthRequest("https://a.local/{auth_request_id}", "https://a.local/{auth_request_id}",
+		"cluster-a", "X-Signature-Sha256", "secret", txcom.NewLockTxBeginner(), repo)
+	other := usecase.NewAuthRequest("https://b.local/{auth_request_id}", "https://b.local/{auth_request_id}",
+		"cluster-a", "X-Signature-Sha256", "secret", txcom.NewLockTxBeginner(), repo)
+
+	ctx := context.Background()
+	saved, err := draining.Save(ctx, "id1", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = other.Claim(ctx, "id1", "wrong"); err != usecase.ErrPollSecretMismatch {
+		t.Errorf("Claim() error = %v, wantErr %v", err, usecase.ErrPollSecretMismatch)
+	}
+	claimed, err := other.Claim(ctx, "id1", saved.PollSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed.ResponseUrl != "https://b.local/id1" {
+		t.Errorf("ResponseUrl = %v", claimed.ResponseUrl)
+	}
+	// the callback, on any instance, signals the instance which waits now
+	found, _ := draining.Find(ctx, "id1")
+	if found.ResponseUrl != "https://b.local/id1" {
+		t.Errorf("ResponseUrl of Find() = %v", found.ResponseUrl)
+	}
+}
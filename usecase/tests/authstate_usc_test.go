@@ -0,0 +1,69 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/usecase"
+	"github.com/w-woong/common/txcom"
+)
+
+func Test_authStateUsc_Verify(t *testing.T) {
+	stateCookie := adapter.NewStateCookie(adapter.DefaultCookiePolicy(), "auth_state", []byte("secret"))
+	authStateUsc := usecase.NewAuthStateUsc(txcom.NewLockTxBeginner(), adapter.NewMapAuthState(),
+		[]string{"https://woong.com/app/"}, stateCookie)
+
+	// the attacker starts authorization in their own browser
+	w := httptest.NewRecorder()
+	attackerState, err := authStateUsc.Create(w, httptest.NewRequest(http.MethodGet, "/", nil), "ar1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and the victim starts another one
+	w = httptest.NewRecorder()
+	victimState, err := authStateUsc.Create(w, httptest.NewRequest(http.MethodGet, "/", nil), "ar2", "https://woong.com/app/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	victimCookies := w.Result().Cookies()
+
+	// the victim's browser is fed the callback url of the attacker
+	r := httptest.NewRequest(http.MethodGet, "/v1/auth/callback/google?state="+attackerState.State, nil)
+	for _, c := range victimCookies {
+		r.AddCookie(c)
+	}
+	if _, err = authStateUsc.Verify(httptest.NewRecorder(), r); !errors.Is(err, usecase.ErrStateNotBound) {
+		t.Errorf("Verify() error = %v, want %v", err, usecase.ErrStateNotBound)
+	}
+
+	// a state is consumed by any attempt, so use another one without a cookie
+	otherState, err := authStateUsc.Create(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), "ar3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/v1/auth/callback/google?state="+otherState.State, nil)
+	if _, err = authStateUsc.Verify(httptest.NewRecorder(), r); !errors.Is(err, usecase.ErrStateNotBound) {
+		t.Errorf("Verify() without cookie error = %v, want %v", err, usecase.ErrStateNotBound)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/v1/auth/callback/google?state="+victimState.State, nil)
+	for _, c := range victimCookies {
+		r.AddCookie(c)
+	}
+	verified, err := authStateUsc.Verify(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.ReturnTo != "https://woong.com/app/home" {
+		t.Errorf("ReturnTo = %v", verified.ReturnTo)
+	}
+
+	w = httptest.NewRecorder()
+	if _, err = authStateUsc.Create(w, httptest.NewRequest(http.MethodGet, "/", nil), "ar4", "https://evil.com/"); err != usecase.ErrReturnToNotAllowed {
+		t.Errorf("Create() error = %v, want %v", err, usecase.ErrReturnToNotAllowed)
+	}
+}
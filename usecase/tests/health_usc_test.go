@@ -0,0 +1,51 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/w-woong/auth/dto"
+	"github.com/w-woong/auth/usecase"
+)
+
+type healthCheck struct {
+	name string
+	err  error
+}
+
+func (c *healthCheck) Name() string { return c.name }
+
+func (c *healthCheck) Check(ctx context.Context) error {
+	if c.err == nil {
+		return nil
+	}
+	<-ctx.Done()
+	return c.err
+}
+
+func Test_healthUsc_Ready(t *testing.T) {
+	ctx := context.Background()
+	db := &healthCheck{name: "db"}
+	usc := usecase.NewHealthUsc(10*time.Millisecond, db, &healthCheck{name: "jwks"})
+
+	if health := usc.Ready(ctx); health.Status != dto.HealthOk || len(health.Checks) != 2 {
+		t.Errorf("Ready() = %+v", health)
+	}
+
+	db.err = errors.New("connection refused")
+	health := usc.Ready(ctx)
+	if health.Status != dto.HealthFail || health.Checks[0].Status != dto.HealthFail || health.Checks[1].Status != dto.HealthOk {
+		t.Errorf("Ready() = %+v", health)
+	}
+	if health.Checks[0].LatencyMs < 10 {
+		t.Errorf("LatencyMs = %v, want the timeout at least", health.Checks[0].LatencyMs)
+	}
+
+	db.err = nil
+	usc.SetDraining(true)
+	if health := usc.Ready(ctx); health.Status != dto.HealthDraining || !health.Draining {
+		t.Errorf("Ready() while draining = %+v", health)
+	}
+}
@@ -0,0 +1,44 @@
+package usecase_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/usecase"
+	"github.com/w-woong/common/txcom"
+)
+
+func Test_rateLimitUsc_Take(t *testing.T) {
+	rateLimitUsc := usecase.NewRateLimitUsc(txcom.NewLockTxBeginner(), adapter.NewMapRateLimit())
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		allowed, _, err := rateLimitUsc.Take(ctx, "ip:request:127.0.0.1", 0.001, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	allowed, retryAfter, err := rateLimitUsc.Take(ctx, "ip:request:127.0.0.1", 0.001, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Fatal("request over burst should be limited")
+	}
+	if retryAfter <= 0 {
+		t.Errorf("retryAfter = %v, want positive", retryAfter)
+	}
+
+	allowed, _, err = rateLimitUsc.Take(ctx, "ip:request:127.0.0.2", 0.001, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Error("another key should have its own bucket")
+	}
+}
@@ -0,0 +1,79 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/usecase"
+)
+
+type secretProvider struct {
+	values map[string]string
+	calls  int
+}
+
+func (p *secretProvider) Secret(ctx context.Context, ref string) (string, error) {
+	p.calls++
+	value, ok := p.values[ref]
+	if !ok {
+		return "", port.ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func Test_secretUsc_Resolve(t *testing.T) {
+	ctx := context.Background()
+	provider := &secretProvider{values: map[string]string{"auth#client_secret": "s1"}}
+	usc := usecase.NewSecretUsc(map[string]port.SecretProvider{"vault": provider})
+
+	if got, err := usc.Resolve(ctx, "plain"); err != nil || got != "plain" {
+		t.Errorf("Resolve(plain) = %q, %v", got, err)
+	}
+	// a reference of a scheme without provider is never taken as the secret
+	for _, value := range []string{"exec:///usr/local/bin/secret client_secret", "https://woong.com"} {
+		if got, err := usc.Resolve(ctx, value); !errors.Is(err, port.ErrSecretSchemeUnknown) || got != "" {
+			t.Errorf("Resolve(%q) = %q, %v, want %v", value, got, err, port.ErrSecretSchemeUnknown)
+		}
+	}
+	if _, err := usc.Resolve(ctx, "vault://auth#missing"); err != port.ErrSecretNotFound {
+		t.Errorf("Resolve(missing) = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if got, err := usc.Resolve(ctx, "vault://auth#client_secret"); err != nil || got != "s1" {
+			t.Fatalf("Resolve() = %q, %v", got, err)
+		}
+	}
+	if provider.calls != 2 {
+		t.Errorf("provider is called %d times, want the second resolve cached", provider.calls)
+	}
+
+	if changed, err := usc.Refresh(ctx); err != nil || changed {
+		t.Errorf("Refresh() = %v, %v, want unchanged", changed, err)
+	}
+	provider.values["auth#client_secret"] = "s2"
+	if changed, err := usc.Refresh(ctx); err != nil || !changed {
+		t.Errorf("Refresh() = %v, %v, want changed", changed, err)
+	}
+	if got, _ := usc.Resolve(ctx, "vault://auth#client_secret"); got != "s2" {
+		t.Errorf("Resolve() after refresh = %q", got)
+	}
+
+	// the last value stays when refreshing fails
+	delete(provider.values, "auth#client_secret")
+	if _, err := usc.Refresh(ctx); err == nil {
+		t.Error("Refresh() should report the failure")
+	}
+	if got, _ := usc.Resolve(ctx, "vault://auth#client_secret"); got != "s2" {
+		t.Errorf("Resolve() after failed refresh = %q", got)
+	}
+
+	// references removed from the configuration are no longer refreshed
+	usc.Retain([]string{"plain"})
+	provider.calls = 0
+	if changed, err := usc.Refresh(ctx); err != nil || changed || provider.calls != 0 {
+		t.Errorf("Refresh() after Retain() = %v, %v, %d calls", changed, err, provider.calls)
+	}
+}
@@ -0,0 +1,79 @@
+package usecase_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/usecase"
+	"github.com/w-woong/common/txcom"
+)
+
+func Test_sessionCookie(t *testing.T) {
+	sessionCookie := usecase.NewSessionCookie(txcom.NewLockTxBeginner(), adapter.NewMapSession(),
+		adapter.NewSessionIDCookie(adapter.DefaultCookiePolicy(), "sid"))
+
+	w := httptest.NewRecorder()
+	sessionCookie.SetTokenIdentifier(w, "tid1")
+	sessionCookie.SetIDToken(w, "idtoken1")
+	sessionCookie.SetTokenSource(w, "google")
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "sid" {
+		t.Fatalf("cookies = %v, want a single sid cookie", cookies)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "sid", Value: cookies[0].Value})
+	if got := sessionCookie.GetTokenIdentifier(r); got != "tid1" {
+		t.Errorf("GetTokenIdentifier() = %v, want tid1", got)
+	}
+	if got := sessionCookie.GetIDToken(r); got != "idtoken1" {
+		t.Errorf("GetIDToken() = %v, want idtoken1", got)
+	}
+	if got := sessionCookie.GetTokenSource(r); got != "google" {
+		t.Errorf("GetTokenSource() = %v, want google", got)
+	}
+
+	// setting the same token identifier again keeps the session
+	w = httptest.NewRecorder()
+	sessionCookie.SetTokenIdentifier(w, "tid1")
+	sessionCookie.SetIDToken(w, "idtoken2")
+	if got := w.Result().Cookies(); len(got) != 1 || got[0].Value != cookies[0].Value {
+		t.Errorf("session id changed to %v", got)
+	}
+	if got := sessionCookie.GetIDToken(r); got != "idtoken2" {
+		t.Errorf("GetIDToken() = %v, want idtoken2", got)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "sid", Value: "forged"})
+	if got := sessionCookie.GetIDToken(r); got != "" {
+		t.Errorf("GetIDToken() of unknown session = %v", got)
+	}
+}
+
+func Test_sessionCookie_ClearToken(t *testing.T) {
+	sessionRepo := adapter.NewMapSession()
+	sessionCookie := usecase.NewSessionCookie(txcom.NewLockTxBeginner(), sessionRepo,
+		adapter.NewSessionIDCookie(adapter.DefaultCookiePolicy(), "sid"))
+	tokenSetter := usecase.NewTokenSetter(sessionCookie, adapter.NewNopTokenHeader())
+
+	w := httptest.NewRecorder()
+	tokenSetter.SetTokenIdentifier(w, "tid1")
+	tokenSetter.SetIDToken(w, "idtoken1")
+	sessionID := w.Result().Cookies()[0].Value
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "sid", Value: sessionID})
+	w = httptest.NewRecorder()
+	tokenSetter.ClearToken(w, r)
+
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("cookies = %v, want the sid cookie removed", cookies)
+	}
+	if _, err := sessionRepo.ReadNoTx(r.Context(), sessionID); err == nil {
+		t.Error("the session is not deleted")
+	}
+}
@@ -0,0 +1,58 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/usecase"
+)
+
+func Test_tokenGetter_GetCredential(t *testing.T) {
+	extractors := []port.CredentialExtractor{
+		adapter.NewTokenCookieExtractor("header", adapter.NewTokenHeader("tid", "id_token", "token_source")),
+		adapter.NewBearerExtractor(),
+		adapter.NewQueryExtractor("tid", "id_token", "token_source"),
+	}
+	tokenGetter, err := usecase.NewTokenGetter(extractors, []string{"header", "bearer"},
+		map[string][]string{"ws": {"query"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("tid", "t1")
+	r.Header.Set("Authorization", "Bearer idtoken1")
+	cred, err := tokenGetter.GetCredential(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.TokenIdentifier != "t1" || cred.IDToken != "idtoken1" {
+		t.Errorf("GetCredential() = %+v", cred)
+	}
+
+	r.Header.Set("id_token", "idtoken2")
+	if _, err = tokenGetter.GetCredential(r); !errors.Is(err, usecase.ErrCredentialConflict) {
+		t.Errorf("GetCredential() error = %v, want %v", err, usecase.ErrCredentialConflict)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/?tid=t2", nil)
+	if tid := tokenGetter.Route("ws").GetTokenIdentifier(r); tid != "" {
+		t.Errorf("GetTokenIdentifier() without upgrade = %v", tid)
+	}
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	if tid := tokenGetter.Route("ws").GetTokenIdentifier(r); tid != "t2" {
+		t.Errorf("GetTokenIdentifier() = %v, want t2", tid)
+	}
+	if tid := tokenGetter.GetTokenIdentifier(r); tid != "" {
+		t.Errorf("GetTokenIdentifier() on default chain = %v", tid)
+	}
+
+	if _, err = usecase.NewTokenGetter(extractors, []string{"form"}, nil); err == nil {
+		t.Error("NewTokenGetter() with unknown extractor should fail")
+	}
+}
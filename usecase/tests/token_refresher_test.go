@@ -0,0 +1,113 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/usecase"
+	"github.com/w-woong/common"
+	"github.com/w-woong/common/txcom"
+	"golang.org/x/oauth2"
+)
+
+type refreshingTokenUsc struct {
+	port.TokenUsc
+}
+
+func (u *refreshingTokenUsc) Refresh(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
+	if token.RefreshToken == "revoked" {
+		return nil, errors.New("invalid_grant")
+	}
+	return &oauth2.Token{AccessToken: "new_" + token.AccessToken, Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func Test_tokenRefresher_Refresh(t *testing.T) {
+	ctx := context.Background()
+	repo := adapter.NewMapToken()
+	soon := time.Now().Add(time.Minute).Unix()
+	repo.Create(ctx, nil, entity.Token{ID: "t1", AccessToken: "a1", RefreshToken: "r1", IDToken: "i1", Expiry: soon})
+	repo.Create(ctx, nil, entity.Token{ID: "t2", AccessToken: "a2", RefreshToken: "revoked", Expiry: soon})
+	repo.Create(ctx, nil, entity.Token{ID: "t3", AccessToken: "a3", Expiry: soon})
+	repo.Create(ctx, nil, entity.Token{ID: "t4", AccessToken: "a4", RefreshToken: "r4", Expiry: time.Now().Add(time.Hour).Unix()})
+
+	refresher := usecase.NewTokenRefresher(&refreshingTokenUsc{}, txcom.NewLockTxBeginner(), repo,
+		5*time.Minute, 10, 2)
+
+	for i := 0; i < 3; i++ {
+		refreshed, err := refresher.Refresh(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && refreshed != 1 {
+			t.Errorf("Refresh() = %v, want 1", refreshed)
+		}
+	}
+
+	t1, _ := repo.ReadNoTx(ctx, "t1")
+	if t1.AccessToken != "new_a1" || t1.RefreshToken != "r1" || t1.IDToken != "i1" {
+		t.Errorf("t1 = %+v", t1)
+	}
+	t2, _ := repo.ReadNoTx(ctx, "t2")
+	if t2.RefreshFailures != 2 {
+		t.Errorf("t2.RefreshFailures = %v, want 2", t2.RefreshFailures)
+	}
+	t4, _ := repo.ReadNoTx(ctx, "t4")
+	if t4.AccessToken != "a4" {
+		t.Errorf("t4 should not be refreshed")
+	}
+}
+
+// A token leased by a refresh in progress is skipped, and the lock is not held while the provider
+// is called.
+func Test_tokenRefresher_Refresh_Lease(t *testing.T) {
+	ctx := context.Background()
+	txBeginner := adapter.NewMapTxBeginner()
+	repo := adapter.NewMapToken()
+	soon := time.Now().Add(time.Minute).Unix()
+	repo.Create(ctx, nil, entity.Token{ID: "t1", AccessToken: "a1", RefreshToken: "r1", Expiry: soon,
+		RefreshingUntil: time.Now().Add(time.Minute).Unix()})
+	repo.Create(ctx, nil, entity.Token{ID: "t2", AccessToken: "a2", RefreshToken: "r2", Expiry: soon})
+
+	tokenUsc := &lockCheckingTokenUsc{txBeginner: txBeginner}
+	refresher := usecase.NewTokenRefresher(tokenUsc, txBeginner, repo, 5*time.Minute, 10, 2)
+	refreshed, err := refresher.Refresh(ctx)
+	if err != nil || refreshed != 1 {
+		t.Fatalf("Refresh() = %v, %v, want 1", refreshed, err)
+	}
+	if tokenUsc.locked {
+		t.Error("the token is locked while the provider is called")
+	}
+	if t1, _ := repo.ReadNoTx(ctx, "t1"); t1.AccessToken != "a1" {
+		t.Errorf("t1 refreshed under the lease of another refresh: %+v", t1)
+	}
+	if t2, _ := repo.ReadNoTx(ctx, "t2"); t2.AccessToken != "new_a2" || t2.RefreshingUntil != 0 {
+		t.Errorf("t2 = %+v", t2)
+	}
+}
+
+type lockCheckingTokenUsc struct {
+	refreshingTokenUsc
+	txBeginner common.TxBeginner
+	locked     bool
+}
+
+func (u *lockCheckingTokenUsc) Refresh(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
+	done := make(chan struct{})
+	go func() {
+		if tx, err := u.txBeginner.Begin(); err == nil {
+			tx.Rollback()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		u.locked = true
+	}
+	return u.refreshingTokenUsc.Refresh(ctx, token)
+}
@@ -2,137 +2,383 @@ package usecase_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/w-woong/auth/adapter"
+	"github.com/w-woong/auth/authtest"
 	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/port"
 	"github.com/w-woong/auth/usecase"
 	commonadapter "github.com/w-woong/common/adapter"
+	commondto "github.com/w-woong/common/dto"
+	"github.com/w-woong/common/txcom"
 	"github.com/w-woong/common/utils"
 	"golang.org/x/oauth2"
 )
 
-func Test_tokenUsc_Revoke(t *testing.T) {
-
-	oauthConfig := oauth2.Config{
-		ClientID:     "",
-		ClientSecret: "",
-		RedirectURL:  "https://localhost:5558/v1/auth/callback/google",
-		Scopes:       []string{},
-		// Endpoint:     google.Endpoint,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:   "https://accounts.google.com/o/oauth2/auth",
-			TokenURL:  "https://oauth2.googleapis.com/token",
-			AuthStyle: oauth2.AuthStyleInParams,
-		},
+func newProviderTokenUsc(t *testing.T, p *authtest.Provider) *usecase.TokenUsc {
+	t.Helper()
+	openIDConf, err := utils.GetOpenIDConfig(p.DiscoveryURL())
+	if err != nil {
+		t.Fatal(err)
 	}
-	openIDConf, _ := utils.GetOpenIDConfig("https://accounts.google.com/.well-known/openid-configuration")
-	jwksUrl, _ := utils.GetJwksUrl("https://accounts.google.com/.well-known/openid-configuration")
+	jwksUrl, _ := utils.GetJwksUrl(p.DiscoveryURL())
 	jwksStore, _ := utils.NewJwksCache(jwksUrl)
 
 	validator := commonadapter.NewJwksIDTokenValidator(jwksStore,
 		"token_source", "tid", "id_token")
 
-	tokenUsc := usecase.NewTokenUsc(nil, nil,
-		entity.TokenSource("google"), openIDConf, &oauthConfig,
-		validator, nil)
+	return usecase.NewTokenUsc(nil, nil,
+		entity.TokenSource("test"), openIDConf, p.OauthConfig("https://localhost:5558/v1/auth/callback/test"),
+		validator, nil, 0, 0, false)
+}
 
-	o := &oauth2.Token{
-		AccessToken:  "",
-		RefreshToken: "",
-		TokenType:    "Bearer",
-		Expiry:       time.Unix(1674729287, 0),
-	}
+func Test_tokenUsc_Revoke(t *testing.T) {
+	p := authtest.NewProvider("client1", "secret1")
+	defer p.Close()
+	tokenUsc := newProviderTokenUsc(t, p)
 
-	extra := make(map[string]interface{})
-	extra["id_token"] = ""
-	o = o.WithExtra(extra)
-	tokenUsc.Revoke(context.Background(), o)
+	o := p.Token()
+	if err := tokenUsc.Revoke(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	if revoked := p.Revoked(); len(revoked) != 1 || revoked[0] != o.RefreshToken {
+		t.Errorf("Revoked() = %v, want %v", revoked, o.RefreshToken)
+	}
 }
 
 func Test_tokenUsc_Refresh(t *testing.T) {
+	p := authtest.NewProvider("client1", "secret1")
+	defer p.Close()
+	tokenUsc := newProviderTokenUsc(t, p)
+
+	o := p.Token()
+	no, err := tokenUsc.Refresh(context.Background(), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if no.AccessToken == o.AccessToken || no.Extra("id_token") == nil {
+		t.Errorf("Refresh() = %+v", no)
+	}
+}
+
+func Test_tokenUsc_Userinfo(t *testing.T) {
+	p := authtest.NewProvider("client1", "secret1")
+	defer p.Close()
+	tokenUsc := newProviderTokenUsc(t, p)
+
+	if err := tokenUsc.Userinfo(context.Background(), p.Token()); err != nil {
+		t.Error(err)
+	}
+	unknown := &oauth2.Token{AccessToken: "unknown", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+	if err := tokenUsc.Userinfo(context.Background(), unknown); err == nil {
+		t.Error("Userinfo() of an unknown access token should fail")
+	}
+}
+
+func Test_tokenUsc_RefreshToken(t *testing.T) {
+	ctx := context.Background()
+	var calls int32
+	fail := int32(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			http.Error(w, `{"error":"server_error"}`, http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"a2","token_type":"Bearer","expires_in":3600,"id_token":"i2"}`)
+	}))
+	defer srv.Close()
 
 	oauthConfig := oauth2.Config{
-		ClientID:     "",
-		ClientSecret: "",
-		RedirectURL:  "https://localhost:5558/v1/auth/callback/google",
-		Scopes:       []string{},
-		// Endpoint:     google.Endpoint,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:   "https://accounts.google.com/o/oauth2/auth",
-			TokenURL:  "https://oauth2.googleapis.com/token",
-			AuthStyle: oauth2.AuthStyleInParams,
-		},
+		Endpoint: oauth2.Endpoint{TokenURL: srv.URL, AuthStyle: oauth2.AuthStyleInParams},
 	}
+	repo := adapter.NewMapToken()
+	repo.Create(ctx, nil, entity.Token{ID: "t1", AccessToken: "a1", RefreshToken: "r1", IDToken: "i1",
+		Expiry: time.Now().Unix()})
+	tokenUsc := usecase.NewTokenUsc(txcom.NewLockTxBeginner(), repo,
+		entity.TokenSource("google"), nil, &oauthConfig, nil, nil, 0, 0, false)
 
-	openIDConf, _ := utils.GetOpenIDConfig("https://accounts.google.com/.well-known/openid-configuration")
-	jwksUrl, _ := utils.GetJwksUrl("https://accounts.google.com/.well-known/openid-configuration")
-	jwksStore, _ := utils.NewJwksCache(jwksUrl)
+	// the provider fails, the stored token is left as is
+	atomic.StoreInt32(&fail, 1)
+	if _, err := tokenUsc.RefreshToken(ctx, "t1", "i1"); err == nil {
+		t.Fatal("RefreshToken() should fail")
+	}
+	if stored, _ := repo.ReadNoTx(ctx, "t1"); stored.AccessToken != "a1" || stored.RefreshToken != "r1" {
+		t.Errorf("stored token = %+v", stored)
+	}
+	atomic.StoreInt32(&fail, 0)
+	atomic.StoreInt32(&calls, 0)
 
-	validator := commonadapter.NewJwksIDTokenValidator(jwksStore,
-		"token_source", "tid", "id_token")
-	tokenUsc := usecase.NewTokenUsc(nil, nil,
-		entity.TokenSource("google"), openIDConf, &oauthConfig,
-		validator, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refreshed, err := tokenUsc.RefreshToken(ctx, "t1", "i1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if refreshed.ID != "t1" || refreshed.IDToken != "i2" {
+				t.Errorf("RefreshToken() = %+v", refreshed)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("provider called %v times, want 1", calls)
+	}
 
-	o := &oauth2.Token{
-		AccessToken:  "",
-		RefreshToken: "",
-		TokenType:    "Bearer",
-		// Expiry:       time.Unix(1674729287, 0),
-		Expiry: time.Unix(167472, 0),
+	// a request racing the refresh with the old id_token gets the refreshed token
+	refreshed, err := tokenUsc.RefreshToken(ctx, "t1", "i1")
+	if err != nil || refreshed.IDToken != "i2" {
+		t.Errorf("RefreshToken() with previous id_token = %+v, %v", refreshed, err)
 	}
+	stored, _ := repo.ReadNoTx(ctx, "t1")
+	if stored.RefreshToken != "r1" || stored.AccessToken != "a2" {
+		t.Errorf("stored token = %+v", stored)
+	}
+}
 
-	extra := make(map[string]interface{})
-	extra["id_token"] = ""
-	o = o.WithExtra(extra)
-	no, err := tokenUsc.Refresh(context.Background(), o)
-	if err != nil {
-		fmt.Println(err)
+func Test_tokenUsc_RefreshToken_Unlocked(t *testing.T) {
+	ctx := context.Background()
+	txBeginner := adapter.NewMapTxBeginner()
+	var idToken string
+	var blocked int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the token is not locked while the provider is called
+		done := make(chan struct{})
+		go func() {
+			if tx, err := txBeginner.Begin(); err == nil {
+				tx.Rollback()
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			atomic.StoreInt32(&blocked, 1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"a2","token_type":"Bearer","expires_in":3600`+idToken+`}`)
+	}))
+	defer srv.Close()
+
+	oauthConfig := oauth2.Config{
+		Endpoint: oauth2.Endpoint{TokenURL: srv.URL, AuthStyle: oauth2.AuthStyleInParams},
+	}
+	repo := adapter.NewMapToken()
+	repo.Create(ctx, nil, entity.Token{ID: "t1", AccessToken: "a1", RefreshToken: "r1", IDToken: "i1",
+		Expiry: time.Now().Unix()})
+	tokenUsc := usecase.NewTokenUsc(txBeginner, repo,
+		entity.TokenSource("google"), nil, &oauthConfig, nil, nil, 0, 0, false)
+
+	// the refreshed tokens are kept with the previous id_token
+	if _, err := tokenUsc.RefreshToken(ctx, "t1", "i1"); !errors.Is(err, port.ErrIDTokenNotIssued) {
+		t.Errorf("RefreshToken() without id_token error = %v, want %v", err, port.ErrIDTokenNotIssued)
+	}
+	stored, _ := repo.ReadNoTx(ctx, "t1")
+	if stored.AccessToken != "a2" || stored.RefreshToken != "r1" || stored.IDToken != "i1" {
+		t.Errorf("stored token = %+v", stored)
+	}
+	// and the provider is not asked again before the refreshed access token expires
+	idToken = `,"id_token":"i2"`
+	if _, err := tokenUsc.RefreshToken(ctx, "t1", "i1"); !errors.Is(err, port.ErrIDTokenNotIssued) {
+		t.Errorf("RefreshToken() again error = %v, want %v", err, port.ErrIDTokenNotIssued)
+	}
+
+	stored.Expiry = time.Now().Unix()
+	repo.Update(ctx, nil, stored)
+	refreshed, err := tokenUsc.RefreshToken(ctx, "t1", "i1")
+	if err != nil || refreshed.IDToken != "i2" {
+		t.Errorf("RefreshToken() = %+v, %v", refreshed, err)
+	}
+	if atomic.LoadInt32(&blocked) == 1 {
+		t.Error("the token is locked while the provider is called")
 	}
-	fmt.Println(no)
 }
 
-func Test_tokenUsc_Userinfo(t *testing.T) {
+// Instances sharing a repository refresh a token once, the refresh token rotated by one is never
+// presented again by another.
+func Test_tokenUsc_RefreshToken_Instances(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var calls int
+	refreshToken := "r1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		if r.FormValue("refresh_token") != refreshToken {
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		refreshToken = fmt.Sprintf("r%d", calls+1)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"a2","refresh_token":"`+refreshToken+`","token_type":"Bearer","expires_in":3600,"id_token":"i2"}`)
+	}))
+	defer srv.Close()
 
 	oauthConfig := oauth2.Config{
-		ClientID:     "",
-		ClientSecret: "",
-		RedirectURL:  "https://localhost:5558/v1/auth/callback/google",
-		Scopes:       []string{},
-		// Endpoint:     google.Endpoint,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:   "https://accounts.google.com/o/oauth2/auth",
-			TokenURL:  "https://oauth2.googleapis.com/token",
-			AuthStyle: oauth2.AuthStyleInParams,
+		Endpoint: oauth2.Endpoint{TokenURL: srv.URL, AuthStyle: oauth2.AuthStyleInParams},
+	}
+	txBeginner := txcom.NewLockTxBeginner()
+	repo := adapter.NewMapToken()
+	repo.Create(ctx, nil, entity.Token{ID: "t1", AccessToken: "a1", RefreshToken: "r1", IDToken: "i1",
+		Expiry: time.Now().Unix()})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		tokenUsc := usecase.NewTokenUsc(txBeginner, repo,
+			entity.TokenSource("google"), nil, &oauthConfig, nil, nil, 0, 0, false)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refreshed, err := tokenUsc.RefreshToken(ctx, "t1", "i1")
+			if err != nil || refreshed.IDToken != "i2" {
+				t.Errorf("RefreshToken() = %+v, %v", refreshed, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("provider called %v times, want 1", calls)
+	}
+	if stored, _ := repo.ReadNoTx(ctx, "t1"); stored.RefreshToken != "r2" || stored.RefreshingUntil != 0 {
+		t.Errorf("stored token = %+v", stored)
+	}
+}
+
+func Test_tokenUsc_RefreshToken_NoRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	repo := adapter.NewMapToken()
+	repo.Create(ctx, nil, entity.Token{ID: "t1", AccessToken: "a1", IDToken: "i1", Expiry: time.Now().Unix()})
+	tokenUsc := usecase.NewTokenUsc(txcom.NewLockTxBeginner(), repo,
+		entity.TokenSource("google"), nil, &oauth2.Config{}, nil, nil, 0, 0, false)
+
+	if _, err := tokenUsc.RefreshToken(ctx, "t1", "i1"); !errors.Is(err, port.ErrRefreshRejected) {
+		t.Errorf("RefreshToken() without refresh token error = %v, want %v", err, port.ErrRefreshRejected)
+	}
+}
+
+type claimsValidator struct {
+	claims commondto.IDTokenClaims
+}
+
+func (v *claimsValidator) Validate(idToken string) (*jwt.Token, *commondto.IDTokenClaims, error) {
+	return nil, &v.claims, nil
+}
+
+func Test_tokenUsc_SaveToken(t *testing.T) {
+	ctx := context.Background()
+	claims := commondto.IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  "sub1",
+			Issuer:   "https://accounts.google.com",
+			Audience: jwt.ClaimStrings{"client1"},
 		},
+		Email: "wonk@woong.com",
+	}
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "sub1", "sid": "sid1", "email": "wonk@woong.com",
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	openIDConf, _ := utils.GetOpenIDConfig("https://accounts.google.com/.well-known/openid-configuration")
-	jwksUrl, _ := utils.GetJwksUrl("https://accounts.google.com/.well-known/openid-configuration")
-	jwksStore, _ := utils.NewJwksCache(jwksUrl)
+	repo := adapter.NewMapToken()
+	tokenUsc := usecase.NewTokenUsc(txcom.NewLockTxBeginner(), repo,
+		entity.TokenSource("google"), nil, &oauth2.Config{}, &claimsValidator{claims: claims}, nil, 0, 0, false)
 
-	validator := commonadapter.NewJwksIDTokenValidator(jwksStore,
-		"token_source", "tid", "id_token")
-	tokenUsc := usecase.NewTokenUsc(nil, nil,
-		entity.TokenSource("google"), openIDConf, &oauthConfig,
-		validator, nil)
-
-	o := &oauth2.Token{
-		AccessToken:  "",
-		RefreshToken: "",
-		TokenType:    "Bearer",
-		// Expiry:       time.Unix(1674729287, 0),
-		Expiry: time.Unix(1674731349, 0),
-	}
-
-	extra := make(map[string]interface{})
-	extra["id_token"] = ""
-	o = o.WithExtra(extra)
-	err := tokenUsc.Userinfo(context.Background(), o)
+	o := (&oauth2.Token{AccessToken: "a1", RefreshToken: "r1", Expiry: time.Now().Add(time.Hour)}).
+		WithExtra(map[string]interface{}{"id_token": idToken, "scope": "openid email"})
+	saved, _, err := tokenUsc.SaveToken(ctx, nil, o, "")
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
 	}
 
+	tokens, err := repo.ReadBySubject(ctx, nil, "google", "sub1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != saved.ID {
+		t.Fatalf("ReadBySubject() = %+v", tokens)
+	}
+	token := tokens[0]
+	if token.Email != "wonk@woong.com" || token.Issuer != "https://accounts.google.com" ||
+		token.Audience != "client1" || token.Scope != "openid email" || token.SID != "sid1" {
+		t.Errorf("token = %+v", token)
+	}
+	if !strings.Contains(token.Claims, `"sid":"sid1"`) {
+		t.Errorf("token.Claims = %v", token.Claims)
+	}
+
+	// logging in again without consent, the provider omits refresh token
+	o = (&oauth2.Token{AccessToken: "a2", Expiry: time.Now().Add(time.Hour)}).
+		WithExtra(map[string]interface{}{"id_token": idToken})
+	saved, _, err = tokenUsc.SaveToken(ctx, nil, o, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _ = repo.ReadNoTx(ctx, saved.ID); token.RefreshToken != "r1" {
+		t.Errorf("RefreshToken = %v, want r1", token.RefreshToken)
+	}
+	if !tokenUsc.HasOfflineToken(ctx, saved.ID) {
+		t.Error("HasOfflineToken() = false")
+	}
+	if tokenUsc.HasOfflineToken(ctx, "unknown") {
+		t.Error("HasOfflineToken() of unknown token = true")
+	}
+}
+
+func Test_tokenUsc_SaveToken_Sessions(t *testing.T) {
+	ctx := context.Background()
+	claims := commondto.IDTokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "sub1"}}
+	idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "sub1"}).
+		SignedString([]byte("secret"))
+
+	repo := adapter.NewMapToken()
+	tokenUsc := usecase.NewTokenUsc(txcom.NewLockTxBeginner(), repo,
+		entity.TokenSource("google"), nil, &oauth2.Config{}, &claimsValidator{claims: claims}, nil, 0, 2, false)
+	save := func(accessToken, device string) (string, int) {
+		o := (&oauth2.Token{AccessToken: accessToken}).WithExtra(map[string]interface{}{"id_token": idToken})
+		saved, evicted, err := tokenUsc.SaveToken(ctx, nil, o, device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return saved.ID, evicted
+	}
+
+	phone, _ := save("a1", "phone")
+	if again, evicted := save("a2", "phone"); again != phone || evicted != 0 {
+		t.Errorf("SaveToken() on the same device = %v, %v, want %v, 0", again, evicted, phone)
+	}
+	save("a3", "tablet")
+	if _, evicted := save("a4", "laptop"); evicted != 1 {
+		t.Errorf("SaveToken() evicted = %v, want 1", evicted)
+	}
+
+	tokens, _ := repo.ReadBySubject(ctx, nil, "google", "sub1")
+	if len(tokens) != 2 {
+		t.Fatalf("sessions = %v, want 2", len(tokens))
+	}
+	if _, err := repo.ReadNoTx(ctx, phone); err == nil {
+		t.Error("the oldest session should be evicted")
+	}
 }
@@ -1,45 +1,114 @@
 package usecase
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/w-woong/auth/port"
 )
 
+var (
+	ErrCredentialConflict = errors.New("conflicting credentials")
+)
+
+// tokenGetter looks for credentials through a chain of extractors. Values found earlier in the
+// chain take precedence, different values found later are rejected as a conflict.
 type tokenGetter struct {
-	cookie port.TokenCookie
-	header port.TokenCookie
+	chain  []port.CredentialExtractor
+	routes map[string][]port.CredentialExtractor
 }
 
-func NewTokenGetter(cookie port.TokenCookie, header port.TokenCookie) *tokenGetter {
+// NewTokenGetter creates tokenGetter. order names extractors of the default chain and routes
+// names them for route names.
+func NewTokenGetter(extractors []port.CredentialExtractor, order []string,
+	routes map[string][]string) (*tokenGetter, error) {
+
+	chain, err := credentialChain(extractors, order)
+	if err != nil {
+		return nil, err
+	}
+	routeChains := make(map[string][]port.CredentialExtractor)
+	for route, names := range routes {
+		if routeChains[route], err = credentialChain(extractors, names); err != nil {
+			return nil, fmt.Errorf("route %s: %w", route, err)
+		}
+	}
 	return &tokenGetter{
-		cookie: cookie,
-		header: header,
+		chain:  chain,
+		routes: routeChains,
+	}, nil
+}
+
+func credentialChain(extractors []port.CredentialExtractor, names []string) ([]port.CredentialExtractor, error) {
+	chain := make([]port.CredentialExtractor, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, e := range extractors {
+			if e.Name() == name {
+				chain = append(chain, e)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown credential extractor %s", name)
+		}
 	}
+	return chain, nil
 }
 
-func (u *tokenGetter) GetTokenIdentifier(r *http.Request) string {
-	id := u.cookie.GetTokenIdentifier(r)
-	if id != "" {
-		return id
+func (u *tokenGetter) Route(name string) port.TokenGetter {
+	chain, ok := u.routes[name]
+	if !ok {
+		return u
+	}
+	return &tokenGetter{
+		chain:  chain,
+		routes: u.routes,
 	}
-	return u.header.GetTokenIdentifier(r)
 }
 
-// getIDToken retrieves id_token from cookie or header
-func (u *tokenGetter) GetIDToken(r *http.Request) string {
-	idToken := u.cookie.GetIDToken(r)
-	if idToken != "" {
-		return idToken
+func (u *tokenGetter) GetCredential(r *http.Request) (port.Credential, error) {
+	var cred port.Credential
+	for _, e := range u.chain {
+		found := e.Extract(r)
+		if found.IsEmpty() {
+			continue
+		}
+		if !merge(&cred.TokenIdentifier, found.TokenIdentifier) ||
+			!merge(&cred.IDToken, found.IDToken) ||
+			!merge(&cred.TokenSource, found.TokenSource) {
+			return port.Credential{}, fmt.Errorf("%w: %s", ErrCredentialConflict, e.Name())
+		}
 	}
-	return u.header.GetIDToken(r)
+	return cred, nil
 }
-func (u *tokenGetter) GetTokenSource(r *http.Request) string {
-	val := u.cookie.GetTokenSource(r)
-	if val != "" {
-		return val
+
+// merge sets dst to src if dst is empty. It reports false if both are set and differ.
+func merge(dst *string, src string) bool {
+	if src == "" {
+		return true
 	}
-	return u.header.GetTokenSource(r)
+	if *dst == "" {
+		*dst = src
+		return true
+	}
+	return *dst == src
+}
+
+func (u *tokenGetter) GetTokenIdentifier(r *http.Request) string {
+	cred, _ := u.GetCredential(r)
+	return cred.TokenIdentifier
+}
+
+func (u *tokenGetter) GetIDToken(r *http.Request) string {
+	cred, _ := u.GetCredential(r)
+	return cred.IDToken
+}
+func (u *tokenGetter) GetTokenSource(r *http.Request) string {
+	cred, _ := u.GetCredential(r)
+	return cred.TokenSource
 }
 
 type tokenSetter struct {
@@ -67,3 +136,16 @@ func (u *tokenSetter) SetTokenSource(w http.ResponseWriter, val string) {
 	u.cookie.SetTokenSource(w, val)
 	u.header.SetTokenSource(w, val)
 }
+
+func (u *tokenSetter) ClearToken(w http.ResponseWriter, r *http.Request) {
+	if remover, ok := u.cookie.(port.SessionRemover); ok {
+		remover.RemoveSession(w, r)
+	} else {
+		u.cookie.SetTokenIdentifier(w, "")
+		u.cookie.SetIDToken(w, "")
+		u.cookie.SetTokenSource(w, "")
+	}
+	u.header.SetTokenIdentifier(w, "")
+	u.header.SetIDToken(w, "")
+	u.header.SetTokenSource(w, "")
+}
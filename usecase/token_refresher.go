@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/w-woong/auth/conv"
+	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/metrics"
+	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/tracing"
+	"github.com/w-woong/common"
+)
+
+// errRefreshed is returned when a token is not to be refreshed in the background any more.
+var errRefreshed = errors.New("token is refreshed")
+
+// tokenRefresher keeps offline tokens, which have a refresh token, warm in the background.
+// id_token is kept as is, because clients present the one they were given.
+type tokenRefresher struct {
+	tokenUsc        port.TokenUsc
+	tokenTxBeginner common.TxBeginner
+	tokenRepo       port.TokenRepo
+
+	window      time.Duration
+	batch       int
+	maxFailures int
+}
+
+// NewTokenRefresher creates tokenRefresher. It refreshes up to batch tokens expiring within window
+// per run, and gives up on tokens which failed maxFailures times in a row.
+func NewTokenRefresher(tokenUsc port.TokenUsc, tokenTxBeginner common.TxBeginner, tokenRepo port.TokenRepo,
+	window time.Duration, batch, maxFailures int) *tokenRefresher {
+	return &tokenRefresher{
+		tokenUsc:        tokenUsc,
+		tokenTxBeginner: tokenTxBeginner,
+		tokenRepo:       tokenRepo,
+		window:          window,
+		batch:           batch,
+		maxFailures:     maxFailures,
+	}
+}
+
+// Refresh refreshes a batch of tokens and returns the number of refreshed ones.
+func (u *tokenRefresher) Refresh(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "TokenRefresher.Refresh")
+	defer span.End()
+	expiry := time.Now().Add(u.window).Unix()
+	tokens, err := u.tokenRepo.ReadRefreshable(ctx, expiry, u.maxFailures, u.batch)
+	if err != nil {
+		return 0, err
+	}
+
+	refreshed := 0
+	for _, token := range tokens {
+		ok, err := u.refresh(ctx, token.ID, expiry)
+		if err != nil {
+			return refreshed, err
+		}
+		if ok {
+			refreshed++
+		}
+	}
+	return refreshed, nil
+}
+
+// refresh refreshes the token of id under its refresh lease, unless it has been refreshed meanwhile
+// or another refresh is in progress. The token is not locked while the provider is called.
+func (u *tokenRefresher) refresh(ctx context.Context, id string, expiry int64) (bool, error) {
+	token, err := takeRefreshLease(ctx, u.tokenTxBeginner, u.tokenRepo, id, func(token entity.Token) error {
+		if token.Expiry >= expiry {
+			return errRefreshed
+		}
+		return nil
+	})
+	if errors.Is(err, common.ErrRecordNotFound) || errors.Is(err, errRefreshed) || errors.Is(err, errRefreshLeased) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	oauth2Token, err := conv.ToTokenOauth2FromEntity(&token)
+	if err != nil {
+		endRefreshLease(ctx, u.tokenTxBeginner, u.tokenRepo, token, nil)
+		return false, err
+	}
+	newToken, refreshErr := u.tokenUsc.Refresh(ctx, oauth2Token)
+	if refreshErr != nil {
+		logger.Error("background refresh of " + token.ID + " failed: " + refreshErr.Error())
+		metrics.BackgroundRefreshes.WithLabelValues("failed").Inc()
+		metrics.RefreshFailures.WithLabelValues(string(token.TokenSource), "background").Inc()
+	} else {
+		metrics.BackgroundRefreshes.WithLabelValues("refreshed").Inc()
+	}
+
+	_, err = endRefreshLease(ctx, u.tokenTxBeginner, u.tokenRepo, token, func(token *entity.Token) {
+		if refreshErr != nil {
+			token.RefreshFailures++
+			return
+		}
+		token.AccessToken = newToken.AccessToken
+		if newToken.RefreshToken != "" {
+			token.RefreshToken = newToken.RefreshToken
+		}
+		token.TokenType = newToken.TokenType
+		token.Expiry = newToken.Expiry.Unix()
+		token.RefreshFailures = 0
+	})
+	if errors.Is(err, errRefreshLeaseLost) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return refreshErr == nil, nil
+}
@@ -2,19 +2,25 @@ package usecase
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/w-woong/auth/authutil"
 	"github.com/w-woong/auth/conv"
 	"github.com/w-woong/auth/entity"
+	"github.com/w-woong/auth/logger"
+	"github.com/w-woong/auth/metrics"
 	"github.com/w-woong/auth/port"
+	"github.com/w-woong/auth/tracing"
 	"github.com/w-woong/common"
 	commondto "github.com/w-woong/common/dto"
 	commonport "github.com/w-woong/common/port"
@@ -27,15 +33,22 @@ type TokenUsc struct {
 
 	tokenSource entity.TokenSource
 	openIDConf  map[string]interface{}
+	configMu    sync.RWMutex
 	config      *oauth2.Config
 	validator   commonport.IDTokenValidator
+	userSvc     commonport.UserSvc
 
-	userSvc commonport.UserSvc
+	refreshWindow time.Duration
+	refreshGroup  *refreshGroup
+
+	maxSessions   int
+	revokeEvicted bool
 }
 
 func NewTokenUsc(tokenTxBeginner common.TxBeginner, tokenRepo port.TokenRepo,
 	tokenSource entity.TokenSource, openIDConf map[string]interface{}, config *oauth2.Config,
 	validator commonport.IDTokenValidator, userSvc commonport.UserSvc,
+	refreshWindow time.Duration, maxSessions int, revokeEvicted bool,
 ) *TokenUsc {
 
 	return &TokenUsc{
@@ -47,30 +60,81 @@ func NewTokenUsc(tokenTxBeginner common.TxBeginner, tokenRepo port.TokenRepo,
 		tokenSource: tokenSource,
 		openIDConf:  openIDConf,
 		validator:   validator,
+
+		refreshWindow: refreshWindow,
+		refreshGroup:  &refreshGroup{},
+		maxSessions:   maxSessions,
+		revokeEvicted: revokeEvicted,
 	}
 }
 
-func (u TokenUsc) TokenSource() string {
+func (u *TokenUsc) TokenSource() string {
 	return string(u.tokenSource)
 }
 
-func (u *TokenUsc) AuthorizeCode(w http.ResponseWriter, r *http.Request, state, codeVerifier string) error {
-	url := u.config.AuthCodeURL(state,
+// SetConfig replaces the oauth2 config, for example with a rotated client secret.
+// Requests in flight keep the config they started with.
+func (u *TokenUsc) SetConfig(config *oauth2.Config) {
+	u.configMu.Lock()
+	defer u.configMu.Unlock()
+	u.config = config
+}
+
+func (u *TokenUsc) oauthConfig() *oauth2.Config {
+	u.configMu.RLock()
+	defer u.configMu.RUnlock()
+	return u.config
+}
+
+// SetUserSvc replaces the user service, for example with a rotated bearer token.
+func (u *TokenUsc) SetUserSvc(userSvc commonport.UserSvc) {
+	u.configMu.Lock()
+	defer u.configMu.Unlock()
+	u.userSvc = userSvc
+}
+
+func (u *TokenUsc) userService() commonport.UserSvc {
+	u.configMu.RLock()
+	defer u.configMu.RUnlock()
+	return u.userSvc
+}
+
+// providerClient calls the provider with trace context.
+var providerClient = tracing.NewClient(&http.Client{Timeout: 30 * time.Second})
+
+// providerContext returns ctx making oauth2 call the provider with providerClient, unless ctx
+// already carries a client.
+func providerContext(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, providerClient)
+}
+
+func (u *TokenUsc) AuthorizeCode(w http.ResponseWriter, r *http.Request, state, codeVerifier string, consent bool) error {
+	opts := []oauth2.AuthCodeOption{
 		oauth2.SetAuthURLParam("code_challenge", authutil.GenerateCodeChallenge(codeVerifier)),
 		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
 		oauth2.SetAuthURLParam("access_type", "offline"),
-		oauth2.SetAuthURLParam("prompt", "consent"))
+	}
+	if consent {
+		opts = append(opts, oauth2.SetAuthURLParam("prompt", "consent"))
+	}
+	url := u.oauthConfig().AuthCodeURL(state, opts...)
 
 	http.Redirect(w, r, url, http.StatusFound)
 	return nil
 }
 
 func (u *TokenUsc) Exchange(r *http.Request, codeVerifier string) (*oauth2.Token, error) {
-
+	ctx, span := tracing.Start(r.Context(), "TokenUsc.Exchange")
+	defer span.End()
 	var opts []oauth2.AuthCodeOption
 	opts = append(opts, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
 
-	token, err := u.config.Exchange(context.Background(), r.URL.Query().Get("code"), opts...)
+	start := time.Now()
+	token, err := u.oauthConfig().Exchange(providerContext(ctx), r.URL.Query().Get("code"), opts...)
+	metrics.ExchangeDuration.WithLabelValues(u.TokenSource(), metrics.Outcome(err)).Observe(time.Since(start).Seconds())
 	if err != nil {
 		// failed
 		return nil, err
@@ -83,43 +147,224 @@ func (u *TokenUsc) Exchange(r *http.Request, codeVerifier string) (*oauth2.Token
 }
 
 func (u *TokenUsc) ValidateIDToken(ctx context.Context, idToken string) (*jwt.Token, *commondto.IDTokenClaims, error) {
+	ctx, span := tracing.Start(ctx, "TokenUsc.ValidateIDToken")
+	defer span.End()
 	return u.validator.Validate(idToken)
 }
 
-func (u *TokenUsc) SaveToken(ctx context.Context, w http.ResponseWriter, token *oauth2.Token) (commondto.Token, error) {
+// SaveToken saves token with the claims of its id_token and registers the user of it.
+func (u *TokenUsc) SaveToken(ctx context.Context, w http.ResponseWriter, token *oauth2.Token, device string) (commondto.Token, int, error) {
+	ctx, span := tracing.Start(ctx, "TokenUsc.SaveToken")
+	defer span.End()
+	tokenEntity, err := conv.ToTokenEntityFromOauth2(token, uuid.New().String(), u.tokenSource)
+	if err != nil {
+		return commondto.NilToken, 0, err
+	}
+	if scope, ok := token.Extra("scope").(string); ok {
+		tokenEntity.Scope = scope
+	}
+	var claims *commondto.IDTokenClaims
+	if tokenEntity.IDToken != "" {
+		if claims, err = u.recordClaims(&tokenEntity); err != nil {
+			return commondto.NilToken, 0, err
+		}
+	}
+	tokenEntity.Device = device
+	if tokenEntity.Device == "" {
+		tokenEntity.Device = tokenEntity.ID
+	}
+
+	// the user service may be slow, the user is registered before sessions of the user are locked
+	if claims != nil && u.userService() != nil {
+		if tokenEntity.ID, err = u.deviceTokenID(ctx, tokenEntity); err != nil {
+			return commondto.NilToken, 0, err
+		}
+		user, err := u.RegisterUser(ctx, tokenEntity.ID, *claims)
+		if err != nil {
+			return commondto.NilToken, 0, err
+		}
+		tokenEntity.UserID = user.ID
+	}
+
 	tx, err := u.tokenTxBeginner.Begin()
 	if err != nil {
-		return commondto.NilToken, err
+		return commondto.NilToken, 0, err
 	}
 	defer tx.Rollback()
 
-	tokenEntity, err := conv.ToTokenEntityFromOauth2(token, uuid.New().String(), u.tokenSource)
-	if err != nil {
-		return commondto.NilToken, err
+	var existing *entity.Token
+	var others []entity.Token
+	if tokenEntity.Subject != "" {
+		sessions, err := u.tokenRepo.ReadBySubject(ctx, tx, u.tokenSource, tokenEntity.Subject)
+		if err != nil {
+			return commondto.NilToken, 0, err
+		}
+		for i := range sessions {
+			if sessions[i].Device == tokenEntity.Device {
+				existing = &sessions[i]
+				continue
+			}
+			others = append(others, sessions[i])
+		}
+		if tokenEntity.RefreshToken == "" {
+			// refresh tokens are issued only on consent, carry the one we hold forward
+			tokenEntity.RefreshToken = heldRefreshToken(sessions)
+		}
 	}
 
-	affected, err := u.tokenRepo.Create(ctx, tx, tokenEntity)
+	var affected int64
+	if existing != nil {
+		tokenEntity.ID = existing.ID
+		affected, err = u.tokenRepo.Update(ctx, tx, tokenEntity)
+	} else {
+		affected, err = u.tokenRepo.Create(ctx, tx, tokenEntity)
+	}
 	if err != nil {
-		return commondto.NilToken, err
+		return commondto.NilToken, 0, err
 	}
 	if affected != 1 {
-		return commondto.NilToken, errors.New("could not store token")
+		return commondto.NilToken, 0, errors.New("could not store token")
+	}
+
+	// others are the most recent first, the oldest ones are evicted
+	var evicted []entity.Token
+	if u.maxSessions > 0 && len(others) >= u.maxSessions {
+		evicted = others[u.maxSessions-1:]
+		others = others[:u.maxSessions-1]
+		for _, e := range evicted {
+			if _, err = u.tokenRepo.Delete(ctx, tx, e.ID); err != nil {
+				return commondto.NilToken, 0, err
+			}
+		}
 	}
 
 	tokenForClient, err := conv.ToTokenDto(&tokenEntity)
 	if err != nil {
-		return commondto.NilToken, err
+		return commondto.NilToken, 0, err
 	}
 
 	if err = tx.Commit(); err != nil {
-		return commondto.NilToken, err
+		return commondto.NilToken, 0, err
 	}
 
-	return tokenForClient, nil
+	if u.revokeEvicted {
+		u.revokeTokens(ctx, evicted, append(others, tokenEntity))
+	}
+	return tokenForClient, len(evicted), nil
 }
 
-func (u *TokenUsc) FindWithIDToken(ctx context.Context, id, idToken string) (*oauth2.Token, error) {
+// deviceTokenID returns the id of the stored token of the subject of token on its device, which is
+// replaced by token, or the id of token if there is none.
+func (u *TokenUsc) deviceTokenID(ctx context.Context, token entity.Token) (string, error) {
+	if token.Subject == "" {
+		return token.ID, nil
+	}
+	tx, err := u.tokenTxBeginner.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	sessions, err := u.tokenRepo.ReadBySubject(ctx, tx, u.tokenSource, token.Subject)
+	if err != nil {
+		return "", err
+	}
+	for _, session := range sessions {
+		if session.Device == token.Device {
+			return session.ID, nil
+		}
+	}
+	return token.ID, nil
+}
+
+// revokeTokens revokes tokens at the provider, except refresh tokens still used by remaining ones.
+// Providers like google revoke the whole grant with a refresh token.
+func (u *TokenUsc) revokeTokens(ctx context.Context, tokens []entity.Token, remaining []entity.Token) {
+	for _, token := range tokens {
+		if token.RefreshToken == "" || sharesRefreshToken(token, remaining) {
+			continue
+		}
+		oauth2Token, err := conv.ToTokenOauth2FromEntity(&token)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		if err = u.Revoke(ctx, oauth2Token); err != nil {
+			logger.Error("revoking " + token.ID + " failed: " + err.Error())
+		}
+	}
+}
+
+// recordClaims sets subject and the other claims of the id_token of token.
+func (u *TokenUsc) recordClaims(token *entity.Token) (*commondto.IDTokenClaims, error) {
+	_, claims, err := u.validator.Validate(token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	token.Subject = claims.Subject
+	token.Email = claims.Email
+	token.Issuer = claims.Issuer
+	token.Audience = strings.Join(claims.Audience, " ")
+
+	// the id_token has been validated above
+	mapClaims := jwt.MapClaims{}
+	if _, _, err = jwt.NewParser().ParseUnverified(token.IDToken, mapClaims); err != nil {
+		return nil, err
+	}
+	if sid, ok := mapClaims["sid"].(string); ok {
+		token.SID = sid
+	}
+	b, err := json.Marshal(mapClaims)
+	if err != nil {
+		return nil, err
+	}
+	token.Claims = string(b)
+	return claims, nil
+}
+
+// heldRefreshToken returns the refresh token of the most recent token having one.
+func heldRefreshToken(tokens []entity.Token) string {
+	for _, token := range tokens {
+		if token.RefreshToken != "" {
+			return token.RefreshToken
+		}
+	}
+	return ""
+}
 
+func sharesRefreshToken(token entity.Token, tokens []entity.Token) bool {
+	for _, t := range tokens {
+		if t.RefreshToken == token.RefreshToken {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *TokenUsc) HasOfflineToken(ctx context.Context, id string) bool {
+	ctx, span := tracing.Start(ctx, "TokenUsc.HasOfflineToken")
+	defer span.End()
+	token, err := u.tokenRepo.ReadNoTx(ctx, id)
+	if err != nil || token.TokenSource != u.tokenSource {
+		return false
+	}
+	if token.RefreshToken != "" {
+		return true
+	}
+	if token.Subject == "" {
+		return false
+	}
+	tx, err := u.tokenTxBeginner.Begin()
+	if err != nil {
+		return false
+	}
+	defer tx.Rollback()
+	tokens, err := u.tokenRepo.ReadBySubject(ctx, tx, u.tokenSource, token.Subject)
+	return err == nil && heldRefreshToken(tokens) != ""
+}
+
+func (u *TokenUsc) FindWithIDToken(ctx context.Context, id, idToken string) (*oauth2.Token, error) {
+	ctx, span := tracing.Start(ctx, "TokenUsc.FindWithIDToken")
+	defer span.End()
 	token, err := u.tokenRepo.ReadNoTx(ctx, id)
 	if err != nil {
 		return nil, err
@@ -132,6 +377,8 @@ func (u *TokenUsc) FindWithIDToken(ctx context.Context, id, idToken string) (*oa
 }
 
 func (u *TokenUsc) RemoveToken(ctx context.Context, id string) (int64, error) {
+	ctx, span := tracing.Start(ctx, "TokenUsc.RemoveToken")
+	defer span.End()
 	tx, err := u.tokenTxBeginner.Begin()
 	if err != nil {
 		return 0, err
@@ -146,9 +393,44 @@ func (u *TokenUsc) RemoveToken(ctx context.Context, id string) (int64, error) {
 	return rowsAffected, tx.Commit()
 }
 
-func (u *TokenUsc) RegisterUser(ctx context.Context, tokenID string, claims commondto.IDTokenClaims) (commondto.User, error) {
+func (u *TokenUsc) Logout(ctx context.Context, id, idToken string) error {
+	ctx, span := tracing.Start(ctx, "TokenUsc.Logout")
+	defer span.End()
+	tx, err := u.tokenTxBeginner.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	token, err := u.tokenRepo.ReadForUpdate(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if token.IDToken != idToken {
+		return common.ErrIDTokenInconsistent
+	}
+	if _, err = u.tokenRepo.Delete(ctx, tx, id); err != nil {
+		return err
+	}
+	var others []entity.Token
+	if token.Subject != "" {
+		if others, err = u.tokenRepo.ReadBySubject(ctx, tx, u.tokenSource, token.Subject); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
 
-	registeredUser, err := u.userSvc.RegisterUser(ctx, commondto.User{
+	u.revokeTokens(ctx, []entity.Token{token}, others)
+	return nil
+}
+
+func (u *TokenUsc) RegisterUser(ctx context.Context, tokenID string, claims commondto.IDTokenClaims) (commondto.User, error) {
+	ctx, span := tracing.Start(ctx, "TokenUsc.RegisterUser")
+	defer span.End()
+	start := time.Now()
+	registeredUser, err := u.userService().RegisterUser(ctx, commondto.User{
 		LoginID:     claims.Subject,
 		LoginType:   "token",
 		LoginSource: u.TokenSource(),
@@ -166,19 +448,116 @@ func (u *TokenUsc) RegisterUser(ctx context.Context, tokenID string, claims comm
 			LastName:  claims.FamilyName,
 		},
 	})
+	metrics.RegisterUserDuration.WithLabelValues(u.TokenSource()).Observe(time.Since(start).Seconds())
 	if err != nil {
+		metrics.RegisterUserErrors.WithLabelValues(u.TokenSource()).Inc()
 		return commondto.NilUser, err
 	}
 
 	return registeredUser, nil
 }
 
+// Refresh always asks the provider, even if the access token of token has not expired yet.
 func (u *TokenUsc) Refresh(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
-	return u.config.TokenSource(ctx, token).Token()
+	ctx, span := tracing.Start(ctx, "TokenUsc.Refresh")
+	defer span.End()
+	return u.oauthConfig().TokenSource(providerContext(ctx), &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
 	// refreshed := newOauthToken.AccessToken != oauthToken.AccessToken || newOauthToken.RefreshToken != oauthToken.RefreshToken
 }
 
+func (u *TokenUsc) RefreshToken(ctx context.Context, id, idToken string) (commondto.Token, error) {
+	ctx, span := tracing.Start(ctx, "TokenUsc.RefreshToken")
+	defer span.End()
+	return u.refreshGroup.do(id+"."+idToken, func() (commondto.Token, error) {
+		return u.refreshToken(ctx, id, idToken)
+	})
+}
+
+func (u *TokenUsc) refreshToken(ctx context.Context, id, idToken string) (commondto.Token, error) {
+	// refreshes of other instances are waited for under the lease, the token is not locked while
+	// the provider is called
+	token, err := waitRefreshLease(ctx, u.tokenTxBeginner, u.tokenRepo, id, func(token entity.Token) error {
+		if token.IDToken != idToken {
+			return common.ErrIDTokenInconsistent
+		}
+		// oauth2 fails without asking the provider, the token can never be refreshed
+		if token.RefreshToken == "" {
+			return fmt.Errorf("%w: no refresh token is held", port.ErrRefreshRejected)
+		}
+		// the provider would not issue one again, an expired id_token would be refreshed on every validation
+		if token.IDTokenNotIssued && time.Now().Unix() < token.Expiry {
+			return port.ErrIDTokenNotIssued
+		}
+		return nil
+	})
+	if errors.Is(err, common.ErrIDTokenInconsistent) {
+		return refreshedFrom(token, idToken)
+	}
+	if err != nil {
+		return commondto.NilToken, err
+	}
+
+	refreshedOauth2Token, err := u.Refresh(ctx, &oauth2.Token{RefreshToken: token.RefreshToken})
+	if err != nil {
+		current, endErr := endRefreshLease(ctx, u.tokenTxBeginner, u.tokenRepo, token, nil)
+		if errors.Is(endErr, errRefreshLeaseLost) && current.IDToken != idToken {
+			// the lease expired and another refresh succeeded with a rotated refresh token
+			return refreshedFrom(current, idToken)
+		}
+		var retrieveErr *oauth2.RetrieveError
+		if endErr == nil && errors.As(err, &retrieveErr) && retrieveErr.Response != nil &&
+			retrieveErr.Response.StatusCode >= 400 && retrieveErr.Response.StatusCode < 500 {
+			return commondto.NilToken, fmt.Errorf("%w: %v", port.ErrRefreshRejected, err)
+		}
+		return commondto.NilToken, err
+	}
+	// providers may not issue id_token on refresh, the tokens are kept with the previous one
+	newIDToken, _ := refreshedOauth2Token.Extra("id_token").(string)
+
+	// subject and the other claims are kept
+	refreshed, err := endRefreshLease(ctx, u.tokenTxBeginner, u.tokenRepo, token, func(refreshed *entity.Token) {
+		refreshed.AccessToken = refreshedOauth2Token.AccessToken
+		if refreshedOauth2Token.RefreshToken != "" {
+			refreshed.RefreshToken = refreshedOauth2Token.RefreshToken
+		}
+		refreshed.TokenType = refreshedOauth2Token.TokenType
+		refreshed.Expiry = refreshedOauth2Token.Expiry.Unix()
+		refreshed.RefreshFailures = 0
+		refreshed.IDTokenNotIssued = newIDToken == ""
+		if newIDToken != "" {
+			refreshed.PreviousIDToken = refreshed.IDToken
+			refreshed.IDToken = newIDToken
+		}
+	})
+	if errors.Is(err, errRefreshLeaseLost) && refreshed.IDToken != idToken {
+		return refreshedFrom(refreshed, idToken)
+	}
+	if err != nil {
+		return commondto.NilToken, err
+	}
+	if refreshed.IDTokenNotIssued {
+		return commondto.NilToken, port.ErrIDTokenNotIssued
+	}
+	return conv.ToTokenDto(&refreshed)
+}
+
+// refreshedFrom returns token if it has just been refreshed from idToken, by a request racing the
+// refresh.
+func refreshedFrom(token entity.Token, idToken string) (commondto.Token, error) {
+	if token.PreviousIDToken == idToken && token.UpdatedAt != nil &&
+		time.Since(*token.UpdatedAt) < refreshGrace {
+		return conv.ToTokenDto(&token)
+	}
+	return commondto.NilToken, common.ErrIDTokenInconsistent
+}
+
+func (u *TokenUsc) ShouldRefresh(expiresAt time.Time) bool {
+	return u.refreshWindow > 0 && time.Until(expiresAt) < u.refreshWindow
+}
+
 func (u *TokenUsc) Revoke(ctx context.Context, token *oauth2.Token) error {
+	ctx, span := tracing.Start(ctx, "TokenUsc.Revoke")
+	defer span.End()
 	revokeEndpoint, ok := u.openIDConf["revocation_endpoint"]
 	if !ok {
 		return nil
@@ -186,7 +565,7 @@ func (u *TokenUsc) Revoke(ctx context.Context, token *oauth2.Token) error {
 
 	reqBody := url.Values{}
 	reqBody.Set("token", token.RefreshToken)
-	resp, err := u.config.Client(ctx, token).Post(revokeEndpoint.(string), "application/x-www-form-urlencoded", strings.NewReader(reqBody.Encode()))
+	resp, err := u.oauthConfig().Client(providerContext(ctx), token).Post(revokeEndpoint.(string), "application/x-www-form-urlencoded", strings.NewReader(reqBody.Encode()))
 	if err != nil {
 		return err
 	}
@@ -201,15 +580,20 @@ func (u *TokenUsc) Revoke(ctx context.Context, token *oauth2.Token) error {
 }
 
 func (u *TokenUsc) Userinfo(ctx context.Context, token *oauth2.Token) error {
-	userinfoEndpoint, ok := u.openIDConf["revocation_endpoint"]
+	ctx, span := tracing.Start(ctx, "TokenUsc.Userinfo")
+	defer span.End()
+	userinfoEndpoint, ok := u.openIDConf["userinfo_endpoint"]
 	if !ok {
 		return nil
 	}
-	resp, err := u.config.Client(ctx, token).Get(userinfoEndpoint.(string))
+	resp, err := u.oauthConfig().Client(providerContext(ctx), token).Get(userinfoEndpoint.(string))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("userinfo responded " + resp.Status)
+	}
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {